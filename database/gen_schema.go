@@ -0,0 +1,19 @@
+//go:build ignore
+
+// gen_schema генерирует schema.sql из миграций в migrations.go.
+// Запуск: go generate ./database
+package main
+
+import (
+	"log"
+	"os"
+
+	"webForum/database"
+)
+
+func main() {
+	schema := database.SchemaSQL(database.DriverMySQL)
+	if err := os.WriteFile("schema.sql", []byte(schema), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Ошибки GetUploadToken и создания поста с Media.UploadToken
+var (
+	ErrUploadTokenUnknown = errors.New("неизвестный токен загрузки")
+	ErrUploadTokenUsed    = errors.New("токен загрузки уже использован")
+	ErrUploadTokenExpired = errors.New("срок действия токена загрузки истёк")
+)
+
+// ErrMediaGone запись media, прикрепляемая к посту, уже удалена сборщиком
+// неиспользуемых файлов; файл нужно загрузить заново
+var ErrMediaGone = errors.New("медиафайл удалён, загрузите его заново")
+
+// newLeaseHash ключ аренды записи media в upload_tokens: случайное
+// значение, которое никто не знает, поэтому прикрепить по нему файл нельзя
+func newLeaseHash() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// mediaColumns столбцы таблицы media в порядке scanMedia
+const mediaColumns = `id, hash, path, media_type, mime_type, thumb_path, width, height, size, ref_count, created_at`
+
+// scanMedia читает строку таблицы media
+func scanMedia(row interface{ Scan(...interface{}) error }) (*Media, error) {
+	var m Media
+	var thumbPath sql.NullString
+	var width, height sql.NullInt64
+	err := row.Scan(&m.ID, &m.Hash, &m.Path, &m.Type, &m.MIME, &thumbPath,
+		&width, &height, &m.Size, &m.RefCount, &m.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	m.ThumbPath = thumbPath.String
+	m.Width = int(width.Int64)
+	m.Height = int(height.Int64)
+	return &m, nil
+}
+
+// GetMediaByHash возвращает медиафайл по SHA-256 содержимого
+// или nil, если такого файла ещё не загружали
+func (s *SQLStore) GetMediaByHash(hash string) (*Media, error) {
+	row := s.db.QueryRow(`SELECT `+mediaColumns+` FROM media WHERE hash = ?`, hash)
+	m, err := scanMedia(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return m, err
+}
+
+// CreateMedia записывает медиафайл со счётчиком ссылок 0. Если файл с
+// таким хешем уже есть (его одновременно загрузили два клиента),
+// возвращает существующую запись.
+func (s *SQLStore) CreateMedia(m Media) (*Media, error) {
+	query := `INSERT INTO media (hash, path, media_type, mime_type, thumb_path, width, height, size) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.db.Exec(query, m.Hash, m.Path, m.Type, m.MIME,
+		nullString(m.ThumbPath), nullInt(m.Width), nullInt(m.Height), m.Size)
+	if err != nil {
+		// Нарушение уникальности hash: запись уже создана другим запросом
+		if existing, getErr := s.GetMediaByHash(m.Hash); getErr == nil && existing != nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return s.GetMediaByHash(m.Hash)
+}
+
+// LeaseMedia возвращает запись медиафайла с хешем hash и защищает её от
+// DeleteMedia до until, как действующий токен загрузки; nil - записи нет.
+// Аренда создаётся одним запросом с проверкой записи, поэтому сборщик либо
+// удалил запись раньше (вернётся nil, файл нужно сохранить заново), либо
+// уже не удалит её, пока файл прикрепляют к посту.
+func (s *SQLStore) LeaseMedia(hash string, until time.Time) (*Media, error) {
+	lease, err := newLeaseHash()
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.db.Exec(`INSERT INTO upload_tokens (token_hash, media_id, expires_at)
+		SELECT ?, id, ? FROM media WHERE hash = ?`, lease, until.UTC(), hash)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return nil, err
+	}
+	return s.GetMediaByHash(hash)
+}
+
+// ReferencedMediaPaths пути файлов (media_path и thumb_path), на которые
+// ссылается хотя бы один пост. Используется сборщиком неиспользуемых файлов.
+func (s *SQLStore) ReferencedMediaPaths() (map[string]bool, error) {
+	rows, err := s.db.Query(`SELECT media_path FROM posts WHERE media_path IS NOT NULL AND media_path <> ''
+		UNION SELECT thumb_path FROM posts WHERE thumb_path IS NOT NULL AND thumb_path <> ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := make(map[string]bool)
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		paths[p] = true
+	}
+	return paths, rows.Err()
+}
+
+// DeleteMedia удаляет запись медиафайла, если на неё не ссылается ни один
+// пост и для неё нет действующего токена загрузки. Возвращает false, если
+// запись уже используется или её нет.
+func (s *SQLStore) DeleteMedia(id int64) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM media WHERE id = ?
+		AND NOT EXISTS (SELECT 1 FROM posts WHERE media_id = ?)
+		AND NOT EXISTS (SELECT 1 FROM upload_tokens WHERE media_id = ? AND used_at IS NULL AND expires_at > ?)`,
+		id, id, id, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CreateUploadToken сохраняет токен загрузки медиафайла mediaID.
+// Хранится только SHA-256 токена, сам токен знает лишь загрузивший клиент.
+func (s *SQLStore) CreateUploadToken(tokenHash string, mediaID int64, expiresAt time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO upload_tokens (token_hash, media_id, expires_at) VALUES (?, ?, ?)`,
+		tokenHash, mediaID, expiresAt.UTC())
+	return err
+}
+
+// GetUploadToken возвращает медиафайл действующего токена, не отмечая
+// токен использованным: по нему проверяется политика доски до создания
+// поста
+func (s *SQLStore) GetUploadToken(tokenHash string) (*Media, error) {
+	var mediaID int64
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	err := s.db.QueryRow(`SELECT media_id, expires_at, used_at FROM upload_tokens WHERE token_hash = ?`, tokenHash).
+		Scan(&mediaID, &expiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrUploadTokenUnknown
+	}
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		return nil, ErrUploadTokenUsed
+	}
+	if time.Now().UTC().After(expiresAt) {
+		return nil, ErrUploadTokenExpired
+	}
+
+	m, err := scanMedia(s.db.QueryRow(`SELECT `+mediaColumns+` FROM media WHERE id = ?`, mediaID))
+	if err == sql.ErrNoRows {
+		return nil, ErrUploadTokenUnknown
+	}
+	return m, err
+}
+
+// useUploadToken отмечает токен m.UploadToken использованным в
+// транзакции создания поста. Токен используется один раз: из двух
+// одновременных запросов пост создаст только один, второй получит
+// ErrUploadTokenUsed. Если пост не создан, транзакция откатывается и токен
+// остаётся действующим.
+func useUploadToken(tx *sql.Tx, m Media) error {
+	now := time.Now().UTC()
+	res, err := tx.Exec(`UPDATE upload_tokens SET used_at = ?
+		WHERE token_hash = ? AND media_id = ? AND used_at IS NULL AND expires_at > ?`,
+		now, m.UploadToken, m.ID, now)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	var mediaID int64
+	var usedAt sql.NullTime
+	err = tx.QueryRow(`SELECT media_id, used_at FROM upload_tokens WHERE token_hash = ?`, m.UploadToken).
+		Scan(&mediaID, &usedAt)
+	switch {
+	case err == sql.ErrNoRows || err == nil && mediaID != m.ID:
+		return ErrUploadTokenUnknown
+	case err != nil:
+		return err
+	case usedAt.Valid:
+		return ErrUploadTokenUsed
+	}
+	return ErrUploadTokenExpired
+}
+
+// DeleteExpiredUploadTokens удаляет токены, истёкшие до before
+func (s *SQLStore) DeleteExpiredUploadTokens(before time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM upload_tokens WHERE expires_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
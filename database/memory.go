@@ -0,0 +1,639 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore потокобезопасная реализация Store в памяти.
+// Повторяет семантику SQL-запросов и нужна для тестов обработчиков
+// через httptest без живой БД.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	boards  map[string]Board
+	threads map[int]Thread
+	posts   map[int]Post
+	media   map[int64]Media
+	tokens  map[string]uploadToken
+
+	nextThreadID int
+	nextPostID   int
+	nextMediaID  int64
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore создаёт пустое хранилище в памяти
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		boards:       make(map[string]Board),
+		threads:      make(map[int]Thread),
+		posts:        make(map[int]Post),
+		media:        make(map[int64]Media),
+		tokens:       make(map[string]uploadToken),
+		nextThreadID: 1,
+		nextPostID:   1,
+		nextMediaID:  1,
+	}
+}
+
+// === BOARDS ===
+
+// GetAllBoards возвращает все доски, отсортированные по ID
+func (m *MemoryStore) GetAllBoards() ([]Board, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, t := range m.threads {
+		counts[t.BoardID]++
+	}
+
+	var boards []Board
+	for _, b := range m.boards {
+		b.ThreadCount = counts[b.ID]
+		boards = append(boards, b)
+	}
+	sort.Slice(boards, func(i, j int) bool {
+		return boards[i].ID < boards[j].ID
+	})
+	return boards, nil
+}
+
+// GetBoard возвращает доску по ID
+func (m *MemoryStore) GetBoard(id string) (*Board, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, ok := m.boards[id]
+	if !ok {
+		return nil, nil
+	}
+	return &b, nil
+}
+
+// CreateBoard создаёт новую доску
+func (m *MemoryStore) CreateBoard(id, name, description string, settings BoardSettings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.boards[id]; ok {
+		return fmt.Errorf("доска %s уже существует", id)
+	}
+	m.boards[id] = Board{
+		ID:          id,
+		Name:        name,
+		Description: description,
+		CreatedAt:   time.Now(),
+		Settings:    cloneBoardSettings(settings),
+	}
+	return nil
+}
+
+// UpdateBoardSettings сохраняет настройки доски
+func (m *MemoryStore) UpdateBoardSettings(id string, settings BoardSettings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.boards[id]
+	if !ok {
+		return fmt.Errorf("доска %s не найдена", id)
+	}
+	b.Settings = cloneBoardSettings(settings)
+	m.boards[id] = b
+	return nil
+}
+
+// cloneBoardSettings копия настроек, не разделяющая MediaTypes с вызывающим
+func cloneBoardSettings(settings BoardSettings) BoardSettings {
+	settings.MediaTypes = append([]string{}, settings.MediaTypes...)
+	return settings
+}
+
+// === THREADS ===
+
+// GetThreadsByBoard возвращает треды доски с сортировкой
+func (m *MemoryStore) GetThreadsByBoard(boardID, sortBy string) ([]Thread, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var threads []Thread
+	for _, t := range m.threads {
+		if t.BoardID != boardID {
+			continue
+		}
+		posts := m.threadPosts(t.ID)
+		t.PostCount = len(posts)
+		for _, p := range posts {
+			if p.MediaType.String == "image" {
+				t.ImageCount++
+			}
+		}
+		if len(posts) > 0 {
+			op := posts[0]
+			t.FirstPost = &op
+		}
+		threads = append(threads, t)
+	}
+
+	ts := threadSortFor(sortBy)
+	sort.Slice(threads, func(i, j int) bool {
+		return ts.less(&threads[i], &threads[j])
+	})
+	return threads, nil
+}
+
+// GetThreadsPage возвращает страницу тредов доски (keyset-пагинация)
+func (m *MemoryStore) GetThreadsPage(boardID, sortBy string, page PageRequest) ([]Thread, PageInfo, error) {
+	all, err := m.GetThreadsByBoard(boardID, sortBy)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	ts := threadSortFor(sortBy)
+	reverse := page.Cursor != nil && page.Cursor.Before
+	if reverse {
+		for i, j := 0, len(all)-1; i < j; i, j = i+1, j-1 {
+			all[i], all[j] = all[j], all[i]
+		}
+	}
+
+	var threads []Thread
+	for i := range all {
+		if page.Cursor != nil && !afterCursor(ts.cursor(&all[i]), page.Cursor, ts.desc) {
+			continue
+		}
+		threads = append(threads, all[i])
+		if len(threads) > page.Limit {
+			break
+		}
+	}
+
+	hasMore := len(threads) > page.Limit
+	if hasMore {
+		threads = threads[:page.Limit]
+	}
+	if reverse {
+		for i, j := 0, len(threads)-1; i < j; i, j = i+1, j-1 {
+			threads[i], threads[j] = threads[j], threads[i]
+		}
+	}
+	if len(threads) == 0 {
+		return threads, PageInfo{}, nil
+	}
+	info := pageInfo(page, len(threads), hasMore, ts.cursor(&threads[0]), ts.cursor(&threads[len(threads)-1]))
+	return threads, info, nil
+}
+
+// GetThread возвращает тред по ID
+func (m *MemoryStore) GetThread(id int) (*Thread, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.threads[id]
+	if !ok {
+		return nil, nil
+	}
+	t.PostCount = len(m.threadPosts(id))
+	return &t, nil
+}
+
+// CreateThread создаёт новый тред и возвращает его ID
+func (m *MemoryStore) CreateThread(boardID, subject string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.boards[boardID]; !ok {
+		return 0, fmt.Errorf("доска %s не существует", boardID)
+	}
+
+	now := time.Now()
+	id := m.nextThreadID
+	m.nextThreadID++
+	m.threads[id] = Thread{
+		ID:        id,
+		BoardID:   boardID,
+		Subject:   subject,
+		CreatedAt: now,
+		BumpedAt:  now,
+	}
+	return int64(id), nil
+}
+
+// CreateThreadWithOP атомарно создаёт тред и его первый пост
+func (m *MemoryStore) CreateThreadWithOP(boardID, subject, author, content string, media Media) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.boards[boardID]; !ok {
+		return 0, 0, fmt.Errorf("доска %s не существует", boardID)
+	}
+	if err := m.checkMedia(media); err != nil {
+		return 0, 0, err
+	}
+	if err := m.useUploadToken(media); err != nil {
+		return 0, 0, err
+	}
+
+	now := time.Now()
+	threadID := m.nextThreadID
+	m.nextThreadID++
+	m.threads[threadID] = Thread{
+		ID:        threadID,
+		BoardID:   boardID,
+		Subject:   subject,
+		CreatedAt: now,
+		BumpedAt:  now,
+	}
+
+	postID := m.nextPostID
+	m.nextPostID++
+	p := Post{
+		ID:        postID,
+		ThreadID:  threadID,
+		Author:    author,
+		Content:   content,
+		CreatedAt: now,
+	}
+	p.setMedia(media)
+	m.posts[postID] = p
+	m.addMediaRef(media.ID)
+	return int64(threadID), int64(postID), nil
+}
+
+// BumpThread обновляет время последнего бампа
+func (m *MemoryStore) BumpThread(threadID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.threads[threadID]
+	if !ok {
+		return nil
+	}
+	t.BumpedAt = time.Now()
+	m.threads[threadID] = t
+	return nil
+}
+
+// === POSTS ===
+
+// GetPostsByThread возвращает все посты треда
+func (m *MemoryStore) GetPostsByThread(threadID int) ([]Post, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return buildPostTree(m.threadPosts(threadID), MaxPostDepth), nil
+}
+
+// GetPostsPage возвращает страницу постов треда в хронологическом порядке
+func (m *MemoryStore) GetPostsPage(threadID int, page PageRequest) ([]Post, PageInfo, error) {
+	m.mu.RLock()
+	all := m.threadPosts(threadID)
+	m.mu.RUnlock()
+
+	reverse := page.Cursor != nil && page.Cursor.Before
+	if reverse {
+		for i, j := 0, len(all)-1; i < j; i, j = i+1, j-1 {
+			all[i], all[j] = all[j], all[i]
+		}
+	}
+
+	var posts []Post
+	for i := range all {
+		if page.Cursor != nil && !afterCursor(postCursor(&all[i]), page.Cursor, false) {
+			continue
+		}
+		posts = append(posts, all[i])
+		if len(posts) > page.Limit {
+			break
+		}
+	}
+	return postsPage(posts, page, reverse)
+}
+
+// GetFirstPost возвращает первый пост треда (OP)
+func (m *MemoryStore) GetFirstPost(threadID int) (*Post, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	posts := m.threadPosts(threadID)
+	if len(posts) == 0 {
+		return nil, nil
+	}
+	return &posts[0], nil
+}
+
+// CreatePost создаёт новый пост и возвращает его ID
+func (m *MemoryStore) CreatePost(threadID int, parentID *int, author, content string, media Media) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.threads[threadID]; !ok {
+		return 0, fmt.Errorf("тред #%d не существует", threadID)
+	}
+
+	p := Post{
+		ThreadID:  threadID,
+		Author:    author,
+		Content:   content,
+		CreatedAt: time.Now(),
+	}
+	p.setMedia(media)
+	if parentID != nil && *parentID > 0 {
+		if _, ok := m.posts[*parentID]; !ok {
+			return 0, fmt.Errorf("пост #%d не существует", *parentID)
+		}
+		p.ParentID = sql.NullInt64{Int64: int64(*parentID), Valid: true}
+	}
+	if err := m.checkMedia(media); err != nil {
+		return 0, err
+	}
+	if err := m.useUploadToken(media); err != nil {
+		return 0, err
+	}
+
+	p.ID = m.nextPostID
+	m.nextPostID++
+	m.posts[p.ID] = p
+	m.addMediaRef(media.ID)
+	return int64(p.ID), nil
+}
+
+// === MEDIA ===
+
+// GetMediaByHash возвращает медиафайл по SHA-256 содержимого
+func (m *MemoryStore) GetMediaByHash(hash string) (*Media, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, md := range m.media {
+		if md.Hash == hash {
+			return &md, nil
+		}
+	}
+	return nil, nil
+}
+
+// CreateMedia записывает медиафайл; для уже известного хеша
+// возвращает существующую запись
+func (m *MemoryStore) CreateMedia(md Media) (*Media, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.media {
+		if existing.Hash == md.Hash {
+			return &existing, nil
+		}
+	}
+	md.ID = m.nextMediaID
+	m.nextMediaID++
+	md.RefCount = 0
+	md.CreatedAt = time.Now()
+	m.media[md.ID] = md
+	return &md, nil
+}
+
+// ReferencedMediaPaths пути файлов, на которые ссылаются посты
+func (m *MemoryStore) ReferencedMediaPaths() (map[string]bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	paths := make(map[string]bool)
+	for _, p := range m.posts {
+		if p.MediaPath.String != "" {
+			paths[p.MediaPath.String] = true
+		}
+		if p.ThumbPath.String != "" {
+			paths[p.ThumbPath.String] = true
+		}
+	}
+	return paths, nil
+}
+
+// LeaseMedia возвращает медиафайл с хешем hash и защищает его от
+// DeleteMedia до until; nil - записи нет
+func (m *MemoryStore) LeaseMedia(hash string, until time.Time) (*Media, error) {
+	lease, err := newLeaseHash()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, md := range m.media {
+		if md.Hash == hash {
+			m.tokens[lease] = uploadToken{mediaID: md.ID, expiresAt: until}
+			return &md, nil
+		}
+	}
+	return nil, nil
+}
+
+// checkMedia проверяет, что запись прикрепляемого медиафайла не удалена;
+// вызывается под m.mu
+func (m *MemoryStore) checkMedia(md Media) error {
+	if md.ID == 0 {
+		return nil
+	}
+	if _, ok := m.media[md.ID]; !ok {
+		return ErrMediaGone
+	}
+	return nil
+}
+
+// DeleteMedia удаляет медиафайл, если на него не ссылается ни один пост
+// и для него нет действующего токена загрузки
+func (m *MemoryStore) DeleteMedia(id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.media[id]; !ok {
+		return false, nil
+	}
+	for _, p := range m.posts {
+		if p.MediaID.Valid && p.MediaID.Int64 == id {
+			return false, nil
+		}
+	}
+	now := time.Now()
+	for _, t := range m.tokens {
+		if t.mediaID == id && !t.used && now.Before(t.expiresAt) {
+			return false, nil
+		}
+	}
+	delete(m.media, id)
+	for hash, t := range m.tokens {
+		if t.mediaID == id {
+			delete(m.tokens, hash)
+		}
+	}
+	return true, nil
+}
+
+// uploadToken строка upload_tokens
+type uploadToken struct {
+	mediaID   int64
+	expiresAt time.Time
+	used      bool
+}
+
+// CreateUploadToken сохраняет токен загрузки
+func (m *MemoryStore) CreateUploadToken(tokenHash string, mediaID int64, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tokens[tokenHash]; ok {
+		return errors.New("токен загрузки уже существует")
+	}
+	m.tokens[tokenHash] = uploadToken{mediaID: mediaID, expiresAt: expiresAt}
+	return nil
+}
+
+// GetUploadToken возвращает медиафайл действующего токена, не отмечая
+// токен использованным
+func (m *MemoryStore) GetUploadToken(tokenHash string) (*Media, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.uploadTokenMedia(tokenHash)
+}
+
+// uploadTokenMedia медиафайл действующего токена; вызывается под m.mu
+func (m *MemoryStore) uploadTokenMedia(tokenHash string) (*Media, error) {
+	t, ok := m.tokens[tokenHash]
+	switch {
+	case !ok:
+		return nil, ErrUploadTokenUnknown
+	case t.used:
+		return nil, ErrUploadTokenUsed
+	case time.Now().After(t.expiresAt):
+		return nil, ErrUploadTokenExpired
+	}
+	md, ok := m.media[t.mediaID]
+	if !ok {
+		return nil, ErrUploadTokenUnknown
+	}
+	return &md, nil
+}
+
+// useUploadToken отмечает токен md.UploadToken использованным при
+// создании поста; вызывается под m.mu.Lock до изменения данных
+func (m *MemoryStore) useUploadToken(md Media) error {
+	if md.UploadToken == "" {
+		return nil
+	}
+	if _, err := m.uploadTokenMedia(md.UploadToken); err != nil {
+		return err
+	}
+	t := m.tokens[md.UploadToken]
+	if t.mediaID != md.ID {
+		return ErrUploadTokenUnknown
+	}
+	t.used = true
+	m.tokens[md.UploadToken] = t
+	return nil
+}
+
+// DeleteExpiredUploadTokens удаляет токены, истёкшие до before
+func (m *MemoryStore) DeleteExpiredUploadTokens(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for hash, t := range m.tokens {
+		if t.expiresAt.Before(before) {
+			delete(m.tokens, hash)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// addMediaRef увеличивает счётчик ссылок на медиафайл (под m.mu)
+func (m *MemoryStore) addMediaRef(id int64) {
+	if md, ok := m.media[id]; ok {
+		md.RefCount++
+		m.media[id] = md
+	}
+}
+
+// === SEARCH ===
+
+// Search ищет посты по подстрокам без учёта регистра, новые первыми
+func (m *MemoryStore) Search(q SearchQuery, page PageRequest) ([]SearchResult, PageInfo, error) {
+	terms := SearchTerms(q.Text)
+	if len(terms) == 0 {
+		return nil, PageInfo{}, nil
+	}
+
+	m.mu.RLock()
+	var all []SearchResult
+	for _, t := range m.threads {
+		if q.BoardID != "" && t.BoardID != q.BoardID {
+			continue
+		}
+		subjectMatch := containsTerms(t.Subject, terms)
+		for i, p := range m.threadPosts(t.ID) {
+			if q.Author != "" && p.Author != q.Author {
+				continue
+			}
+			if q.HasMedia != nil && p.MediaPath.Valid != *q.HasMedia {
+				continue
+			}
+			if !containsTerms(p.Content, terms) && !(i == 0 && subjectMatch) {
+				continue
+			}
+			all = append(all, SearchResult{Post: p, Subject: t.Subject, BoardID: t.BoardID})
+		}
+	}
+	m.mu.RUnlock()
+
+	reverse := page.Cursor != nil && page.Cursor.Before
+	sort.Slice(all, func(i, j int) bool {
+		a, b := &all[i].Post, &all[j].Post
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt) != reverse
+		}
+		return (a.ID > b.ID) != reverse
+	})
+
+	var results []SearchResult
+	for i := range all {
+		if page.Cursor != nil && !afterCursor(postCursor(&all[i].Post), page.Cursor, true) {
+			continue
+		}
+		results = append(results, all[i])
+		if len(results) > page.Limit {
+			break
+		}
+	}
+	return searchPage(results, page, reverse)
+}
+
+// Close ничего не делает: ресурсов, требующих освобождения, нет
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// threadPosts возвращает копии постов треда по возрастанию created_at.
+// Вызывающий должен держать m.mu.
+func (m *MemoryStore) threadPosts(threadID int) []Post {
+	var posts []Post
+	for _, p := range m.posts {
+		if p.ThreadID == threadID {
+			posts = append(posts, p)
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		if !posts[i].CreatedAt.Equal(posts[j].CreatedAt) {
+			return posts[i].CreatedAt.Before(posts[j].CreatedAt)
+		}
+		return posts[i].ID < posts[j].ID
+	})
+	return posts
+}
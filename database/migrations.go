@@ -0,0 +1,523 @@
+package database
+
+//go:generate go run gen_schema.go
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Steps SQL-выражения миграции для одного драйвера
+type Steps struct {
+	Up   []string
+	Down []string
+}
+
+// Migration нумерованная версия схемы.
+// Новые миграции добавляются только в конец списка migrations,
+// уже выпущенные не редактируются.
+type Migration struct {
+	Version int
+	Name    string
+	MySQL   Steps
+	SQLite  Steps
+}
+
+// steps возвращает выражения миграции для драйвера
+func (m Migration) steps(driver string) Steps {
+	if driver == DriverSQLite {
+		return m.SQLite
+	}
+	return m.MySQL
+}
+
+// MigrationStatus состояние миграции в БД
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrationsTable DDL таблицы учёта миграций (одинаков для всех драйверов)
+const migrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`
+
+// migrations все миграции схемы по возрастанию версии
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "init",
+		MySQL: Steps{
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS boards (
+	id VARCHAR(50) PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	description TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				`CREATE TABLE IF NOT EXISTS threads (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	board_id VARCHAR(50) NOT NULL,
+	subject VARCHAR(255) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	bumped_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	FOREIGN KEY (board_id) REFERENCES boards(id) ON DELETE CASCADE,
+	INDEX idx_board_bumped (board_id, bumped_at DESC)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				`CREATE TABLE IF NOT EXISTS posts (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	thread_id INT NOT NULL,
+	parent_id INT DEFAULT NULL,
+	author VARCHAR(100) DEFAULT 'Аноним',
+	content TEXT NOT NULL,
+	media_path VARCHAR(500),
+	media_type VARCHAR(20),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (thread_id) REFERENCES threads(id) ON DELETE CASCADE,
+	FOREIGN KEY (parent_id) REFERENCES posts(id) ON DELETE SET NULL,
+	INDEX idx_thread (thread_id),
+	INDEX idx_parent (parent_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+			},
+			Down: []string{
+				`DROP TABLE IF EXISTS posts`,
+				`DROP TABLE IF EXISTS threads`,
+				`DROP TABLE IF EXISTS boards`,
+			},
+		},
+		SQLite: Steps{
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS boards (
+	id VARCHAR(50) PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	description TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`,
+				`CREATE TABLE IF NOT EXISTS threads (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	board_id VARCHAR(50) NOT NULL REFERENCES boards(id) ON DELETE CASCADE,
+	subject VARCHAR(255) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	bumped_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`,
+				`CREATE INDEX IF NOT EXISTS idx_board_bumped ON threads (board_id, bumped_at)`,
+				`CREATE TABLE IF NOT EXISTS posts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	thread_id INTEGER NOT NULL REFERENCES threads(id) ON DELETE CASCADE,
+	parent_id INTEGER DEFAULT NULL REFERENCES posts(id) ON DELETE SET NULL,
+	author VARCHAR(100) DEFAULT 'Аноним',
+	content TEXT NOT NULL,
+	media_path VARCHAR(500),
+	media_type VARCHAR(20),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`,
+				`CREATE INDEX IF NOT EXISTS idx_thread ON posts (thread_id)`,
+				`CREATE INDEX IF NOT EXISTS idx_parent ON posts (parent_id)`,
+			},
+			Down: []string{
+				`DROP TABLE IF EXISTS posts`,
+				`DROP TABLE IF EXISTS threads`,
+				`DROP TABLE IF EXISTS boards`,
+			},
+		},
+	},
+	{
+		Version: 2,
+		Name:    "search",
+		MySQL: Steps{
+			Up: []string{
+				`ALTER TABLE posts ADD FULLTEXT INDEX ft_posts_content (content)`,
+				`ALTER TABLE threads ADD FULLTEXT INDEX ft_threads_subject (subject)`,
+			},
+			Down: []string{
+				`ALTER TABLE threads DROP INDEX ft_threads_subject`,
+				`ALTER TABLE posts DROP INDEX ft_posts_content`,
+			},
+		},
+		// FTS5-таблицы с внешним содержимым синхронизируются триггерами
+		SQLite: Steps{
+			Up: []string{
+				`CREATE VIRTUAL TABLE posts_fts USING fts5(content, content='posts', content_rowid='id')`,
+				`CREATE TRIGGER posts_fts_ai AFTER INSERT ON posts BEGIN
+	INSERT INTO posts_fts (rowid, content) VALUES (new.id, new.content);
+END`,
+				`CREATE TRIGGER posts_fts_ad AFTER DELETE ON posts BEGIN
+	INSERT INTO posts_fts (posts_fts, rowid, content) VALUES ('delete', old.id, old.content);
+END`,
+				`CREATE TRIGGER posts_fts_au AFTER UPDATE OF content ON posts BEGIN
+	INSERT INTO posts_fts (posts_fts, rowid, content) VALUES ('delete', old.id, old.content);
+	INSERT INTO posts_fts (rowid, content) VALUES (new.id, new.content);
+END`,
+				`INSERT INTO posts_fts (posts_fts) VALUES ('rebuild')`,
+				`CREATE VIRTUAL TABLE threads_fts USING fts5(subject, content='threads', content_rowid='id')`,
+				`CREATE TRIGGER threads_fts_ai AFTER INSERT ON threads BEGIN
+	INSERT INTO threads_fts (rowid, subject) VALUES (new.id, new.subject);
+END`,
+				`CREATE TRIGGER threads_fts_ad AFTER DELETE ON threads BEGIN
+	INSERT INTO threads_fts (threads_fts, rowid, subject) VALUES ('delete', old.id, old.subject);
+END`,
+				`CREATE TRIGGER threads_fts_au AFTER UPDATE OF subject ON threads BEGIN
+	INSERT INTO threads_fts (threads_fts, rowid, subject) VALUES ('delete', old.id, old.subject);
+	INSERT INTO threads_fts (rowid, subject) VALUES (new.id, new.subject);
+END`,
+				`INSERT INTO threads_fts (threads_fts) VALUES ('rebuild')`,
+			},
+			Down: []string{
+				`DROP TRIGGER IF EXISTS threads_fts_au`,
+				`DROP TRIGGER IF EXISTS threads_fts_ad`,
+				`DROP TRIGGER IF EXISTS threads_fts_ai`,
+				`DROP TABLE IF EXISTS threads_fts`,
+				`DROP TRIGGER IF EXISTS posts_fts_au`,
+				`DROP TRIGGER IF EXISTS posts_fts_ad`,
+				`DROP TRIGGER IF EXISTS posts_fts_ai`,
+				`DROP TABLE IF EXISTS posts_fts`,
+			},
+		},
+	},
+	{
+		Version: 3,
+		Name:    "thumbnails",
+		MySQL: Steps{
+			Up:   []string{`ALTER TABLE posts ADD COLUMN thumb_path VARCHAR(500) AFTER media_type`},
+			Down: []string{`ALTER TABLE posts DROP COLUMN thumb_path`},
+		},
+		SQLite: Steps{
+			Up:   []string{`ALTER TABLE posts ADD COLUMN thumb_path VARCHAR(500)`},
+			Down: []string{`ALTER TABLE posts DROP COLUMN thumb_path`},
+		},
+	},
+	{
+		Version: 4,
+		Name:    "mime_type",
+		MySQL: Steps{
+			Up:   []string{`ALTER TABLE posts ADD COLUMN mime_type VARCHAR(100) AFTER media_type`},
+			Down: []string{`ALTER TABLE posts DROP COLUMN mime_type`},
+		},
+		SQLite: Steps{
+			Up:   []string{`ALTER TABLE posts ADD COLUMN mime_type VARCHAR(100)`},
+			Down: []string{`ALTER TABLE posts DROP COLUMN mime_type`},
+		},
+	},
+	{
+		Version: 5,
+		Name:    "media_metadata",
+		MySQL: Steps{
+			Up: []string{
+				`ALTER TABLE boards ADD COLUMN strip_metadata BOOLEAN NOT NULL DEFAULT TRUE`,
+				`ALTER TABLE posts ADD COLUMN media_width INT AFTER thumb_path, ADD COLUMN media_height INT AFTER media_width`,
+			},
+			Down: []string{
+				`ALTER TABLE posts DROP COLUMN media_height, DROP COLUMN media_width`,
+				`ALTER TABLE boards DROP COLUMN strip_metadata`,
+			},
+		},
+		SQLite: Steps{
+			Up: []string{
+				`ALTER TABLE boards ADD COLUMN strip_metadata BOOLEAN NOT NULL DEFAULT 1`,
+				`ALTER TABLE posts ADD COLUMN media_width INTEGER`,
+				`ALTER TABLE posts ADD COLUMN media_height INTEGER`,
+			},
+			Down: []string{
+				`ALTER TABLE posts DROP COLUMN media_height`,
+				`ALTER TABLE posts DROP COLUMN media_width`,
+				`ALTER TABLE boards DROP COLUMN strip_metadata`,
+			},
+		},
+	},
+	{
+		Version: 6,
+		Name:    "media",
+		MySQL: Steps{
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS media (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	hash CHAR(64) NOT NULL,
+	path VARCHAR(500) NOT NULL,
+	media_type VARCHAR(20) NOT NULL,
+	mime_type VARCHAR(100) NOT NULL,
+	thumb_path VARCHAR(500),
+	width INT,
+	height INT,
+	size BIGINT NOT NULL,
+	ref_count INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE INDEX idx_media_hash (hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				`ALTER TABLE posts ADD COLUMN media_id INT AFTER media_height,
+	ADD CONSTRAINT fk_posts_media FOREIGN KEY (media_id) REFERENCES media(id) ON DELETE SET NULL`,
+			},
+			Down: []string{
+				`ALTER TABLE posts DROP FOREIGN KEY fk_posts_media, DROP COLUMN media_id`,
+				`DROP TABLE IF EXISTS media`,
+			},
+		},
+		// Столбец с REFERENCES в SQLite нельзя удалить через DROP COLUMN,
+		// поэтому связь posts.media_id держится на уровне приложения
+		SQLite: Steps{
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS media (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	hash CHAR(64) NOT NULL UNIQUE,
+	path VARCHAR(500) NOT NULL,
+	media_type VARCHAR(20) NOT NULL,
+	mime_type VARCHAR(100) NOT NULL,
+	thumb_path VARCHAR(500),
+	width INTEGER,
+	height INTEGER,
+	size BIGINT NOT NULL,
+	ref_count INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`,
+				`ALTER TABLE posts ADD COLUMN media_id INTEGER`,
+				`CREATE INDEX IF NOT EXISTS idx_posts_media ON posts (media_id)`,
+			},
+			Down: []string{
+				`DROP INDEX IF EXISTS idx_posts_media`,
+				`ALTER TABLE posts DROP COLUMN media_id`,
+				`DROP TABLE IF EXISTS media`,
+			},
+		},
+	},
+	{
+		Version: 7,
+		Name:    "upload_tokens",
+		MySQL: Steps{
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS upload_tokens (
+	token_hash CHAR(64) PRIMARY KEY,
+	media_id INT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	used_at DATETIME NULL DEFAULT NULL,
+	FOREIGN KEY (media_id) REFERENCES media(id) ON DELETE CASCADE,
+	INDEX idx_upload_tokens_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+			},
+			Down: []string{
+				`DROP TABLE IF EXISTS upload_tokens`,
+			},
+		},
+		SQLite: Steps{
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS upload_tokens (
+	token_hash CHAR(64) PRIMARY KEY,
+	media_id INTEGER NOT NULL REFERENCES media(id) ON DELETE CASCADE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	used_at DATETIME
+)`,
+				`CREATE INDEX IF NOT EXISTS idx_upload_tokens_expires ON upload_tokens (expires_at)`,
+			},
+			Down: []string{
+				`DROP INDEX IF EXISTS idx_upload_tokens_expires`,
+				`DROP TABLE IF EXISTS upload_tokens`,
+			},
+		},
+	},
+	{
+		Version: 8,
+		Name:    "board_media_policy",
+		MySQL: Steps{
+			Up: []string{
+				`ALTER TABLE boards ADD COLUMN media_types VARCHAR(50) NOT NULL DEFAULT 'image,video,audio' AFTER strip_metadata,
+	ADD COLUMN max_file_size BIGINT NOT NULL DEFAULT 104857600 AFTER media_types`,
+			},
+			Down: []string{
+				`ALTER TABLE boards DROP COLUMN max_file_size, DROP COLUMN media_types`,
+			},
+		},
+		SQLite: Steps{
+			Up: []string{
+				`ALTER TABLE boards ADD COLUMN media_types VARCHAR(50) NOT NULL DEFAULT 'image,video,audio'`,
+				`ALTER TABLE boards ADD COLUMN max_file_size BIGINT NOT NULL DEFAULT 104857600`,
+			},
+			Down: []string{
+				`ALTER TABLE boards DROP COLUMN max_file_size`,
+				`ALTER TABLE boards DROP COLUMN media_types`,
+			},
+		},
+	},
+}
+
+// MigrateUp применяет до n ещё не применённых миграций (0 — все).
+// Возвращает количество применённых миграций.
+func (s *SQLStore) MigrateUp(n int) (int, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if n > 0 && count >= n {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := s.runMigration(m, m.steps(s.driver).Up, true); err != nil {
+			return count, fmt.Errorf("миграция %d (%s): %w", m.Version, m.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// MigrateDown откатывает n последних применённых миграций (0 — одну).
+// Возвращает количество откаченных миграций.
+func (s *SQLStore) MigrateDown(n int) (int, error) {
+	if n <= 0 {
+		n = 1
+	}
+
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < n; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := s.runMigration(m, m.steps(s.driver).Down, false); err != nil {
+			return count, fmt.Errorf("откат миграции %d (%s): %w", m.Version, m.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// MigrationStatus возвращает состояние всех известных миграций
+func (s *SQLStore) MigrationStatus() ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []MigrationStatus
+	for _, m := range migrations {
+		st := MigrationStatus{Version: m.Version, Name: m.Name}
+		st.AppliedAt, st.Applied = applied[m.Version]
+		result = append(result, st)
+	}
+	return result, nil
+}
+
+// runMigration выполняет выражения миграции и обновляет schema_migrations.
+// В MySQL каждое DDL-выражение фиксируется сразу, и транзакция не
+// откатывает миграцию, прерванную на середине. Повторный запуск такой
+// миграции пропускает выражения, которые уже применены (см. mysqlApplied).
+func (s *SQLStore) runMigration(m Migration, statements []string, up bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			if s.driver == DriverMySQL && mysqlApplied(err) {
+				continue
+			}
+			return err
+		}
+	}
+
+	if up {
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.Version, m.Name)
+	} else {
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Коды ошибок MySQL, означающие, что выражение миграции уже применено:
+// создаваемый объект существует или удаляемого уже нет
+const (
+	mysqlErrTableExists   = 1050 // ER_TABLE_EXISTS_ERROR
+	mysqlErrBadTable      = 1051 // ER_BAD_TABLE_ERROR
+	mysqlErrDupFieldName  = 1060 // ER_DUP_FIELDNAME
+	mysqlErrDupKeyName    = 1061 // ER_DUP_KEYNAME
+	mysqlErrCantDropKey   = 1091 // ER_CANT_DROP_FIELD_OR_KEY
+	mysqlErrDupForeignKey = 1826 // ER_FK_DUP_NAME
+)
+
+// mysqlApplied сообщает, что выражение миграции уже было выполнено
+// прерванным запуском. ALTER TABLE в MySQL атомарен, поэтому выражение из
+// нескольких ADD/DROP применено либо целиком, либо никак, и ошибка о
+// существующем столбце или индексе относится ко всему выражению.
+func mysqlApplied(err error) bool {
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) {
+		return false
+	}
+	switch myErr.Number {
+	case mysqlErrTableExists, mysqlErrBadTable, mysqlErrDupFieldName,
+		mysqlErrDupKeyName, mysqlErrCantDropKey, mysqlErrDupForeignKey:
+		return true
+	}
+	return false
+}
+
+// appliedMigrations возвращает версии применённых миграций и время применения
+func (s *SQLStore) appliedMigrations() (map[int]time.Time, error) {
+	if _, err := s.db.Exec(migrationsTable); err != nil {
+		return nil, fmt.Errorf("ошибка создания schema_migrations: %w", err)
+	}
+
+	rows, err := s.db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// SchemaSQL собирает полную схему для драйвера из миграций.
+// Из неё генерируется database/schema.sql (go generate ./database).
+func SchemaSQL(driver string) string {
+	var b strings.Builder
+	b.WriteString("-- Код сгенерирован из database/migrations.go; НЕ РЕДАКТИРОВАТЬ.\n")
+	b.WriteString("-- Обновление: go generate ./database\n\n")
+
+	if driver != DriverSQLite {
+		b.WriteString("-- Создание базы данных\n")
+		b.WriteString("CREATE DATABASE IF NOT EXISTS webforum\nCHARACTER SET utf8mb4\nCOLLATE utf8mb4_unicode_ci;\n\n")
+		b.WriteString("USE webforum;\n\n")
+	}
+
+	b.WriteString("-- Учёт применённых миграций\n")
+	b.WriteString(migrationsTable + ";\n")
+
+	for _, m := range migrations {
+		fmt.Fprintf(&b, "\n-- Миграция %d: %s\n", m.Version, m.Name)
+		for _, stmt := range m.steps(driver).Up {
+			b.WriteString(stmt + ";\n\n")
+		}
+		fmt.Fprintf(&b, "INSERT INTO schema_migrations (version, name) VALUES (%d, '%s');\n", m.Version, m.Name)
+	}
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestMySQLApplied(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"столбец уже добавлен", &mysql.MySQLError{Number: mysqlErrDupFieldName, Message: "Duplicate column name 'media_id'"}, true},
+		{"индекс уже добавлен", &mysql.MySQLError{Number: mysqlErrDupKeyName, Message: "Duplicate key name 'ft_posts_content'"}, true},
+		{"таблица уже создана", &mysql.MySQLError{Number: mysqlErrTableExists}, true},
+		{"внешний ключ уже создан", &mysql.MySQLError{Number: mysqlErrDupForeignKey}, true},
+		{"столбец уже удалён", &mysql.MySQLError{Number: mysqlErrCantDropKey}, true},
+		{"таблица уже удалена", &mysql.MySQLError{Number: mysqlErrBadTable}, true},
+		{"обёрнутая ошибка", fmt.Errorf("exec: %w", &mysql.MySQLError{Number: mysqlErrDupFieldName}), true},
+		{"синтаксическая ошибка", &mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax"}, false},
+		{"нет таблицы для ALTER", &mysql.MySQLError{Number: 1146, Message: "Table 'webforum.media' doesn't exist"}, false},
+		{"ошибка соединения", mysql.ErrInvalidConn, false},
+		{"не ошибка MySQL", errors.New("duplicate column name"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mysqlApplied(tt.err); got != tt.want {
+				t.Errorf("mysqlApplied(%v) = %v, ожидалось %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cursor позиция в выдаче для keyset-пагинации.
+// Ключом служит значение сортировки (bumped_at, created_at или число
+// постов) вместе с ID, поэтому страницы не «съезжают» при появлении
+// новых постов и тредов.
+type Cursor struct {
+	Key    int64 // время в UnixNano или число постов
+	ID     int   // ID строки, разрешает равенство ключей
+	Before bool  // true - страница перед позицией (prev)
+}
+
+// ErrInvalidCursor курсор не удалось разобрать
+var ErrInvalidCursor = errors.New("неверный курсор")
+
+// Encode кодирует курсор в непрозрачную строку для клиентов
+func (c Cursor) Encode() string {
+	dir := "n"
+	if c.Before {
+		dir = "p"
+	}
+	raw := fmt.Sprintf("%s.%d.%d", dir, c.Key, c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor разбирает строку, полученную из Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	parts := strings.Split(string(raw), ".")
+	if len(parts) != 3 || (parts[0] != "n" && parts[0] != "p") {
+		return nil, ErrInvalidCursor
+	}
+	key, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{Key: key, ID: id, Before: parts[0] == "p"}, nil
+}
+
+// PageRequest параметры запрашиваемой страницы
+type PageRequest struct {
+	Limit  int
+	Cursor *Cursor // nil - первая страница
+}
+
+// PageInfo курсоры соседних страниц (пустые, если страницы нет)
+type PageInfo struct {
+	Next string
+	Prev string
+}
+
+// pageInfo вычисляет курсоры соседних страниц.
+// first и last - позиции первого и последнего элемента страницы,
+// hasMore - в направлении запроса есть ещё элементы.
+func pageInfo(req PageRequest, count int, hasMore bool, first, last Cursor) PageInfo {
+	var info PageInfo
+	if count == 0 {
+		return info
+	}
+
+	backward := req.Cursor != nil && req.Cursor.Before
+	hasNext := hasMore
+	hasPrev := req.Cursor != nil
+	if backward {
+		hasNext, hasPrev = true, hasMore
+	}
+
+	if hasNext {
+		last.Before = false
+		info.Next = last.Encode()
+	}
+	if hasPrev {
+		first.Before = true
+		info.Prev = first.Encode()
+	}
+	return info
+}
+
+// threadSort ключ сортировки тредов доски
+type threadSort struct {
+	column string // SQL-выражение ключа
+	desc   bool
+	isTime bool
+	key    func(t *Thread) int64
+}
+
+// postCountColumn количество постов треда t
+const postCountColumn = `(SELECT COUNT(*) FROM posts pc WHERE pc.thread_id = t.id)`
+
+// threadSortFor возвращает ключ для режима сортировки bump|new|old|replies
+func threadSortFor(sortBy string) threadSort {
+	switch sortBy {
+	case "new":
+		return threadSort{"t.created_at", true, true, func(t *Thread) int64 { return t.CreatedAt.UnixNano() }}
+	case "old":
+		return threadSort{"t.created_at", false, true, func(t *Thread) int64 { return t.CreatedAt.UnixNano() }}
+	case "replies":
+		return threadSort{postCountColumn, true, false, func(t *Thread) int64 { return int64(t.PostCount) }}
+	default: // bump
+		return threadSort{"t.bumped_at", true, true, func(t *Thread) int64 { return t.BumpedAt.UnixNano() }}
+	}
+}
+
+// cursor позиция треда в этой сортировке
+func (ts threadSort) cursor(t *Thread) Cursor {
+	return Cursor{Key: ts.key(t), ID: t.ID}
+}
+
+// less сравнивает треды в порядке сортировки (ID разрешает равенство)
+func (ts threadSort) less(a, b *Thread) bool {
+	ka, kb := ts.key(a), ts.key(b)
+	if ka != kb {
+		return (ka > kb) == ts.desc
+	}
+	if a.ID == b.ID {
+		return false
+	}
+	return (a.ID > b.ID) == ts.desc
+}
+
+// postCursor позиция поста в хронологическом порядке
+func postCursor(p *Post) Cursor {
+	return Cursor{Key: p.CreatedAt.UnixNano(), ID: p.ID}
+}
+
+// keyset возвращает условие WHERE, ORDER BY и признак обратного порядка
+// для выборки страницы после (или перед) курсором по паре (key, id)
+func keyset(keyColumn, idColumn string, desc bool, c *Cursor) (where, order string, reverse bool) {
+	reverse = c != nil && c.Before
+	if reverse {
+		desc = !desc
+	}
+
+	cmp, dir := ">", "ASC"
+	if desc {
+		cmp, dir = "<", "DESC"
+	}
+
+	order = fmt.Sprintf("%s %s, %s %s", keyColumn, dir, idColumn, dir)
+	if c != nil {
+		where = fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))", keyColumn, cmp, keyColumn, idColumn, cmp)
+	}
+	return where, order, reverse
+}
+
+// keyArg значение ключа курсора в виде параметра запроса
+func (s *SQLStore) keyArg(key int64, isTime bool) interface{} {
+	if !isTime {
+		return key
+	}
+	t := time.Unix(0, key)
+	if s.driver == DriverSQLite {
+		// CURRENT_TIMESTAMP в SQLite хранится текстом в UTC
+		return t.UTC().Format("2006-01-02 15:04:05")
+	}
+	return t
+}
+
+// afterCursor проверяет, лежит ли позиция pos за курсором c в порядке desc
+func afterCursor(pos Cursor, c *Cursor, desc bool) bool {
+	if c.Before {
+		desc = !desc
+	}
+	if pos.Key != c.Key {
+		return (pos.Key < c.Key) == desc
+	}
+	if pos.ID == c.ID {
+		return false
+	}
+	return (pos.ID < c.ID) == desc
+}
@@ -2,6 +2,8 @@ package database
 
 import (
 	"database/sql"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -11,18 +13,62 @@ type Board struct {
 	Name        string
 	Description string
 	CreatedAt   time.Time
+	Settings    BoardSettings
 	ThreadCount int // вычисляемое поле
 }
 
+// BoardSettings настройки доски
+type BoardSettings struct {
+	StripMetadata bool     // удалять EXIF и прочие метаданные из загруженных фото
+	MediaTypes    []string // разрешённые типы файлов (image, video, audio); пустой - без файлов
+	MaxFileSize   int64    // максимальный размер файла, байт
+}
+
+// AllMediaTypes типы медиафайлов, которые можно разрешить на доске
+var AllMediaTypes = []string{"image", "video", "audio"}
+
+// DefaultBoardSettings настройки новой доски по умолчанию
+func DefaultBoardSettings() BoardSettings {
+	return BoardSettings{
+		StripMetadata: true,
+		MediaTypes:    append([]string(nil), AllMediaTypes...),
+		MaxFileSize:   100 << 20,
+	}
+}
+
+// AllowsMediaType разрешены ли на доске файлы типа mediaType
+func (s BoardSettings) AllowsMediaType(mediaType string) bool {
+	for _, t := range s.MediaTypes {
+		if t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// joinMediaTypes и splitMediaTypes - хранение MediaTypes в boards.media_types
+// строкой через запятую
+func joinMediaTypes(types []string) string {
+	return strings.Join(types, ",")
+}
+
+func splitMediaTypes(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
 // Thread тред на доске
 type Thread struct {
-	ID        int
-	BoardID   string
-	Subject   string
-	CreatedAt time.Time
-	BumpedAt  time.Time
-	PostCount int   // вычисляемое поле
-	FirstPost *Post // первый пост (OP)
+	ID         int
+	BoardID    string
+	Subject    string
+	CreatedAt  time.Time
+	BumpedAt   time.Time
+	PostCount  int   // вычисляемое поле
+	ImageCount int   // вычисляемое поле: посты с картинками
+	FirstPost  *Post // первый пост (OP)
 }
 
 // Post пост/комментарий в треде
@@ -34,23 +80,65 @@ type Post struct {
 	Content   string
 	MediaPath sql.NullString
 	MediaType sql.NullString
+	MimeType  sql.NullString
+	ThumbPath sql.NullString
+	Width     sql.NullInt64 // размеры картинки, px
+	Height    sql.NullInt64
+	MediaID   sql.NullInt64 // запись в таблице media
 	CreatedAt time.Time
 	Depth     int // глубина вложенности для лесенки
 }
 
+// PreviewPath путь для превью медиафайла: миниатюра, если она есть
+func (p Post) PreviewPath() string {
+	if p.ThumbPath.Valid {
+		return p.ThumbPath.String
+	}
+	return p.MediaPath.String
+}
+
+// setMedia заполняет медиаполя поста
+func (p *Post) setMedia(m Media) {
+	p.MediaPath = sql.NullString{String: m.Path, Valid: m.Path != ""}
+	p.MediaType = sql.NullString{String: m.Type, Valid: m.Type != ""}
+	p.MimeType = sql.NullString{String: m.MIME, Valid: m.MIME != ""}
+	p.ThumbPath = sql.NullString{String: m.ThumbPath, Valid: m.ThumbPath != ""}
+	p.Width = sql.NullInt64{Int64: int64(m.Width), Valid: m.Width > 0}
+	p.Height = sql.NullInt64{Int64: int64(m.Height), Valid: m.Height > 0}
+	p.MediaID = sql.NullInt64{Int64: m.ID, Valid: m.ID > 0}
+}
+
+// postFields столбцы таблицы posts в порядке postDest
+var postFields = []string{"id", "thread_id", "parent_id", "author", "content",
+	"media_path", "media_type", "mime_type", "thumb_path", "media_width", "media_height", "media_id", "created_at"}
+
+// postColumns список столбцов поста для SELECT с псевдонимом таблицы alias
+func postColumns(alias string) string {
+	if alias == "" {
+		return strings.Join(postFields, ", ")
+	}
+	return alias + "." + strings.Join(postFields, ", "+alias+".")
+}
+
+// postDest указатели на поля поста для rows.Scan
+func postDest(p *Post) []interface{} {
+	return []interface{}{&p.ID, &p.ThreadID, &p.ParentID, &p.Author, &p.Content,
+		&p.MediaPath, &p.MediaType, &p.MimeType, &p.ThumbPath, &p.Width, &p.Height, &p.MediaID, &p.CreatedAt}
+}
+
 // === BOARDS ===
 
 // GetAllBoards возвращает все доски
-func GetAllBoards() ([]Board, error) {
+func (s *SQLStore) GetAllBoards() ([]Board, error) {
 	query := `
-		SELECT b.id, b.name, b.description, b.created_at,
+		SELECT b.id, b.name, b.description, b.created_at, b.strip_metadata, b.media_types, b.max_file_size,
 		       COALESCE(COUNT(t.id), 0) as thread_count
 		FROM boards b
 		LEFT JOIN threads t ON b.id = t.board_id
 		GROUP BY b.id
 		ORDER BY b.id`
 	
-	rows, err := DB.Query(query)
+	rows, err := s.db.Query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -59,87 +147,167 @@ func GetAllBoards() ([]Board, error) {
 	var boards []Board
 	for rows.Next() {
 		var b Board
-		if err := rows.Scan(&b.ID, &b.Name, &b.Description, &b.CreatedAt, &b.ThreadCount); err != nil {
+		var mediaTypes string
+		if err := rows.Scan(&b.ID, &b.Name, &b.Description, &b.CreatedAt, &b.Settings.StripMetadata,
+			&mediaTypes, &b.Settings.MaxFileSize, &b.ThreadCount); err != nil {
 			return nil, err
 		}
+		b.Settings.MediaTypes = splitMediaTypes(mediaTypes)
 		boards = append(boards, b)
 	}
 	return boards, nil
 }
 
 // GetBoard возвращает доску по ID
-func GetBoard(id string) (*Board, error) {
-	query := `SELECT id, name, description, created_at FROM boards WHERE id = ?`
+func (s *SQLStore) GetBoard(id string) (*Board, error) {
+	query := `SELECT id, name, description, created_at, strip_metadata, media_types, max_file_size FROM boards WHERE id = ?`
 	
 	var b Board
-	err := DB.QueryRow(query, id).Scan(&b.ID, &b.Name, &b.Description, &b.CreatedAt)
+	var mediaTypes string
+	err := s.db.QueryRow(query, id).Scan(&b.ID, &b.Name, &b.Description, &b.CreatedAt, &b.Settings.StripMetadata,
+		&mediaTypes, &b.Settings.MaxFileSize)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	b.Settings.MediaTypes = splitMediaTypes(mediaTypes)
 	return &b, nil
 }
 
 // CreateBoard создаёт новую доску
-func CreateBoard(id, name, description string) error {
-	query := `INSERT INTO boards (id, name, description) VALUES (?, ?, ?)`
-	_, err := DB.Exec(query, id, name, description)
+func (s *SQLStore) CreateBoard(id, name, description string, settings BoardSettings) error {
+	query := `INSERT INTO boards (id, name, description, strip_metadata, media_types, max_file_size) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := s.db.Exec(query, id, name, description, settings.StripMetadata,
+		joinMediaTypes(settings.MediaTypes), settings.MaxFileSize)
+	return err
+}
+
+// UpdateBoardSettings сохраняет настройки доски
+func (s *SQLStore) UpdateBoardSettings(id string, settings BoardSettings) error {
+	query := `UPDATE boards SET strip_metadata = ?, media_types = ?, max_file_size = ? WHERE id = ?`
+	_, err := s.db.Exec(query, settings.StripMetadata, joinMediaTypes(settings.MediaTypes), settings.MaxFileSize, id)
 	return err
 }
 
 // === THREADS ===
 
-// GetThreadsByBoard возвращает треды доски с сортировкой
-func GetThreadsByBoard(boardID, sortBy string) ([]Thread, error) {
-	var orderBy string
-	switch sortBy {
-	case "new":
-		orderBy = "t.created_at DESC"
-	case "old":
-		orderBy = "t.created_at ASC"
-	case "replies":
-		orderBy = "post_count DESC"
-	default: // bump
-		orderBy = "t.bumped_at DESC"
-	}
-	
-	query := `
+// imageCountColumn количество постов с картинками в треде t
+const imageCountColumn = `(SELECT COUNT(*) FROM posts ic WHERE ic.thread_id = t.id AND ic.media_type = 'image')`
+
+// threadsQuery выбирает треды вместе с количеством постов, картинок и OP.
+// Коррелированные подзапросы идут по индексу idx_thread, а число
+// round-trip'ов к БД не зависит от количества тредов на доске.
+const threadsQuery = `
 		SELECT t.id, t.board_id, t.subject, t.created_at, t.bumped_at,
-		       COUNT(p.id) as post_count
+		       ` + postCountColumn + ` as post_count,
+		       ` + imageCountColumn + ` as image_count,
+		       op.id, op.parent_id, op.author, op.content, op.media_path, op.media_type, op.mime_type, op.thumb_path, op.media_width, op.media_height, op.media_id, op.created_at
 		FROM threads t
-		LEFT JOIN posts p ON t.id = p.thread_id
+		LEFT JOIN posts op ON op.id = (SELECT MIN(p.id) FROM posts p WHERE p.thread_id = t.id)`
+
+// GetThreadsByBoard возвращает треды доски с сортировкой
+func (s *SQLStore) GetThreadsByBoard(boardID, sortBy string) ([]Thread, error) {
+	ts := threadSortFor(sortBy)
+	_, orderBy, _ := keyset(ts.column, "t.id", ts.desc, nil)
+	
+	query := threadsQuery + `
 		WHERE t.board_id = ?
-		GROUP BY t.id
 		ORDER BY ` + orderBy
 	
-	rows, err := DB.Query(query, boardID)
+	rows, err := s.db.Query(query, boardID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	
+	return scanThreads(rows)
+}
+
+// GetThreadsPage возвращает страницу тредов доски (keyset-пагинация)
+func (s *SQLStore) GetThreadsPage(boardID, sortBy string, page PageRequest) ([]Thread, PageInfo, error) {
+	ts := threadSortFor(sortBy)
+	where, orderBy, reverse := keyset(ts.column, "t.id", ts.desc, page.Cursor)
+	
+	query := threadsQuery + `
+		WHERE t.board_id = ?`
+	args := []interface{}{boardID}
+	if where != "" {
+		key := s.keyArg(page.Cursor.Key, ts.isTime)
+		query += ` AND ` + where
+		args = append(args, key, key, page.Cursor.ID)
+	}
+	query += `
+		ORDER BY ` + orderBy + `
+		LIMIT ?`
+	args = append(args, page.Limit+1)
+	
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	
+	threads, err := scanThreads(rows)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	
+	hasMore := len(threads) > page.Limit
+	if hasMore {
+		threads = threads[:page.Limit]
+	}
+	if reverse {
+		for i, j := 0, len(threads)-1; i < j; i, j = i+1, j-1 {
+			threads[i], threads[j] = threads[j], threads[i]
+		}
+	}
+	if len(threads) == 0 {
+		return threads, PageInfo{}, nil
+	}
+	info := pageInfo(page, len(threads), hasMore, ts.cursor(&threads[0]), ts.cursor(&threads[len(threads)-1]))
+	return threads, info, nil
+}
+
+// scanThreads читает строки threadsQuery
+func scanThreads(rows *sql.Rows) ([]Thread, error) {
 	var threads []Thread
 	for rows.Next() {
 		var t Thread
-		if err := rows.Scan(&t.ID, &t.BoardID, &t.Subject, &t.CreatedAt, &t.BumpedAt, &t.PostCount); err != nil {
+		var opID sql.NullInt64
+		var opAuthor, opContent sql.NullString
+		var opCreatedAt sql.NullTime
+		var op Post
+		if err := rows.Scan(&t.ID, &t.BoardID, &t.Subject, &t.CreatedAt, &t.BumpedAt, &t.PostCount, &t.ImageCount,
+			&opID, &op.ParentID, &opAuthor, &opContent, &op.MediaPath, &op.MediaType, &op.MimeType, &op.ThumbPath, &op.Width, &op.Height, &op.MediaID, &opCreatedAt); err != nil {
 			return nil, err
 		}
 		
-		// Получаем первый пост (OP)
-		t.FirstPost, _ = GetFirstPost(t.ID)
+		// Первый пост (OP), если он есть
+		if opID.Valid {
+			op.ID = int(opID.Int64)
+			op.ThreadID = t.ID
+			op.Author = opAuthor.String
+			op.Content = opContent.String
+			op.CreatedAt = opCreatedAt.Time
+			t.FirstPost = &op
+		}
 		threads = append(threads, t)
 	}
-	return threads, nil
+	return threads, rows.Err()
 }
 
-// GetThread возвращает тред по ID
-func GetThread(id int) (*Thread, error) {
-	query := `SELECT id, board_id, subject, created_at, bumped_at FROM threads WHERE id = ?`
+// GetThread возвращает тред по ID вместе с количеством постов
+func (s *SQLStore) GetThread(id int) (*Thread, error) {
+	query := `
+		SELECT t.id, t.board_id, t.subject, t.created_at, t.bumped_at,
+		       ` + postCountColumn + ` as post_count
+		FROM threads t
+		WHERE t.id = ?`
 	
 	var t Thread
-	err := DB.QueryRow(query, id).Scan(&t.ID, &t.BoardID, &t.Subject, &t.CreatedAt, &t.BumpedAt)
+	err := s.db.QueryRow(query, id).Scan(&t.ID, &t.BoardID, &t.Subject, &t.CreatedAt, &t.BumpedAt, &t.PostCount)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -150,33 +318,61 @@ func GetThread(id int) (*Thread, error) {
 }
 
 // CreateThread создаёт новый тред и возвращает его ID
-func CreateThread(boardID, subject string) (int64, error) {
+func (s *SQLStore) CreateThread(boardID, subject string) (int64, error) {
 	query := `INSERT INTO threads (board_id, subject) VALUES (?, ?)`
-	result, err := DB.Exec(query, boardID, subject)
+	result, err := s.db.Exec(query, boardID, subject)
 	if err != nil {
 		return 0, err
 	}
 	return result.LastInsertId()
 }
 
+// CreateThreadWithOP в одной транзакции создаёт тред и его первый пост
+func (s *SQLStore) CreateThreadWithOP(boardID, subject, author, content string, media Media) (int64, int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	
+	result, err := tx.Exec(`INSERT INTO threads (board_id, subject) VALUES (?, ?)`, boardID, subject)
+	if err != nil {
+		return 0, 0, err
+	}
+	threadID, err := result.LastInsertId()
+	if err != nil {
+		return 0, 0, err
+	}
+	
+	postID, err := insertPost(tx, threadID, nil, author, content, media)
+	if err != nil {
+		return 0, 0, err
+	}
+	
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return threadID, postID, nil
+}
+
 // BumpThread обновляет время последнего бампа
-func BumpThread(threadID int) error {
+func (s *SQLStore) BumpThread(threadID int) error {
 	query := `UPDATE threads SET bumped_at = CURRENT_TIMESTAMP WHERE id = ?`
-	_, err := DB.Exec(query, threadID)
+	_, err := s.db.Exec(query, threadID)
 	return err
 }
 
 // === POSTS ===
 
 // GetPostsByThread возвращает все посты треда
-func GetPostsByThread(threadID int) ([]Post, error) {
+func (s *SQLStore) GetPostsByThread(threadID int) ([]Post, error) {
 	query := `
-		SELECT id, thread_id, parent_id, author, content, media_path, media_type, created_at
+		SELECT ` + postColumns("") + `
 		FROM posts
 		WHERE thread_id = ?
 		ORDER BY created_at ASC`
 	
-	rows, err := DB.Query(query, threadID)
+	rows, err := s.db.Query(query, threadID)
 	if err != nil {
 		return nil, err
 	}
@@ -185,29 +381,69 @@ func GetPostsByThread(threadID int) ([]Post, error) {
 	var posts []Post
 	for rows.Next() {
 		var p Post
-		if err := rows.Scan(&p.ID, &p.ThreadID, &p.ParentID, &p.Author, &p.Content,
-			&p.MediaPath, &p.MediaType, &p.CreatedAt); err != nil {
+		if err := rows.Scan(postDest(&p)...); err != nil {
 			return nil, err
 		}
 		posts = append(posts, p)
 	}
 	
 	// Вычисляем глубину для лесенки
-	return buildPostTree(posts), nil
+	return buildPostTree(posts, MaxPostDepth), nil
+}
+
+// GetPostsPage возвращает страницу постов треда в хронологическом порядке
+// (keyset-пагинация по created_at/id). Внутри страницы посты выстроены
+// лесенкой; ответы на посты с других страниц начинают свою ветку.
+func (s *SQLStore) GetPostsPage(threadID int, page PageRequest) ([]Post, PageInfo, error) {
+	where, orderBy, reverse := keyset("created_at", "id", false, page.Cursor)
+	
+	query := `
+		SELECT ` + postColumns("") + `
+		FROM posts
+		WHERE thread_id = ?`
+	args := []interface{}{threadID}
+	if where != "" {
+		key := s.keyArg(page.Cursor.Key, true)
+		query += ` AND ` + where
+		args = append(args, key, key, page.Cursor.ID)
+	}
+	query += `
+		ORDER BY ` + orderBy + `
+		LIMIT ?`
+	args = append(args, page.Limit+1)
+	
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	
+	var posts []Post
+	for rows.Next() {
+		var p Post
+		if err := rows.Scan(postDest(&p)...); err != nil {
+			return nil, PageInfo{}, err
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	
+	return postsPage(posts, page, reverse)
 }
 
 // GetFirstPost возвращает первый пост треда (OP)
-func GetFirstPost(threadID int) (*Post, error) {
+func (s *SQLStore) GetFirstPost(threadID int) (*Post, error) {
 	query := `
-		SELECT id, thread_id, parent_id, author, content, media_path, media_type, created_at
+		SELECT ` + postColumns("") + `
 		FROM posts
 		WHERE thread_id = ?
 		ORDER BY created_at ASC
 		LIMIT 1`
 	
 	var p Post
-	err := DB.QueryRow(query, threadID).Scan(&p.ID, &p.ThreadID, &p.ParentID, &p.Author,
-		&p.Content, &p.MediaPath, &p.MediaType, &p.CreatedAt)
+	err := s.db.QueryRow(query, threadID).Scan(postDest(&p)...)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -218,19 +454,51 @@ func GetFirstPost(threadID int) (*Post, error) {
 }
 
 // CreatePost создаёт новый пост и возвращает его ID
-func CreatePost(threadID int, parentID *int, author, content, mediaPath, mediaType string) (int64, error) {
-	var query string
-	var result sql.Result
-	var err error
-	
+func (s *SQLStore) CreatePost(threadID int, parentID *int, author, content string, media Media) (int64, error) {
+	var parent interface{}
 	if parentID != nil && *parentID > 0 {
-		query = `INSERT INTO posts (thread_id, parent_id, author, content, media_path, media_type) VALUES (?, ?, ?, ?, ?, ?)`
-		result, err = DB.Exec(query, threadID, *parentID, author, content, nullString(mediaPath), nullString(mediaType))
-	} else {
-		query = `INSERT INTO posts (thread_id, author, content, media_path, media_type) VALUES (?, ?, ?, ?, ?)`
-		result, err = DB.Exec(query, threadID, author, content, nullString(mediaPath), nullString(mediaType))
+		parent = *parentID
+	}
+	
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
 	}
+	defer tx.Rollback()
 	
+	postID, err := insertPost(tx, int64(threadID), parent, author, content, media)
+	if err != nil {
+		return 0, err
+	}
+	return postID, tx.Commit()
+}
+
+// insertPost добавляет пост и увеличивает счётчик ссылок его медиафайла
+func insertPost(tx *sql.Tx, threadID int64, parent interface{}, author, content string, media Media) (int64, error) {
+	if media.UploadToken != "" {
+		if err := useUploadToken(tx, media); err != nil {
+			return 0, err
+		}
+	}
+	// Счётчик ссылок увеличивается до вставки поста: UPDATE блокирует
+	// запись media до конца транзакции, а если сборщик успел её удалить,
+	// пост не создаётся (в SQLite posts.media_id без внешнего ключа)
+	if media.ID != 0 {
+		res, err := tx.Exec(`UPDATE media SET ref_count = ref_count + 1 WHERE id = ?`, media.ID)
+		if err != nil {
+			return 0, err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			if err == nil {
+				err = ErrMediaGone
+			}
+			return 0, err
+		}
+	}
+	query := `INSERT INTO posts (thread_id, parent_id, author, content, media_path, media_type, mime_type, thumb_path, media_width, media_height, media_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(query, threadID, parent, author, content,
+		nullString(media.Path), nullString(media.Type), nullString(media.MIME), nullString(media.ThumbPath),
+		nullInt(media.Width), nullInt(media.Height), nullInt(int(media.ID)))
 	if err != nil {
 		return 0, err
 	}
@@ -247,44 +515,103 @@ func nullString(s string) interface{} {
 	return s
 }
 
-// buildPostTree вычисляет глубину вложенности постов
-func buildPostTree(posts []Post) []Post {
+// nullInt возвращает nil для нуля
+func nullInt(n int) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+// MaxPostDepth максимальная отображаемая глубина лесенки.
+// Более глубокие ответы показываются на этом уровне в том же порядке.
+const MaxPostDepth = 10
+
+// postsPage обрезает выборку до страницы, восстанавливает хронологический
+// порядок и строит лесенку в пределах страницы
+func postsPage(posts []Post, page PageRequest, reverse bool) ([]Post, PageInfo, error) {
+	hasMore := len(posts) > page.Limit
+	if hasMore {
+		posts = posts[:page.Limit]
+	}
+	if reverse {
+		for i, j := 0, len(posts)-1; i < j; i, j = i+1, j-1 {
+			posts[i], posts[j] = posts[j], posts[i]
+		}
+	}
 	if len(posts) == 0 {
-		return posts
+		return posts, PageInfo{}, nil
 	}
+	info := pageInfo(page, len(posts), hasMore, postCursor(&posts[0]), postCursor(&posts[len(posts)-1]))
 	
-	// Создаём карту для быстрого поиска
-	postMap := make(map[int]*Post)
+	// Ответы на посты с других страниц считаются корнями своих веток
+	onPage := make(map[int]bool, len(posts))
 	for i := range posts {
-		postMap[posts[i].ID] = &posts[i]
+		onPage[posts[i].ID] = true
+	}
+	isRoot := func(p *Post) bool {
+		return !p.ParentID.Valid || !onPage[int(p.ParentID.Int64)]
+	}
+	return buildTree(posts, MaxPostDepth, isRoot), info, nil
+}
+
+// buildPostTree упорядочивает посты деревом (обход в глубину, дети в
+// исходном порядке) и вычисляет глубину вложенности, ограниченную maxDepth.
+// Посты с несуществующим parent и их потомки идут в конце с глубиной 0.
+// Работает за O(n) по индексу parent -> дети.
+func buildPostTree(posts []Post, maxDepth int) []Post {
+	return buildTree(posts, maxDepth, func(p *Post) bool { return !p.ParentID.Valid })
+}
+
+// buildTree строит лесенку, начиная обход с постов, для которых isRoot
+// возвращает true. Непосещённые посты добавляются в конец с глубиной 0.
+func buildTree(posts []Post, maxDepth int, isRoot func(p *Post) bool) []Post {
+	if len(posts) == 0 {
+		return posts
 	}
 	
-	// Вычисляем глубину
-	var result []Post
-	var addWithDepth func(post *Post, depth int)
+	// Индекс parent -> дети (позиции в posts в исходном порядке)
+	children := make(map[int][]int, len(posts))
+	for i := range posts {
+		if posts[i].ParentID.Valid {
+			parentID := int(posts[i].ParentID.Int64)
+			children[parentID] = append(children[parentID], i)
+		}
+	}
 	
-	processed := make(map[int]bool)
+	result := make([]Post, 0, len(posts))
+	processed := make(map[int]bool, len(posts))
 	
-	addWithDepth = func(post *Post, depth int) {
-		if processed[post.ID] {
-			return
-		}
-		processed[post.ID] = true
-		post.Depth = depth
-		result = append(result, *post)
-		
-		// Добавляем дочерние посты
-		for i := range posts {
-			if posts[i].ParentID.Valid && int(posts[i].ParentID.Int64) == post.ID {
-				addWithDepth(&posts[i], depth+1)
-			}
-		}
+	// Явный стек вместо рекурсии: цепочки ответов могут быть очень длинными
+	type entry struct {
+		index int
+		depth int
 	}
+	var stack []entry
 	
-	// Начинаем с корневых постов (без parent)
+	// Начинаем с корневых постов
 	for i := range posts {
-		if !posts[i].ParentID.Valid {
-			addWithDepth(&posts[i], 0)
+		if !isRoot(&posts[i]) {
+			continue
+		}
+		stack = append(stack[:0], entry{i, 0})
+		for len(stack) > 0 {
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			
+			post := &posts[e.index]
+			if processed[post.ID] {
+				continue
+			}
+			processed[post.ID] = true
+			post.Depth = min(e.depth, maxDepth)
+			result = append(result, *post)
+			
+			// Дети кладутся в обратном порядке, чтобы сниматься в исходном
+			kids := children[post.ID]
+			for k := len(kids) - 1; k >= 0; k-- {
+				stack = append(stack, entry{kids[k], e.depth + 1})
+			}
 		}
 	}
 	
@@ -298,3 +625,20 @@ func buildPostTree(posts []Post) []Post {
 	
 	return result
 }
+
+// FlattenPosts переводит посты в плоский режим: хронологический порядок
+// без лесенки. Используется для тредов с очень глубокими цепочками.
+func FlattenPosts(posts []Post) []Post {
+	flat := make([]Post, len(posts))
+	copy(flat, posts)
+	sort.SliceStable(flat, func(i, j int) bool {
+		if !flat[i].CreatedAt.Equal(flat[j].CreatedAt) {
+			return flat[i].CreatedAt.Before(flat[j].CreatedAt)
+		}
+		return flat[i].ID < flat[j].ID
+	})
+	for i := range flat {
+		flat[i].Depth = 0
+	}
+	return flat
+}
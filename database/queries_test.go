@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"modernc.org/sqlite"
+)
+
+// queryCount количество запросов, прошедших через countingDriver
+var queryCount atomic.Int64
+
+// countingDriver оборачивает драйвер SQLite и считает подготовленные запросы.
+// Обёртка соединения не реализует QueryerContext, поэтому database/sql
+// проводит каждый запрос через Prepare.
+type countingDriver struct {
+	sqlite.Driver
+}
+
+type countingConn struct {
+	driver.Conn
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{conn}, nil
+}
+
+func (c countingConn) Prepare(query string) (driver.Stmt, error) {
+	queryCount.Add(1)
+	return c.Conn.Prepare(query)
+}
+
+func init() {
+	sql.Register("sqlite-counting", &countingDriver{})
+}
+
+// newBenchStore создаёт SQLite-хранилище с доской из n тредов
+func newBenchStore(b *testing.B, threads int) *SQLStore {
+	b.Helper()
+
+	db, err := sql.Open("sqlite-counting", sqliteDSN(filepath.Join(b.TempDir(), "bench.db")))
+	if err != nil {
+		b.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	s := &SQLStore{db: db, driver: DriverSQLite}
+	b.Cleanup(func() { s.Close() })
+
+	if _, err := s.MigrateUp(0); err != nil {
+		b.Fatal(err)
+	}
+	if err := s.CreateBoard("b", "Бред", "", DefaultBoardSettings()); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < threads; i++ {
+		threadID, opID, err := s.CreateThreadWithOP("b", fmt.Sprintf("Тред %d", i), "Аноним", "OP", Media{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		parentID := int(opID)
+		if _, err := s.CreatePost(int(threadID), &parentID, "Аноним", "ответ", Media{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return s
+}
+
+// BenchmarkGetThreadsByBoard показывает, что число запросов на вызов
+// (метрика queries/op) не растёт вместе с количеством тредов
+func BenchmarkGetThreadsByBoard(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("threads=%d", n), func(b *testing.B) {
+			s := newBenchStore(b, n)
+
+			queryCount.Store(0)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				threads, err := s.GetThreadsByBoard("b", "bump")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(threads) != n || threads[0].FirstPost == nil || threads[0].PostCount != 2 {
+					b.Fatalf("неожиданный результат: %d тредов", len(threads))
+				}
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(queryCount.Load())/float64(b.N), "queries/op")
+		})
+	}
+}
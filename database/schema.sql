@@ -1,3 +1,6 @@
+-- Код сгенерирован из database/migrations.go; НЕ РЕДАКТИРОВАТЬ.
+-- Обновление: go generate ./database
+
 -- Создание базы данных
 CREATE DATABASE IF NOT EXISTS webforum
 CHARACTER SET utf8mb4
@@ -5,43 +8,108 @@ COLLATE utf8mb4_unicode_ci;
 
 USE webforum;
 
--- Таблица досок
+-- Учёт применённых миграций
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Миграция 1: init
 CREATE TABLE IF NOT EXISTS boards (
-    id VARCHAR(50) PRIMARY KEY,
-    name VARCHAR(255) NOT NULL,
-    description TEXT,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	id VARCHAR(50) PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	description TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
--- Таблица тредов
 CREATE TABLE IF NOT EXISTS threads (
-    id INT AUTO_INCREMENT PRIMARY KEY,
-    board_id VARCHAR(50) NOT NULL,
-    subject VARCHAR(255) NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    bumped_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-    FOREIGN KEY (board_id) REFERENCES boards(id) ON DELETE CASCADE,
-    INDEX idx_board_bumped (board_id, bumped_at DESC)
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	board_id VARCHAR(50) NOT NULL,
+	subject VARCHAR(255) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	bumped_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	FOREIGN KEY (board_id) REFERENCES boards(id) ON DELETE CASCADE,
+	INDEX idx_board_bumped (board_id, bumped_at DESC)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
--- Таблица постов/комментариев
 CREATE TABLE IF NOT EXISTS posts (
-    id INT AUTO_INCREMENT PRIMARY KEY,
-    thread_id INT NOT NULL,
-    parent_id INT DEFAULT NULL,
-    author VARCHAR(100) DEFAULT 'Аноним',
-    content TEXT NOT NULL,
-    media_path VARCHAR(500),
-    media_type VARCHAR(20),
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (thread_id) REFERENCES threads(id) ON DELETE CASCADE,
-    FOREIGN KEY (parent_id) REFERENCES posts(id) ON DELETE SET NULL,
-    INDEX idx_thread (thread_id),
-    INDEX idx_parent (parent_id)
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	thread_id INT NOT NULL,
+	parent_id INT DEFAULT NULL,
+	author VARCHAR(100) DEFAULT 'Аноним',
+	content TEXT NOT NULL,
+	media_path VARCHAR(500),
+	media_type VARCHAR(20),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (thread_id) REFERENCES threads(id) ON DELETE CASCADE,
+	FOREIGN KEY (parent_id) REFERENCES posts(id) ON DELETE SET NULL,
+	INDEX idx_thread (thread_id),
+	INDEX idx_parent (parent_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+INSERT INTO schema_migrations (version, name) VALUES (1, 'init');
+
+-- Миграция 2: search
+ALTER TABLE posts ADD FULLTEXT INDEX ft_posts_content (content);
+
+ALTER TABLE threads ADD FULLTEXT INDEX ft_threads_subject (subject);
+
+INSERT INTO schema_migrations (version, name) VALUES (2, 'search');
+
+-- Миграция 3: thumbnails
+ALTER TABLE posts ADD COLUMN thumb_path VARCHAR(500) AFTER media_type;
+
+INSERT INTO schema_migrations (version, name) VALUES (3, 'thumbnails');
+
+-- Миграция 4: mime_type
+ALTER TABLE posts ADD COLUMN mime_type VARCHAR(100) AFTER media_type;
+
+INSERT INTO schema_migrations (version, name) VALUES (4, 'mime_type');
+
+-- Миграция 5: media_metadata
+ALTER TABLE boards ADD COLUMN strip_metadata BOOLEAN NOT NULL DEFAULT TRUE;
+
+ALTER TABLE posts ADD COLUMN media_width INT AFTER thumb_path, ADD COLUMN media_height INT AFTER media_width;
+
+INSERT INTO schema_migrations (version, name) VALUES (5, 'media_metadata');
+
+-- Миграция 6: media
+CREATE TABLE IF NOT EXISTS media (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	hash CHAR(64) NOT NULL,
+	path VARCHAR(500) NOT NULL,
+	media_type VARCHAR(20) NOT NULL,
+	mime_type VARCHAR(100) NOT NULL,
+	thumb_path VARCHAR(500),
+	width INT,
+	height INT,
+	size BIGINT NOT NULL,
+	ref_count INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE INDEX idx_media_hash (hash)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
--- Примеры начальных данных (опционально)
--- INSERT INTO boards (id, name, description) VALUES
---     ('b', 'Pair', 'Pair of random topics'),
---     ('pr', 'Программирование', 'Pair of programming topics');
+ALTER TABLE posts ADD COLUMN media_id INT AFTER media_height,
+	ADD CONSTRAINT fk_posts_media FOREIGN KEY (media_id) REFERENCES media(id) ON DELETE SET NULL;
+
+INSERT INTO schema_migrations (version, name) VALUES (6, 'media');
+
+-- Миграция 7: upload_tokens
+CREATE TABLE IF NOT EXISTS upload_tokens (
+	token_hash CHAR(64) PRIMARY KEY,
+	media_id INT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	used_at DATETIME NULL DEFAULT NULL,
+	FOREIGN KEY (media_id) REFERENCES media(id) ON DELETE CASCADE,
+	INDEX idx_upload_tokens_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+INSERT INTO schema_migrations (version, name) VALUES (7, 'upload_tokens');
+
+-- Миграция 8: board_media_policy
+ALTER TABLE boards ADD COLUMN media_types VARCHAR(50) NOT NULL DEFAULT 'image,video,audio' AFTER strip_metadata,
+	ADD COLUMN max_file_size BIGINT NOT NULL DEFAULT 104857600 AFTER media_types;
 
+INSERT INTO schema_migrations (version, name) VALUES (8, 'board_media_policy');
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxSearchTerms ограничение на число слов в поисковом запросе
+const maxSearchTerms = 10
+
+// SearchQuery параметры полнотекстового поиска
+type SearchQuery struct {
+	Text     string // поисковая строка
+	BoardID  string // пусто - все доски
+	Author   string // пусто - любой автор
+	HasMedia *bool  // nil - не важно
+}
+
+// SearchResult найденный пост вместе с тредом, в котором он лежит.
+// Совпадение по теме треда засчитывается его первому посту (OP).
+type SearchResult struct {
+	Post
+	Subject string
+	BoardID string
+}
+
+// SearchTerms разбивает строку запроса на слова в нижнем регистре.
+// Символы, не являющиеся буквами и цифрами, считаются разделителями,
+// поэтому операторы MySQL и FTS5 в запрос пользователя не попадают.
+func SearchTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool)
+	var terms []string
+	for _, w := range words {
+		if seen[w] {
+			continue
+		}
+		seen[w] = true
+		terms = append(terms, w)
+		if len(terms) == maxSearchTerms {
+			break
+		}
+	}
+	return terms
+}
+
+// matchExpr строка для MATCH: все слова обязательны, ищутся по префиксу
+func (s *SQLStore) matchExpr(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		if s.driver == DriverSQLite {
+			parts[i] = `"` + t + `"*`
+		} else {
+			parts[i] = "+" + t + "*"
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// matchConditions условия совпадения текста поста и темы треда
+func (s *SQLStore) matchConditions() (content, subject string) {
+	if s.driver == DriverSQLite {
+		return `p.id IN (SELECT rowid FROM posts_fts WHERE posts_fts MATCH ?)`,
+			`t.id IN (SELECT rowid FROM threads_fts WHERE threads_fts MATCH ?)`
+	}
+	return `MATCH(p.content) AGAINST (? IN BOOLEAN MODE)`,
+		`MATCH(t.subject) AGAINST (? IN BOOLEAN MODE)`
+}
+
+// Search ищет посты по тексту и темам тредов, новые первыми
+// (keyset-пагинация по created_at/id)
+func (s *SQLStore) Search(q SearchQuery, page PageRequest) ([]SearchResult, PageInfo, error) {
+	terms := SearchTerms(q.Text)
+	if len(terms) == 0 {
+		return nil, PageInfo{}, nil
+	}
+
+	expr := s.matchExpr(terms)
+	contentMatch, subjectMatch := s.matchConditions()
+
+	query := `
+		SELECT ` + postColumns("p") + `, t.subject, t.board_id
+		FROM posts p
+		JOIN threads t ON t.id = p.thread_id
+		WHERE (` + contentMatch + `
+			OR (` + subjectMatch + ` AND p.id = (SELECT MIN(op.id) FROM posts op WHERE op.thread_id = t.id)))`
+	args := []interface{}{expr, expr}
+
+	if q.BoardID != "" {
+		query += ` AND t.board_id = ?`
+		args = append(args, q.BoardID)
+	}
+	if q.Author != "" {
+		query += ` AND p.author = ?`
+		args = append(args, q.Author)
+	}
+	if q.HasMedia != nil {
+		if *q.HasMedia {
+			query += ` AND p.media_path IS NOT NULL`
+		} else {
+			query += ` AND p.media_path IS NULL`
+		}
+	}
+
+	where, orderBy, reverse := keyset("p.created_at", "p.id", true, page.Cursor)
+	if where != "" {
+		key := s.keyArg(page.Cursor.Key, true)
+		query += ` AND ` + where
+		args = append(args, key, key, page.Cursor.ID)
+	}
+	query += `
+		ORDER BY ` + orderBy + `
+		LIMIT ?`
+	args = append(args, page.Limit+1)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(append(postDest(&r.Post), &r.Subject, &r.BoardID)...); err != nil {
+			return nil, PageInfo{}, err
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	return searchPage(results, page, reverse)
+}
+
+// searchPage обрезает лишнюю запись, восстанавливает порядок при
+// движении назад и вычисляет курсоры соседних страниц
+func searchPage(results []SearchResult, page PageRequest, reverse bool) ([]SearchResult, PageInfo, error) {
+	hasMore := len(results) > page.Limit
+	if hasMore {
+		results = results[:page.Limit]
+	}
+	if reverse {
+		for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+			results[i], results[j] = results[j], results[i]
+		}
+	}
+	if len(results) == 0 {
+		return results, PageInfo{}, nil
+	}
+	first, last := postCursor(&results[0].Post), postCursor(&results[len(results)-1].Post)
+	return results, pageInfo(page, len(results), hasMore, first, last), nil
+}
+
+// containsTerms проверяет, что текст содержит все слова запроса
+func containsTerms(text string, terms []string) bool {
+	text = strings.ToLower(text)
+	for _, t := range terms {
+		if !strings.Contains(text, t) {
+			return false
+		}
+	}
+	return true
+}
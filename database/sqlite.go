@@ -0,0 +1,39 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	_ "modernc.org/sqlite"
+)
+
+// connectSQLite открывает (или создаёт) файл БД SQLite
+func connectSQLite(cfg Config) (*SQLStore, error) {
+	path := cfg.Path
+	if path == "" {
+		path = "webforum.db"
+	}
+
+	db, err := sql.Open("sqlite", sqliteDSN(path))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия соединения: %w", err)
+	}
+
+	// SQLite допускает только одного писателя
+	db.SetMaxOpenConns(1)
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ошибка подключения к БД: %w", err)
+	}
+
+	log.Printf("✓ Подключение к SQLite успешно (%s)", path)
+	return &SQLStore{db: db, driver: DriverSQLite}, nil
+}
+
+// sqliteDSN строка подключения к файлу БД SQLite.
+// Внешние ключи в SQLite по умолчанию выключены, поэтому включаем явно.
+func sqliteDSN(path string) string {
+	return fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+}
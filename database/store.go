@@ -0,0 +1,67 @@
+package database
+
+import "time"
+
+// Media медиафайл. Файлы хранятся по SHA-256 содержимого, поэтому
+// одинаковые загрузки разделяют одну запись таблицы media.
+type Media struct {
+	ID        int64  // 0 - файл не записан в таблицу media
+	Hash      string // SHA-256 содержимого, hex
+	Path      string // публичный путь /uploads/...
+	Type      string // image, video или audio
+	MIME      string // MIME-тип, определённый по содержимому
+	ThumbPath string // миниатюра; пусто, если её нет
+	Width     int    // размеры картинки, px; 0 - неизвестны
+	Height    int
+	Size      int64 // размер файла, байт
+	RefCount  int   // число постов, ссылающихся на файл
+	CreatedAt time.Time
+
+	// UploadToken SHA-256 токена загрузки, которым файл прикрепляется к
+	// посту. Токен расходуется в одной транзакции с созданием поста, так
+	// что несозданный пост его не сжигает. В таблице media не хранится.
+	UploadToken string
+}
+
+// Store хранилище досок, тредов и постов.
+// Обработчики работают только через этот интерфейс, поэтому форум можно
+// запускать поверх разных бэкендов и тестировать без живой БД.
+type Store interface {
+	// Доски
+	GetAllBoards() ([]Board, error)
+	GetBoard(id string) (*Board, error)
+	CreateBoard(id, name, description string, settings BoardSettings) error
+	UpdateBoardSettings(id string, settings BoardSettings) error
+
+	// Треды
+	GetThreadsByBoard(boardID, sortBy string) ([]Thread, error)
+	GetThreadsPage(boardID, sortBy string, page PageRequest) ([]Thread, PageInfo, error)
+	GetThread(id int) (*Thread, error)
+	CreateThread(boardID, subject string) (int64, error)
+	CreateThreadWithOP(boardID, subject, author, content string, media Media) (threadID, postID int64, err error)
+	BumpThread(threadID int) error
+
+	// Посты
+	GetPostsByThread(threadID int) ([]Post, error)
+	GetPostsPage(threadID int, page PageRequest) ([]Post, PageInfo, error)
+	GetFirstPost(threadID int) (*Post, error)
+	CreatePost(threadID int, parentID *int, author, content string, media Media) (int64, error)
+
+	// Медиафайлы
+	GetMediaByHash(hash string) (*Media, error)
+	CreateMedia(m Media) (*Media, error)
+	LeaseMedia(hash string, until time.Time) (*Media, error)
+	ReferencedMediaPaths() (map[string]bool, error)
+	DeleteMedia(id int64) (bool, error)
+
+	// Токены загрузки
+	CreateUploadToken(tokenHash string, mediaID int64, expiresAt time.Time) error
+	GetUploadToken(tokenHash string) (*Media, error)
+	DeleteExpiredUploadTokens(before time.Time) (int64, error)
+
+	// Поиск
+	Search(q SearchQuery, page PageRequest) ([]SearchResult, PageInfo, error)
+
+	// Close освобождает ресурсы хранилища
+	Close() error
+}
@@ -0,0 +1,547 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStores пустые хранилища всех бэкендов: MemoryStore и SQLStore
+// поверх SQLite во временном каталоге
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+
+	sqlStore, err := connectSQLite(Config{Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlStore.Close() })
+	if _, err := sqlStore.MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"sqlite": sqlStore,
+	}
+}
+
+// forEachStore запускает f подтестом для каждого бэкенда
+func forEachStore(t *testing.T, f func(t *testing.T, s Store)) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) { f(t, s) })
+	}
+}
+
+// createTestMedia запись media для файла с хешем из символа c
+func createTestMedia(t *testing.T, s Store, c string) Media {
+	t.Helper()
+	hash := strings.Repeat(c, 64)
+	m, err := s.CreateMedia(Media{
+		Hash: hash,
+		Path: "/uploads/" + hash[:2] + "/" + hash[2:4] + "/" + hash + ".png",
+		Type: "image",
+		MIME: "image/png",
+		Size: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *m
+}
+
+func TestUploadTokenUsedWithPost(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		if err := s.CreateBoard("b", "Бред", "", DefaultBoardSettings()); err != nil {
+			t.Fatal(err)
+		}
+		threadID, _, err := s.CreateThreadWithOP("b", "Тред", "Аноним", "OP", Media{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		media := createTestMedia(t, s, "a")
+		other := createTestMedia(t, s, "b")
+
+		expires := time.Now().Add(time.Hour)
+		for hash, m := range map[string]Media{"token": media, "other": other} {
+			if err := s.CreateUploadToken(hash, m.ID, expires); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.CreateUploadToken("expired", media.ID, time.Now().Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+
+		withToken := func(m Media, token string) Media {
+			m.UploadToken = token
+			return m
+		}
+
+		// Пост не создан - токен остаётся действующим
+		missing := 1 << 20
+		if _, err := s.CreatePost(int(threadID), &missing, "Аноним", "ответ", withToken(media, "token")); err == nil {
+			t.Fatal("создан ответ на несуществующий пост")
+		}
+		if _, _, err := s.CreateThreadWithOP("nope", "Тред", "Аноним", "OP", withToken(media, "token")); err == nil {
+			t.Fatal("создан тред на несуществующей доске")
+		}
+		if _, err := s.GetUploadToken("token"); err != nil {
+			t.Fatalf("токен израсходован неудачным постом: %v", err)
+		}
+
+		if _, err := s.CreatePost(int(threadID), nil, "Аноним", "ответ", withToken(media, "token")); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name  string
+			media Media
+			want  error
+		}{
+			{"повторно", withToken(media, "token"), ErrUploadTokenUsed},
+			{"неизвестный", withToken(media, "nope"), ErrUploadTokenUnknown},
+			{"чужой файл", withToken(media, "other"), ErrUploadTokenUnknown},
+			{"истёкший", withToken(media, "expired"), ErrUploadTokenExpired},
+		}
+		for _, tt := range tests {
+			if _, err := s.CreatePost(int(threadID), nil, "Аноним", "ответ", tt.media); !errors.Is(err, tt.want) {
+				t.Errorf("пост, токен %s: %v, ожидалась %v", tt.name, err, tt.want)
+			}
+			if _, _, err := s.CreateThreadWithOP("b", "Тред", "Аноним", "OP", tt.media); !errors.Is(err, tt.want) {
+				t.Errorf("тред, токен %s: %v, ожидалась %v", tt.name, err, tt.want)
+			}
+		}
+
+		m, err := s.GetMediaByHash(media.Hash)
+		if err != nil || m == nil {
+			t.Fatalf("медиафайл: %v, %v", m, err)
+		}
+		if m.RefCount != 1 {
+			t.Errorf("ref_count = %d, ожидался 1", m.RefCount)
+		}
+		if _, err := s.GetUploadToken("other"); err != nil {
+			t.Errorf("токен другого файла израсходован: %v", err)
+		}
+	})
+}
+
+// Сборщик неиспользуемых файлов и повторная загрузка того же файла
+// работают с записью media одновременно: арендованную запись сборщик не
+// удаляет, а пост с уже удалённой записью не создаётся
+func TestLeaseMedia(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		if err := s.CreateBoard("b", "Бред", "", DefaultBoardSettings()); err != nil {
+			t.Fatal(err)
+		}
+		threadID, _, err := s.CreateThreadWithOP("b", "Тред", "Аноним", "OP", Media{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		leased := createTestMedia(t, s, "a")
+		expired := createTestMedia(t, s, "b")
+		gone := createTestMedia(t, s, "c")
+
+		m, err := s.LeaseMedia(leased.Hash, time.Now().Add(time.Hour))
+		if err != nil || m == nil || m.ID != leased.ID {
+			t.Fatalf("LeaseMedia: %+v, %v", m, err)
+		}
+		if deleted, err := s.DeleteMedia(leased.ID); err != nil || deleted {
+			t.Fatalf("арендованная запись удалена: %v, %v", deleted, err)
+		}
+		if _, err := s.LeaseMedia(expired.Hash, time.Now().Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if deleted, err := s.DeleteMedia(expired.ID); err != nil || !deleted {
+			t.Fatalf("запись с истёкшей арендой не удалена: %v, %v", deleted, err)
+		}
+
+		// Сборщик удалил запись раньше: аренды нет, файл нужно сохранить заново
+		if deleted, err := s.DeleteMedia(gone.ID); err != nil || !deleted {
+			t.Fatalf("DeleteMedia: %v, %v", deleted, err)
+		}
+		for _, hash := range []string{gone.Hash, strings.Repeat("d", 64)} {
+			if m, err := s.LeaseMedia(hash, time.Now().Add(time.Hour)); err != nil || m != nil {
+				t.Errorf("LeaseMedia(%s…) = %+v, %v; ожидался nil", hash[:4], m, err)
+			}
+		}
+
+		if _, err := s.CreatePost(int(threadID), nil, "Аноним", "ответ", gone); !errors.Is(err, ErrMediaGone) {
+			t.Errorf("пост с удалённой записью: %v, ожидалась %v", err, ErrMediaGone)
+		}
+		if _, _, err := s.CreateThreadWithOP("b", "Тред", "Аноним", "OP", gone); !errors.Is(err, ErrMediaGone) {
+			t.Errorf("тред с удалённой записью: %v, ожидалась %v", err, ErrMediaGone)
+		}
+		if posts, err := s.GetPostsByThread(int(threadID)); err != nil || len(posts) != 1 {
+			t.Errorf("постов в треде: %d, %v; ожидался 1", len(posts), err)
+		}
+		if threads, err := s.GetThreadsByBoard("b", "bump"); err != nil || len(threads) != 1 {
+			t.Errorf("тредов на доске: %d, %v; ожидался 1", len(threads), err)
+		}
+
+		// Арендованная запись прикрепляется к посту как обычно
+		if _, err := s.CreatePost(int(threadID), nil, "Аноним", "ответ", *m); err != nil {
+			t.Fatal(err)
+		}
+		if m, err := s.GetMediaByHash(leased.Hash); err != nil || m == nil || m.RefCount != 1 {
+			t.Errorf("медиафайл после поста: %+v, %v", m, err)
+		}
+	})
+}
+
+// setThreadTime выставляет треду время создания и бампа. CURRENT_TIMESTAMP
+// в SQLite хранится с точностью до секунды, поэтому порядок тредов,
+// созданных в тесте подряд, задаётся явно.
+func setThreadTime(t *testing.T, s Store, threadID int64, at time.Time) {
+	t.Helper()
+	switch st := s.(type) {
+	case *SQLStore:
+		key := st.keyArg(at.UnixNano(), true)
+		if _, err := st.db.Exec(`UPDATE threads SET created_at = ?, bumped_at = ? WHERE id = ?`, key, key, threadID); err != nil {
+			t.Fatal(err)
+		}
+	case *MemoryStore:
+		st.mu.Lock()
+		th := st.threads[int(threadID)]
+		th.CreatedAt, th.BumpedAt = at, at
+		st.threads[int(threadID)] = th
+		st.mu.Unlock()
+	default:
+		t.Fatalf("неизвестное хранилище %T", s)
+	}
+}
+
+// createTestThreads создаёт на доске boardID треды с OP; i-й тред создан
+// на i часов позже первого, все - в прошлом
+func createTestThreads(t *testing.T, s Store, boardID string, subjects ...string) []int64 {
+	t.Helper()
+	base := time.Now().Add(-time.Duration(len(subjects)+1) * time.Hour)
+	ids := make([]int64, len(subjects))
+	for i, subject := range subjects {
+		id, _, err := s.CreateThreadWithOP(boardID, subject, "Аноним", "OP "+subject, Media{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		setThreadTime(t, s, id, base.Add(time.Duration(i)*time.Hour))
+		ids[i] = id
+	}
+	return ids
+}
+
+// createTestPost создаёт пост и возвращает его ID
+func createTestPost(t *testing.T, s Store, threadID int64, parentID int64, author, content string, media Media) int64 {
+	t.Helper()
+	var parent *int
+	if parentID != 0 {
+		p := int(parentID)
+		parent = &p
+	}
+	id, err := s.CreatePost(int(threadID), parent, author, content, media)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func threadSubjects(threads []Thread) []string {
+	subjects := make([]string, len(threads))
+	for i, th := range threads {
+		subjects[i] = th.Subject
+	}
+	return subjects
+}
+
+func TestThreadOrdering(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		for _, id := range []string{"b", "a"} {
+			if err := s.CreateBoard(id, id, "", DefaultBoardSettings()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids := createTestThreads(t, s, "b", "первый", "второй", "третий", "четвёртый")
+		createTestThreads(t, s, "a", "чужой")
+
+		// Ответы без бампа (sage) меняют только число постов
+		createTestPost(t, s, ids[1], 0, "Аноним", "ответ", Media{})
+		createTestPost(t, s, ids[1], 0, "Аноним", "ответ", Media{Path: "/uploads/x.png", Type: "image"})
+		if err := s.BumpThread(int(ids[0])); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			sortBy string
+			want   []string
+		}{
+			{"bump", []string{"первый", "четвёртый", "третий", "второй"}},
+			{"", []string{"первый", "четвёртый", "третий", "второй"}},
+			{"new", []string{"четвёртый", "третий", "второй", "первый"}},
+			{"old", []string{"первый", "второй", "третий", "четвёртый"}},
+			{"replies", []string{"второй", "четвёртый", "третий", "первый"}},
+		}
+		for _, tt := range tests {
+			threads, err := s.GetThreadsByBoard("b", tt.sortBy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := threadSubjects(threads); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("sort=%q: %v, ожидалось %v", tt.sortBy, got, tt.want)
+			}
+		}
+
+		threads, err := s.GetThreadsByBoard("b", "replies")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if th := threads[0]; th.PostCount != 3 || th.ImageCount != 1 || th.FirstPost == nil || th.FirstPost.Content != "OP второй" {
+			t.Errorf("тред %q: постов %d, картинок %d, OP %+v", th.Subject, th.PostCount, th.ImageCount, th.FirstPost)
+		}
+	})
+}
+
+func TestThreadsPagination(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		if err := s.CreateBoard("b", "b", "", DefaultBoardSettings()); err != nil {
+			t.Fatal(err)
+		}
+		ids := createTestThreads(t, s, "b", "1", "2", "3", "4", "5", "6", "7")
+		for i, id := range ids {
+			for j := 0; j < i%3; j++ {
+				createTestPost(t, s, id, 0, "Аноним", "ответ", Media{})
+			}
+		}
+
+		for _, sortBy := range []string{"bump", "new", "old", "replies"} {
+			all, err := s.GetThreadsByBoard("b", sortBy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := strings.Join(threadSubjects(all), ",")
+
+			// Вперёд по next, затем назад по prev
+			var forward, backward []string
+			var pages []PageInfo
+			page := PageRequest{Limit: 3}
+			for {
+				threads, info, err := s.GetThreadsPage("b", sortBy, page)
+				if err != nil {
+					t.Fatal(err)
+				}
+				forward = append(forward, threadSubjects(threads)...)
+				pages = append(pages, info)
+				if info.Next == "" {
+					break
+				}
+				if page.Cursor, err = DecodeCursor(info.Next); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := strings.Join(forward, ","); got != want {
+				t.Errorf("sort=%s вперёд: %s, ожидалось %s", sortBy, got, want)
+			}
+			if len(pages) != 3 || pages[0].Prev != "" {
+				t.Errorf("sort=%s: %d страниц, prev первой %q", sortBy, len(pages), pages[0].Prev)
+			}
+
+			for prev := pages[len(pages)-1].Prev; prev != ""; {
+				cursor, err := DecodeCursor(prev)
+				if err != nil {
+					t.Fatal(err)
+				}
+				threads, info, err := s.GetThreadsPage("b", sortBy, PageRequest{Limit: 3, Cursor: cursor})
+				if err != nil {
+					t.Fatal(err)
+				}
+				backward = append(threadSubjects(threads), backward...)
+				prev = info.Prev
+			}
+			if got := strings.Join(backward, ","); got != strings.Join(forward[:6], ",") {
+				t.Errorf("sort=%s назад: %s, ожидалось %s", sortBy, got, strings.Join(forward[:6], ","))
+			}
+		}
+	})
+}
+
+func TestPostTree(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		if err := s.CreateBoard("b", "b", "", DefaultBoardSettings()); err != nil {
+			t.Fatal(err)
+		}
+		threadID, opID, err := s.CreateThreadWithOP("b", "Тред", "Аноним", "op", Media{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := createTestPost(t, s, threadID, 0, "Аноним", "a", Media{})
+		createTestPost(t, s, threadID, opID, "Аноним", "op.1", Media{})
+		ab := createTestPost(t, s, threadID, a, "Аноним", "a.1", Media{})
+		createTestPost(t, s, threadID, 0, "Аноним", "b", Media{})
+		createTestPost(t, s, threadID, ab, "Аноним", "a.1.1", Media{})
+		createTestPost(t, s, threadID, a, "Аноним", "a.2", Media{})
+
+		// Цепочка глубже MaxPostDepth
+		parent := opID
+		for i := 0; i < MaxPostDepth+2; i++ {
+			parent = createTestPost(t, s, threadID, parent, "Аноним", "chain", Media{})
+		}
+
+		posts, err := s.GetPostsByThread(int(threadID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, p := range posts {
+			if p.Content == "chain" {
+				if p.Depth > MaxPostDepth {
+					t.Errorf("глубина %d больше MaxPostDepth", p.Depth)
+				}
+				continue
+			}
+			got = append(got, p.Content+":"+strconv.Itoa(p.Depth))
+		}
+		want := "op:0,op.1:1,a:0,a.1:1,a.1.1:2,a.2:1,b:0"
+		if strings.Join(got, ",") != want {
+			t.Errorf("лесенка %s, ожидалось %s", strings.Join(got, ","), want)
+		}
+		if len(posts) != 7+MaxPostDepth+2 {
+			t.Errorf("постов %d", len(posts))
+		}
+		if last := posts[len(posts)-1]; last.Content == "chain" && last.Depth != MaxPostDepth {
+			t.Errorf("конец цепочки на глубине %d, ожидалась %d", last.Depth, MaxPostDepth)
+		}
+
+		op, err := s.GetFirstPost(int(threadID))
+		if err != nil || op == nil || op.ID != int(opID) {
+			t.Errorf("первый пост: %+v, %v", op, err)
+		}
+	})
+}
+
+func TestPostsPagination(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		if err := s.CreateBoard("b", "b", "", DefaultBoardSettings()); err != nil {
+			t.Fatal(err)
+		}
+		threadID, _, err := s.CreateThreadWithOP("b", "Тред", "Аноним", "0", Media{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < 7; i++ {
+			createTestPost(t, s, threadID, 0, "Аноним", strconv.Itoa(i), Media{})
+		}
+
+		var got []string
+		var last PageInfo
+		page := PageRequest{Limit: 3}
+		for {
+			posts, info, err := s.GetPostsPage(int(threadID), page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range posts {
+				got = append(got, p.Content)
+			}
+			last = info
+			if info.Next == "" {
+				break
+			}
+			if page.Cursor, err = DecodeCursor(info.Next); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if strings.Join(got, ",") != "0,1,2,3,4,5,6" {
+			t.Errorf("посты по страницам: %v", got)
+		}
+
+		cursor, err := DecodeCursor(last.Prev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		posts, _, err := s.GetPostsPage(int(threadID), PageRequest{Limit: 3, Cursor: cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var prev []string
+		for _, p := range posts {
+			prev = append(prev, p.Content)
+		}
+		if strings.Join(prev, ",") != "3,4,5" {
+			t.Errorf("предыдущая страница: %v", prev)
+		}
+	})
+}
+
+func TestSearch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		for _, id := range []string{"b", "a"} {
+			if err := s.CreateBoard(id, id, "", DefaultBoardSettings()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cats, _, err := s.CreateThreadWithOP("b", "Рыжие коты", "Аноним", "первый пост", Media{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		createTestPost(t, s, cats, 0, "Аноним", "котики спят", Media{})
+		createTestPost(t, s, cats, 0, "Вася", "коты и собаки", Media{Path: "/uploads/x.png", Type: "image"})
+		createTestPost(t, s, cats, 0, "Аноним", "про погоду", Media{})
+		if _, _, err := s.CreateThreadWithOP("a", "Собаки", "Аноним", "большие собаки", Media{}); err != nil {
+			t.Fatal(err)
+		}
+
+		yes := true
+		tests := []struct {
+			name  string
+			query SearchQuery
+			want  []string // текст найденных постов, новые первыми
+		}{
+			{"префикс слова и тема OP", SearchQuery{Text: "кот"}, []string{"коты и собаки", "котики спят", "первый пост"}},
+			{"без учёта регистра", SearchQuery{Text: "КОТЫ"}, []string{"коты и собаки", "первый пост"}},
+			{"тема и текст OP один раз", SearchQuery{Text: "собаки"}, []string{"большие собаки", "коты и собаки"}},
+			{"все слова", SearchQuery{Text: "коты собаки"}, []string{"коты и собаки"}},
+			{"доска", SearchQuery{Text: "собаки", BoardID: "a"}, []string{"большие собаки"}},
+			{"автор", SearchQuery{Text: "кот", Author: "Вася"}, []string{"коты и собаки"}},
+			{"с файлом", SearchQuery{Text: "кот", HasMedia: &yes}, []string{"коты и собаки"}},
+			{"нет совпадений", SearchQuery{Text: "рыба"}, nil},
+			{"только знаки", SearchQuery{Text: `"*+-`}, nil},
+		}
+		for _, tt := range tests {
+			results, _, err := s.Search(tt.query, PageRequest{Limit: 10})
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			var got []string
+			for _, r := range results {
+				got = append(got, r.Content)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("%s: %q, ожидалось %q", tt.name, got, tt.want)
+			}
+		}
+
+		results, _, err := s.Search(SearchQuery{Text: "кот"}, PageRequest{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := results[len(results)-1]; r.Subject != "Рыжие коты" || r.BoardID != "b" || r.ThreadID != int(cats) {
+			t.Errorf("результат без треда: %+v", r)
+		}
+
+		// Постранично: 2 + 1
+		first, info, err := s.Search(SearchQuery{Text: "кот"}, PageRequest{Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor, err := DecodeCursor(info.Next)
+		if len(first) != 2 || err != nil {
+			t.Fatalf("первая страница: %d результатов, next %q", len(first), info.Next)
+		}
+		second, info, err := s.Search(SearchQuery{Text: "кот"}, PageRequest{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(second) != 1 || second[0].Content != "первый пост" || info.Next != "" || info.Prev == "" {
+			t.Errorf("вторая страница: %+v, %+v", second, info)
+		}
+	})
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -13,30 +14,50 @@ import (
 
 // APIResponse стандартный ответ API
 type APIResponse struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Success    bool        `json:"success"`
+	Data       interface{} `json:"data,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	PrevCursor string      `json:"prev_cursor,omitempty"`
 }
 
 // BoardResponse доска для API
 type BoardResponse struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	ThreadCount int    `json:"thread_count"`
-	CreatedAt   string `json:"created_at"`
+	ID            string              `json:"id"`
+	Name          string              `json:"name"`
+	Description   string              `json:"description"`
+	ThreadCount   int                 `json:"thread_count"`
+	StripMetadata bool                `json:"strip_metadata"`
+	Media         MediaPolicyResponse `json:"media"` // какие файлы принимает доска
+	CreatedAt     string              `json:"created_at"`
 }
 
 // ThreadResponse тред для API
 type ThreadResponse struct {
-	ID        int            `json:"id"`
-	BoardID   string         `json:"board_id"`
-	Subject   string         `json:"subject"`
-	PostCount int            `json:"post_count"`
-	CreatedAt string         `json:"created_at"`
-	BumpedAt  string         `json:"bumped_at"`
-	FirstPost *PostResponse  `json:"first_post,omitempty"`
-	Posts     []PostResponse `json:"posts,omitempty"`
+	ID         int            `json:"id"`
+	BoardID    string         `json:"board_id"`
+	Subject    string         `json:"subject"`
+	PostCount  int            `json:"post_count"`
+	ImageCount int            `json:"image_count"`
+	CreatedAt  string         `json:"created_at"`
+	BumpedAt   string         `json:"bumped_at"`
+	FirstPost  *PostResponse  `json:"first_post,omitempty"`
+	Posts      []PostResponse `json:"posts,omitempty"`
+}
+
+// CatalogThreadResponse тред в каталоге доски
+type CatalogThreadResponse struct {
+	ID         int    `json:"id"`
+	Subject    string `json:"subject"`
+	ReplyCount int    `json:"reply_count"`
+	ImageCount int    `json:"image_count"`
+	CreatedAt  string `json:"created_at"`
+	BumpedAt   string `json:"bumped_at"`
+	Author     string `json:"author,omitempty"`
+	Excerpt    string `json:"excerpt,omitempty"`
+	MediaPath  string `json:"media_path,omitempty"`
+	MediaType  string `json:"media_type,omitempty"`
+	ThumbPath  string `json:"thumb_path,omitempty"`
 }
 
 // PostResponse пост для API
@@ -48,15 +69,42 @@ type PostResponse struct {
 	Content   string `json:"content"`
 	MediaPath string `json:"media_path,omitempty"`
 	MediaType string `json:"media_type,omitempty"`
+	MimeType  string `json:"mime_type,omitempty"`
+	ThumbPath string `json:"thumb_path,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
 	CreatedAt string `json:"created_at"`
 	Depth     int    `json:"depth"`
 }
 
+// newPostResponse преобразует пост из БД в ответ API
+func newPostResponse(p *database.Post) PostResponse {
+	parentID := 0
+	if p.ParentID.Valid {
+		parentID = int(p.ParentID.Int64)
+	}
+	return PostResponse{
+		ID:        p.ID,
+		ThreadID:  p.ThreadID,
+		ParentID:  parentID,
+		Author:    p.Author,
+		Content:   p.Content,
+		MediaPath: p.MediaPath.String,
+		MediaType: p.MediaType.String,
+		MimeType:  p.MimeType.String,
+		ThumbPath: p.ThumbPath.String,
+		Width:     int(p.Width.Int64),
+		Height:    int(p.Height.Int64),
+		CreatedAt: p.CreatedAt.Format(time.RFC3339),
+		Depth:     p.Depth,
+	}
+}
+
 // Helper для отправки JSON
 func sendJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
@@ -66,37 +114,87 @@ func sendSuccess(w http.ResponseWriter, data interface{}) {
 	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: data})
 }
 
+// sendPage отправляет страницу данных с курсорами соседних страниц
+func sendPage(w http.ResponseWriter, data interface{}, info database.PageInfo) {
+	sendJSON(w, http.StatusOK, APIResponse{
+		Success:    true,
+		Data:       data,
+		NextCursor: info.Next,
+		PrevCursor: info.Prev,
+	})
+}
+
 func sendError(w http.ResponseWriter, status int, message string) {
 	sendJSON(w, status, APIResponse{Success: false, Error: message})
 }
 
+// MediaResponse медиафайл для API
+type MediaResponse struct {
+	Hash      string `json:"hash"`
+	Path      string `json:"path"`
+	Type      string `json:"type"`
+	MimeType  string `json:"mime_type"`
+	ThumbPath string `json:"thumb_path,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Size      int64  `json:"size"`
+	RefCount  int    `json:"ref_count"`
+	CreatedAt string `json:"created_at"`
+
+	// Одноразовый токен для media_token при создании треда или поста;
+	// выдаётся только в ответ на загрузку файла
+	Token          string `json:"token,omitempty"`
+	TokenExpiresAt string `json:"token_expires_at,omitempty"`
+}
+
+// newMediaResponse преобразует медиафайл из БД в ответ API
+func newMediaResponse(m database.Media) MediaResponse {
+	return MediaResponse{
+		Hash:      m.Hash,
+		Path:      m.Path,
+		Type:      m.Type,
+		MimeType:  m.MIME,
+		ThumbPath: m.ThumbPath,
+		Width:     m.Width,
+		Height:    m.Height,
+		Size:      m.Size,
+		RefCount:  m.RefCount,
+		CreatedAt: m.CreatedAt.Format(time.RFC3339),
+	}
+}
+
 // ============ API HANDLERS ============
 
 // APIBoardsRouter роутер для /api/v1/boards/{id}
-func APIBoardsRouter(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) APIBoardsRouter(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/api/v1/boards/")
 
 	if strings.Contains(path, "/threads") {
-		APIGetThreads(w, r)
+		h.APIGetThreads(w, r)
+		return
+	}
+
+	if strings.HasSuffix(path, "/catalog") {
+		h.APIGetCatalog(w, r)
 		return
 	}
 
-	APIGetBoard(w, r)
+	h.APIGetBoard(w, r)
 }
 
 // APIGetBoards GET /api/v1/boards - получить все доски, POST - создать
-func APIGetBoards(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) APIGetBoards(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "OPTIONS" {
 		sendJSON(w, http.StatusOK, nil)
 		return
 	}
 
 	if r.Method == "POST" {
-		APICreateBoard(w, r)
+		h.APICreateBoard(w, r)
 		return
 	}
 
-	boards, err := database.GetAllBoards()
+	boards, err := h.store.GetAllBoards()
 	if err != nil {
 		log.Printf("API: ошибка получения досок: %v", err)
 		sendError(w, http.StatusInternalServerError, "Ошибка получения досок")
@@ -106,11 +204,13 @@ func APIGetBoards(w http.ResponseWriter, r *http.Request) {
 	var response []BoardResponse
 	for _, b := range boards {
 		response = append(response, BoardResponse{
-			ID:          b.ID,
-			Name:        b.Name,
-			Description: b.Description,
-			ThreadCount: b.ThreadCount,
-			CreatedAt:   b.CreatedAt.Format(time.RFC3339),
+			ID:            b.ID,
+			Name:          b.Name,
+			Description:   b.Description,
+			ThreadCount:   b.ThreadCount,
+			StripMetadata: b.Settings.StripMetadata,
+			Media:         newMediaPolicyResponse(b.Settings),
+			CreatedAt:     b.CreatedAt.Format(time.RFC3339),
 		})
 	}
 
@@ -118,7 +218,7 @@ func APIGetBoards(w http.ResponseWriter, r *http.Request) {
 }
 
 // APIGetBoard GET /api/v1/boards/{id} - получить доску
-func APIGetBoard(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) APIGetBoard(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "OPTIONS" {
 		sendJSON(w, http.StatusOK, nil)
 		return
@@ -130,7 +230,7 @@ func APIGetBoard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	board, err := database.GetBoard(boardID)
+	board, err := h.store.GetBoard(boardID)
 	if err != nil {
 		sendError(w, http.StatusInternalServerError, "Ошибка получения доски")
 		return
@@ -140,25 +240,74 @@ func APIGetBoard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.Method == http.MethodPatch {
+		h.APIUpdateBoard(w, r, board)
+		return
+	}
+
 	sendSuccess(w, BoardResponse{
-		ID:          board.ID,
-		Name:        board.Name,
-		Description: board.Description,
-		CreatedAt:   board.CreatedAt.Format(time.RFC3339),
+		ID:            board.ID,
+		Name:          board.Name,
+		Description:   board.Description,
+		StripMetadata: board.Settings.StripMetadata,
+		Media:         newMediaPolicyResponse(board.Settings),
+		CreatedAt:     board.CreatedAt.Format(time.RFC3339),
 	})
 }
 
+// APIUpdateBoard PATCH /api/v1/boards/{id} - изменить настройки доски.
+// Поля, которых нет в запросе, не меняются.
+func (h *Handler) APIUpdateBoard(w http.ResponseWriter, r *http.Request, board *database.Board) {
+	var req struct {
+		StripMetadata *bool     `json:"strip_metadata"`
+		MediaTypes    *[]string `json:"media_types"`
+		MaxFileSize   *int64    `json:"max_file_size"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Неверный формат данных")
+		return
+	}
+
+	settings := board.Settings
+	if req.StripMetadata != nil {
+		settings.StripMetadata = *req.StripMetadata
+	}
+	if req.MediaTypes != nil {
+		settings.MediaTypes = *req.MediaTypes
+	}
+	if req.MaxFileSize != nil {
+		settings.MaxFileSize = *req.MaxFileSize
+	}
+	if err := validateMediaSettings(settings); err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	settings.MediaTypes = normalizeMediaTypes(settings.MediaTypes)
+
+	if err := h.store.UpdateBoardSettings(board.ID, settings); err != nil {
+		log.Printf("API: ошибка изменения доски: %v", err)
+		sendError(w, http.StatusInternalServerError, "Ошибка изменения доски")
+		return
+	}
+
+	sendSuccess(w, map[string]string{"id": board.ID, "message": "Настройки доски сохранены"})
+}
+
 // APICreateBoard POST /api/v1/boards - создать доску
-func APICreateBoard(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) APICreateBoard(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "OPTIONS" {
 		sendJSON(w, http.StatusOK, nil)
 		return
 	}
 
 	var req struct {
-		ID          string `json:"id"`
-		Name        string `json:"name"`
-		Description string `json:"description"`
+		ID            string    `json:"id"`
+		Name          string    `json:"name"`
+		Description   string    `json:"description"`
+		StripMetadata *bool     `json:"strip_metadata"` // по умолчанию true
+		MediaTypes    *[]string `json:"media_types"`    // по умолчанию все
+		MaxFileSize   *int64    `json:"max_file_size"`  // по умолчанию 100 MB
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -183,13 +332,29 @@ func APICreateBoard(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Проверяем существование
-	existing, _ := database.GetBoard(req.ID)
+	existing, _ := h.store.GetBoard(req.ID)
 	if existing != nil {
 		sendError(w, http.StatusConflict, "Доска с таким ID уже существует")
 		return
 	}
 
-	if err := database.CreateBoard(req.ID, req.Name, req.Description); err != nil {
+	settings := database.DefaultBoardSettings()
+	if req.StripMetadata != nil {
+		settings.StripMetadata = *req.StripMetadata
+	}
+	if req.MediaTypes != nil {
+		settings.MediaTypes = *req.MediaTypes
+	}
+	if req.MaxFileSize != nil {
+		settings.MaxFileSize = *req.MaxFileSize
+	}
+	if err := validateMediaSettings(settings); err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	settings.MediaTypes = normalizeMediaTypes(settings.MediaTypes)
+
+	if err := h.store.CreateBoard(req.ID, req.Name, req.Description, settings); err != nil {
 		sendError(w, http.StatusInternalServerError, "Ошибка создания доски")
 		return
 	}
@@ -198,7 +363,7 @@ func APICreateBoard(w http.ResponseWriter, r *http.Request) {
 }
 
 // APIGetThreads GET /api/v1/boards/{id}/threads - получить треды доски
-func APIGetThreads(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) APIGetThreads(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "OPTIONS" {
 		sendJSON(w, http.StatusOK, nil)
 		return
@@ -214,7 +379,7 @@ func APIGetThreads(w http.ResponseWriter, r *http.Request) {
 	boardID := parts[0]
 
 	// Проверяем доску
-	board, _ := database.GetBoard(boardID)
+	board, _ := h.store.GetBoard(boardID)
 	if board == nil {
 		sendError(w, http.StatusNotFound, "Доска не найдена")
 		return
@@ -225,7 +390,13 @@ func APIGetThreads(w http.ResponseWriter, r *http.Request) {
 		sortBy = "bump"
 	}
 
-	threads, err := database.GetThreadsByBoard(boardID, sortBy)
+	page, err := parsePage(r, defaultThreadsLimit)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	threads, pageInfo, err := h.store.GetThreadsPage(boardID, sortBy, page)
 	if err != nil {
 		sendError(w, http.StatusInternalServerError, "Ошибка получения тредов")
 		return
@@ -234,49 +405,82 @@ func APIGetThreads(w http.ResponseWriter, r *http.Request) {
 	var response []ThreadResponse
 	for _, t := range threads {
 		tr := ThreadResponse{
-			ID:        t.ID,
-			BoardID:   t.BoardID,
-			Subject:   t.Subject,
-			PostCount: t.PostCount,
-			CreatedAt: t.CreatedAt.Format(time.RFC3339),
-			BumpedAt:  t.BumpedAt.Format(time.RFC3339),
+			ID:         t.ID,
+			BoardID:    t.BoardID,
+			Subject:    t.Subject,
+			PostCount:  t.PostCount,
+			ImageCount: t.ImageCount,
+			CreatedAt:  t.CreatedAt.Format(time.RFC3339),
+			BumpedAt:   t.BumpedAt.Format(time.RFC3339),
 		}
 
 		if t.FirstPost != nil {
-			parentID := 0
-			if t.FirstPost.ParentID.Valid {
-				parentID = int(t.FirstPost.ParentID.Int64)
-			}
-			mediaPath := ""
-			if t.FirstPost.MediaPath.Valid {
-				mediaPath = t.FirstPost.MediaPath.String
-			}
-			mediaType := ""
-			if t.FirstPost.MediaType.Valid {
-				mediaType = t.FirstPost.MediaType.String
-			}
-
-			tr.FirstPost = &PostResponse{
-				ID:        t.FirstPost.ID,
-				ThreadID:  t.FirstPost.ThreadID,
-				ParentID:  parentID,
-				Author:    t.FirstPost.Author,
-				Content:   t.FirstPost.Content,
-				MediaPath: mediaPath,
-				MediaType: mediaType,
-				CreatedAt: t.FirstPost.CreatedAt.Format(time.RFC3339),
-				Depth:     t.FirstPost.Depth,
-			}
+			op := newPostResponse(t.FirstPost)
+			tr.FirstPost = &op
 		}
 
 		response = append(response, tr)
 	}
 
+	sendPage(w, response, pageInfo)
+}
+
+// catalogExcerptLength длина текста OP в каталоге (в символах)
+const catalogExcerptLength = 150
+
+// APIGetCatalog GET /api/v1/boards/{id}/catalog - каталог доски
+func (h *Handler) APIGetCatalog(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		sendJSON(w, http.StatusOK, nil)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/boards/")
+	boardID := strings.TrimSuffix(path, "/catalog")
+
+	board, _ := h.store.GetBoard(boardID)
+	if board == nil {
+		sendError(w, http.StatusNotFound, "Доска не найдена")
+		return
+	}
+
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy == "" {
+		sortBy = "bump"
+	}
+
+	threads, err := h.store.GetThreadsByBoard(boardID, sortBy)
+	if err != nil {
+		log.Printf("API: ошибка получения каталога: %v", err)
+		sendError(w, http.StatusInternalServerError, "Ошибка получения каталога")
+		return
+	}
+
+	response := make([]CatalogThreadResponse, 0, len(threads))
+	for _, t := range threads {
+		ct := CatalogThreadResponse{
+			ID:         t.ID,
+			Subject:    t.Subject,
+			ReplyCount: max(t.PostCount-1, 0),
+			ImageCount: t.ImageCount,
+			CreatedAt:  t.CreatedAt.Format(time.RFC3339),
+			BumpedAt:   t.BumpedAt.Format(time.RFC3339),
+		}
+		if t.FirstPost != nil {
+			ct.Author = t.FirstPost.Author
+			ct.Excerpt = truncate(t.FirstPost.Content, catalogExcerptLength)
+			ct.MediaPath = t.FirstPost.MediaPath.String
+			ct.MediaType = t.FirstPost.MediaType.String
+			ct.ThumbPath = t.FirstPost.ThumbPath.String
+		}
+		response = append(response, ct)
+	}
+
 	sendSuccess(w, response)
 }
 
 // APIGetThread GET /api/v1/threads/{id} - получить тред с постами
-func APIGetThread(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) APIGetThread(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "OPTIONS" {
 		sendJSON(w, http.StatusOK, nil)
 		return
@@ -289,73 +493,61 @@ func APIGetThread(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	thread, err := database.GetThread(threadID)
+	thread, err := h.store.GetThread(threadID)
 	if err != nil || thread == nil {
 		sendError(w, http.StatusNotFound, "Тред не найден")
 		return
 	}
 
-	posts, err := database.GetPostsByThread(threadID)
+	page, err := parsePage(r, defaultPostsLimit)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	posts, pageInfo, err := h.store.GetPostsPage(threadID, page)
 	if err != nil {
 		sendError(w, http.StatusInternalServerError, "Ошибка получения постов")
 		return
 	}
 
-	var postsResponse []PostResponse
-	for _, p := range posts {
-		parentID := 0
-		if p.ParentID.Valid {
-			parentID = int(p.ParentID.Int64)
-		}
-		mediaPath := ""
-		if p.MediaPath.Valid {
-			mediaPath = p.MediaPath.String
-		}
-		mediaType := ""
-		if p.MediaType.Valid {
-			mediaType = p.MediaType.String
-		}
+	// ?view=flat - хронологический список без лесенки
+	if r.URL.Query().Get("view") == "flat" {
+		posts = database.FlattenPosts(posts)
+	}
 
-		postsResponse = append(postsResponse, PostResponse{
-			ID:        p.ID,
-			ThreadID:  p.ThreadID,
-			ParentID:  parentID,
-			Author:    p.Author,
-			Content:   p.Content,
-			MediaPath: mediaPath,
-			MediaType: mediaType,
-			CreatedAt: p.CreatedAt.Format(time.RFC3339),
-			Depth:     p.Depth,
-		})
+	var postsResponse []PostResponse
+	for i := range posts {
+		postsResponse = append(postsResponse, newPostResponse(&posts[i]))
 	}
 
 	response := ThreadResponse{
 		ID:        thread.ID,
 		BoardID:   thread.BoardID,
 		Subject:   thread.Subject,
-		PostCount: len(posts),
+		PostCount: thread.PostCount,
 		CreatedAt: thread.CreatedAt.Format(time.RFC3339),
 		BumpedAt:  thread.BumpedAt.Format(time.RFC3339),
 		Posts:     postsResponse,
 	}
 
-	sendSuccess(w, response)
+	sendPage(w, response, pageInfo)
 }
 
 // APICreateThread POST /api/v1/threads - создать тред
-func APICreateThread(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) APICreateThread(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "OPTIONS" {
 		sendJSON(w, http.StatusOK, nil)
 		return
 	}
 
 	var req struct {
-		BoardID   string `json:"board_id"`
-		Subject   string `json:"subject"`
-		Author    string `json:"author"`
-		Content   string `json:"content"`
-		MediaPath string `json:"media_path"`
-		MediaType string `json:"media_type"`
+		BoardID    string `json:"board_id"`
+		Subject    string `json:"subject"`
+		Author     string `json:"author"`
+		Content    string `json:"content"`
+		MediaToken string `json:"media_token"` // токен из /api/v1/upload
+		MediaPath  string `json:"media_path"`  // не принимается, только для понятной ошибки
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -372,33 +564,45 @@ func APICreateThread(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.MediaPath != "" {
+		sendError(w, http.StatusBadRequest, "media_path не принимается, передайте media_token из /api/v1/upload")
+		return
+	}
+
 	if req.Author == "" {
 		req.Author = "Аноним"
 	}
 
 	// Проверяем доску
-	board, _ := database.GetBoard(req.BoardID)
+	board, _ := h.store.GetBoard(req.BoardID)
 	if board == nil {
 		sendError(w, http.StatusNotFound, "Доска не найдена")
 		return
 	}
 
-	// Создаём тред
-	threadID, err := database.CreateThread(req.BoardID, req.Subject)
-	if err != nil {
-		sendError(w, http.StatusInternalServerError, "Ошибка создания треда")
+	media, ok := h.mediaFromToken(w, req.MediaToken, board.Settings)
+	if !ok {
 		return
 	}
 
-	// Создаём первый пост
-	postID, err := database.CreatePost(int(threadID), nil, req.Author, req.Content, req.MediaPath, req.MediaType)
+	// Создаём тред вместе с первым постом; токен расходуется в той же транзакции
+	threadID, postID, err := h.store.CreateThreadWithOP(req.BoardID, req.Subject, req.Author, req.Content, media)
+	if isUploadTokenError(err) {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrMediaGone) {
+		sendError(w, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
-		sendError(w, http.StatusInternalServerError, "Ошибка создания поста")
+		log.Printf("API: ошибка создания треда: %v", err)
+		sendError(w, http.StatusInternalServerError, "Ошибка создания треда")
 		return
 	}
 
 	// WebSocket уведомление
-	WsHub.BroadcastToBoard(req.BoardID, WSMessage{
+	h.hub.BroadcastToBoard(req.BoardID, WSMessage{
 		Type:     "new_thread",
 		ThreadID: int(threadID),
 		BoardID:  req.BoardID,
@@ -412,19 +616,19 @@ func APICreateThread(w http.ResponseWriter, r *http.Request) {
 }
 
 // APICreatePost POST /api/v1/posts - создать пост
-func APICreatePost(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) APICreatePost(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "OPTIONS" {
 		sendJSON(w, http.StatusOK, nil)
 		return
 	}
 
 	var req struct {
-		ThreadID  int    `json:"thread_id"`
-		ParentID  int    `json:"parent_id"`
-		Author    string `json:"author"`
-		Content   string `json:"content"`
-		MediaPath string `json:"media_path"`
-		MediaType string `json:"media_type"`
+		ThreadID   int    `json:"thread_id"`
+		ParentID   int    `json:"parent_id"`
+		Author     string `json:"author"`
+		Content    string `json:"content"`
+		MediaToken string `json:"media_token"` // токен из /api/v1/upload
+		MediaPath  string `json:"media_path"`  // не принимается, только для понятной ошибки
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -440,12 +644,17 @@ func APICreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.MediaPath != "" {
+		sendError(w, http.StatusBadRequest, "media_path не принимается, передайте media_token из /api/v1/upload")
+		return
+	}
+
 	if req.Author == "" {
 		req.Author = "Аноним"
 	}
 
 	// Проверяем тред
-	thread, _ := database.GetThread(req.ThreadID)
+	thread, _ := h.store.GetThread(req.ThreadID)
 	if thread == nil {
 		sendError(w, http.StatusNotFound, "Тред не найден")
 		return
@@ -457,31 +666,44 @@ func APICreatePost(w http.ResponseWriter, r *http.Request) {
 		parentID = &req.ParentID
 	}
 
-	postID, err := database.CreatePost(req.ThreadID, parentID, req.Author, req.Content, req.MediaPath, req.MediaType)
+	media, ok := h.mediaFromToken(w, req.MediaToken, h.boardSettings(thread.BoardID))
+	if !ok {
+		return
+	}
+	postID, err := h.store.CreatePost(req.ThreadID, parentID, req.Author, req.Content, media)
+	if isUploadTokenError(err) {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrMediaGone) {
+		sendError(w, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
 		sendError(w, http.StatusInternalServerError, "Ошибка создания поста")
 		return
 	}
 
 	// Бампаем тред
-	database.BumpThread(req.ThreadID)
+	h.store.BumpThread(req.ThreadID)
 
 	// WebSocket уведомление
-	WsHub.BroadcastToThread(req.ThreadID, WSMessage{
+	h.hub.BroadcastToThread(req.ThreadID, WSMessage{
 		Type:     "new_post",
 		ThreadID: req.ThreadID,
 		Data: map[string]interface{}{
 			"id":         postID,
 			"author":     req.Author,
 			"content":    req.Content,
-			"media_path": req.MediaPath,
-			"media_type": req.MediaType,
+			"media_path": media.Path,
+			"media_type": media.Type,
+			"thumb_path": media.ThumbPath,
 			"parent_id":  req.ParentID,
 			"created_at": time.Now().Format("02.01.2006 15:04:05"),
 		},
 	})
 
-	WsHub.BroadcastToBoard(thread.BoardID, WSMessage{
+	h.hub.BroadcastToBoard(thread.BoardID, WSMessage{
 		Type:     "thread_updated",
 		ThreadID: req.ThreadID,
 		BoardID:  thread.BoardID,
@@ -500,24 +722,62 @@ func (h *Handler) APIUploadMedia(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
 	if err := r.ParseMultipartForm(100 << 20); err != nil {
-		sendError(w, http.StatusBadRequest, "Ошибка парсинга формы")
+		sendError(w, uploadErrorStatus(err), "Ошибка парсинга формы")
+		return
+	}
+
+	settings, ok := h.uploadBoardSettings(w, r.FormValue("board_id"))
+	if !ok {
 		return
 	}
 
-	fileInfo, err := saveFile(r, "media", time.Now().UnixNano())
+	media, err := h.saveFile(r, "media", settings)
 	if err != nil {
-		sendError(w, http.StatusBadRequest, err.Error())
+		sendError(w, uploadErrorStatus(err), err.Error())
 		return
 	}
 
-	if fileInfo == nil {
+	if media.Path == "" {
 		sendError(w, http.StatusBadRequest, "Файл не загружен")
 		return
 	}
 
-	sendSuccess(w, map[string]string{
-		"path": fileInfo.Path,
-		"type": fileInfo.Type,
-	})
+	resp, err := h.uploadedMediaResponse(media)
+	if err != nil {
+		log.Printf("API: ошибка выдачи токена загрузки: %v", err)
+		h.discardMedia(media)
+		sendError(w, http.StatusInternalServerError, "Ошибка загрузки файла")
+		return
+	}
+	sendSuccess(w, resp)
+}
+
+// APIGetMedia GET /api/v1/media/{hash} - медиафайл по SHA-256 содержимого.
+// Клиент может проверить, загружен ли файл, и не отправлять его повторно.
+func (h *Handler) APIGetMedia(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		sendJSON(w, http.StatusOK, nil)
+		return
+	}
+
+	hash := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/api/v1/media/"))
+	if hash == "" {
+		sendError(w, http.StatusBadRequest, "Хеш не указан")
+		return
+	}
+
+	media, err := h.store.GetMediaByHash(hash)
+	if err != nil {
+		log.Printf("API: ошибка получения медиафайла: %v", err)
+		sendError(w, http.StatusInternalServerError, "Ошибка получения медиафайла")
+		return
+	}
+	if media == nil {
+		sendError(w, http.StatusNotFound, "Медиафайл не найден")
+		return
+	}
+
+	sendSuccess(w, newMediaResponse(*media))
 }
@@ -0,0 +1,722 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"webForum/database"
+	"webForum/storage"
+)
+
+// newTestHandler обработчики поверх MemoryStore и локального хранилища
+// файлов во временном каталоге. Шаблоны не загружаются: тесты вызывают
+// только API.
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	return &Handler{
+		store:   database.NewMemoryStore(),
+		media:   storage.NewLocalStore(t.TempDir()),
+		uploads: newUploadSessions(t.TempDir()),
+		hub:     NewHub(WSConfig{}),
+	}
+}
+
+// createTestBoard создаёт доску с разрешёнными типами файлов types
+func createTestBoard(t *testing.T, h *Handler, id string, types ...string) {
+	t.Helper()
+	settings := database.DefaultBoardSettings()
+	settings.MediaTypes = types
+	if err := h.store.CreateBoard(id, id, "", settings); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testPNG картинка 8×8 в формате PNG
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7)
+	}
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// uploadRequest multipart-запрос к /api/v1/upload
+func uploadRequest(t *testing.T, boardID, filename string, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if boardID != "" {
+		mw.WriteField("board_id", boardID)
+	}
+	fw, err := mw.CreateFormFile("media", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/upload", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+// jsonRequest POST-запрос с телом v в JSON
+func jsonRequest(t *testing.T, url string, v interface{}) *http.Request {
+	t.Helper()
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+// serve выполняет запрос и разбирает ответ APIResponse; data - в out
+func serve(t *testing.T, handler http.HandlerFunc, r *http.Request, out interface{}) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	var resp struct {
+		Success bool            `json:"success"`
+		Data    json.RawMessage `json:"data"`
+		Error   string          `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("ответ не JSON (%d): %s", w.Code, w.Body.String())
+	}
+	if out != nil && resp.Success {
+		if err := json.Unmarshal(resp.Data, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code
+}
+
+// uploadToken загружает картинку на доску boardID и возвращает токен
+func uploadToken(t *testing.T, h *Handler, boardID string) string {
+	t.Helper()
+	var media MediaResponse
+	if code := serve(t, h.APIUploadMedia, uploadRequest(t, boardID, "pic.png", testPNG(t)), &media); code != http.StatusOK {
+		t.Fatalf("загрузка: код %d", code)
+	}
+	if media.Token == "" {
+		t.Fatal("загрузка не вернула токен")
+	}
+	return media.Token
+}
+
+func TestAPIUploadRequiresBoard(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+
+	tests := []struct {
+		boardID string
+		want    int
+	}{
+		{"", http.StatusBadRequest},
+		{"nope", http.StatusNotFound},
+		{"b", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := serve(t, h.APIUploadMedia, uploadRequest(t, tt.boardID, "pic.png", testPNG(t)), nil); code != tt.want {
+			t.Errorf("board_id=%q: код %d, ожидался %d", tt.boardID, code, tt.want)
+		}
+	}
+}
+
+// Файл, загруженный для одной доски, проверяется по политике доски, куда
+// его прикрепляют
+func TestAPIMediaTokenCrossBoardPolicy(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "pics", database.AllMediaTypes...)
+	createTestBoard(t, h, "music", "audio")
+	musicThread, _, err := h.store.CreateThreadWithOP("music", "Тред", "Аноним", "OP", database.Media{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := uploadToken(t, h, "pics")
+
+	post := map[string]interface{}{"thread_id": musicThread, "content": "картинка", "media_token": token}
+	if code := serve(t, h.APICreatePost, jsonRequest(t, "/api/v1/posts", post), nil); code != http.StatusUnsupportedMediaType {
+		t.Errorf("пост на аудио-доску: код %d, ожидался 415", code)
+	}
+	thread := map[string]interface{}{"board_id": "music", "subject": "s", "content": "картинка", "media_token": token}
+	if code := serve(t, h.APICreateThread, jsonRequest(t, "/api/v1/threads", thread), nil); code != http.StatusUnsupportedMediaType {
+		t.Errorf("тред на аудио-доске: код %d, ожидался 415", code)
+	}
+
+	// Отклонённый токен не израсходован
+	thread["board_id"] = "pics"
+	if code := serve(t, h.APICreateThread, jsonRequest(t, "/api/v1/threads", thread), nil); code != http.StatusOK {
+		t.Errorf("тред на доске загрузки: код %d, ожидался 200", code)
+	}
+}
+
+func TestAPIMediaTokenCrossBoardSizeLimit(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "pics", database.AllMediaTypes...)
+	settings := database.DefaultBoardSettings()
+	settings.MaxFileSize = 10
+	if err := h.store.CreateBoard("tiny", "tiny", "", settings); err != nil {
+		t.Fatal(err)
+	}
+
+	token := uploadToken(t, h, "pics")
+	thread := map[string]interface{}{"board_id": "tiny", "subject": "s", "content": "картинка", "media_token": token}
+	if code := serve(t, h.APICreateThread, jsonRequest(t, "/api/v1/threads", thread), nil); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("код %d, ожидался 413", code)
+	}
+}
+
+func TestAPIMediaTokenSurvivesFailedPost(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+	threadID, _, err := h.store.CreateThreadWithOP("b", "Тред", "Аноним", "OP", database.Media{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := uploadToken(t, h, "b")
+	post := map[string]interface{}{"thread_id": threadID, "parent_id": 1 << 20, "content": "ответ", "media_token": token}
+	if code := serve(t, h.APICreatePost, jsonRequest(t, "/api/v1/posts", post), nil); code != http.StatusInternalServerError {
+		t.Fatalf("ответ на несуществующий пост: код %d, ожидался 500", code)
+	}
+
+	delete(post, "parent_id")
+	if code := serve(t, h.APICreatePost, jsonRequest(t, "/api/v1/posts", post), nil); code != http.StatusOK {
+		t.Fatalf("повтор после ошибки: код %d, ожидался 200", code)
+	}
+	if code := serve(t, h.APICreatePost, jsonRequest(t, "/api/v1/posts", post), nil); code != http.StatusBadRequest {
+		t.Errorf("повторное использование токена: код %d, ожидался 400", code)
+	}
+}
+
+// createUpload создаёт сессию возобновляемой загрузки файла size байт
+func createUpload(t *testing.T, h *Handler, filename string, size int, boardID string) UploadResponse {
+	t.Helper()
+	var upload UploadResponse
+	create := jsonRequest(t, "/api/v1/uploads", map[string]interface{}{"filename": filename, "size": size, "board_id": boardID})
+	if code := serve(t, h.APIUploads, create, &upload); code != http.StatusCreated {
+		t.Fatalf("создание сессии: код %d", code)
+	}
+	return upload
+}
+
+// patchUpload дописывает data в сессию id с заголовком Upload-Offset: offset
+func patchUpload(h *Handler, id string, offset int, data []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPatch, "/api/v1/uploads/"+id, bytes.NewReader(data))
+	r.Header.Set("Content-Type", "application/offset+octet-stream")
+	r.Header.Set("Upload-Offset", strconv.Itoa(offset))
+	w := httptest.NewRecorder()
+	h.APIUploads(w, r)
+	return w
+}
+
+// uploadOffset смещение сессии id из ответа на HEAD
+func uploadOffset(t *testing.T, h *Handler, id string) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.APIUploads(w, httptest.NewRequest(http.MethodHead, "/api/v1/uploads/"+id, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("HEAD: код %d", w.Code)
+	}
+	offset, err := strconv.Atoi(w.Header().Get("Upload-Offset"))
+	if err != nil {
+		t.Fatalf("Upload-Offset: %q", w.Header().Get("Upload-Offset"))
+	}
+	return offset
+}
+
+// finalizeUpload вызывает finalize сессии id
+func finalizeUpload(t *testing.T, h *Handler, id string) (int, MediaResponse) {
+	t.Helper()
+	var media MediaResponse
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/uploads/"+id+"/finalize", nil)
+	code := serve(t, h.APIUploads, r, &media)
+	return code, media
+}
+
+func TestAPIUploadOffsetMismatch(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+	data := testPNG(t)
+	half := len(data) / 2
+	upload := createUpload(t, h, "pic.png", len(data), "b")
+
+	if w := patchUpload(h, upload.ID, 0, data[:half]); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("первый кусок: код %d, Upload-Offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	// Кусок с чужим смещением не записывается, ответ сообщает текущее
+	for _, offset := range []int{0, half - 1, half + 1, len(data)} {
+		w := patchUpload(h, upload.ID, offset, data[offset%half:])
+		if w.Code != http.StatusConflict {
+			t.Errorf("смещение %d: код %d, ожидался 409", offset, w.Code)
+		}
+		if got := w.Header().Get("Upload-Offset"); got != strconv.Itoa(half) {
+			t.Errorf("смещение %d: Upload-Offset %q, ожидалось %d", offset, got, half)
+		}
+	}
+	if offset := uploadOffset(t, h, upload.ID); offset != half {
+		t.Fatalf("после отклонённых кусков смещение %d, ожидалось %d", offset, half)
+	}
+
+	if w := patchUpload(h, upload.ID, half, data[half:]); w.Code != http.StatusNoContent {
+		t.Fatalf("второй кусок: код %d", w.Code)
+	}
+	code, media := finalizeUpload(t, h, upload.ID)
+	if code != http.StatusOK {
+		t.Fatalf("finalize: код %d", code)
+	}
+	sum := sha256.Sum256(data)
+	if media.Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("файл собран неверно: хеш %s", media.Hash)
+	}
+}
+
+func TestAPIUploadLengthExceeded(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+	data := testPNG(t)
+	upload := createUpload(t, h, "pic.png", len(data), "b")
+
+	// Заявленные байты записываются, лишние отклоняются
+	w := patchUpload(h, upload.ID, 0, append(append([]byte(nil), data...), "лишнее"...))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("данные сверх Upload-Length: код %d, ожидался 413", w.Code)
+	}
+	if offset := uploadOffset(t, h, upload.ID); offset != len(data) {
+		t.Fatalf("смещение %d, ожидалось %d", offset, len(data))
+	}
+	if w := patchUpload(h, upload.ID, len(data), []byte("x")); w.Code == http.StatusNoContent {
+		t.Error("принят кусок после конца файла")
+	}
+	if code, _ := finalizeUpload(t, h, upload.ID); code != http.StatusOK {
+		t.Errorf("finalize: код %d", code)
+	}
+
+	// Сессию больше предела сервера не создать
+	create := jsonRequest(t, "/api/v1/uploads", map[string]interface{}{"filename": "pic.png", "size": maxUploadSize + 1, "board_id": "b"})
+	if code := serve(t, h.APIUploads, create, nil); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("сессия больше maxUploadSize: код %d, ожидался 413", code)
+	}
+}
+
+func TestAPIFinalizeIncompleteUpload(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+	data := testPNG(t)
+	half := len(data) / 2
+	upload := createUpload(t, h, "pic.png", len(data), "b")
+
+	if code, _ := finalizeUpload(t, h, upload.ID); code != http.StatusConflict {
+		t.Errorf("finalize пустой загрузки: код %d, ожидался 409", code)
+	}
+	patchUpload(h, upload.ID, 0, data[:half])
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/uploads/"+upload.ID+"/finalize", nil)
+	w := httptest.NewRecorder()
+	h.APIUploads(w, r)
+	if w.Code != http.StatusConflict || w.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("finalize незавершённой загрузки: код %d, Upload-Offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	if keys := storedKeys(t, h.media); len(keys) != 0 {
+		t.Errorf("незавершённый файл попал в хранилище: %v", keys)
+	}
+
+	// Загрузка продолжается с того же места
+	if w := patchUpload(h, upload.ID, half, data[half:]); w.Code != http.StatusNoContent {
+		t.Fatalf("второй кусок: код %d", w.Code)
+	}
+	if code, media := finalizeUpload(t, h, upload.ID); code != http.StatusOK || media.Token == "" {
+		t.Errorf("finalize: код %d, %+v", code, media)
+	}
+}
+
+func TestAPIFinalizeIssuesOneToken(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+	data := testPNG(t)
+	upload := createUpload(t, h, "pic.png", len(data), "b")
+	if w := patchUpload(h, upload.ID, 0, data); w.Code != http.StatusNoContent {
+		t.Fatalf("передача: код %d: %s", w.Code, w.Body.String())
+	}
+
+	// Одновременные finalize: занятая сессия отвечает 423, остальные
+	// получают один и тот же токен
+	var wg sync.WaitGroup
+	codes := make([]int, 4)
+	tokens := make([]MediaResponse, len(codes))
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/uploads/"+upload.ID+"/finalize", nil)
+			w := httptest.NewRecorder()
+			h.APIUploads(w, r)
+			codes[i] = w.Code
+			if w.Code == http.StatusOK {
+				var resp struct{ Data MediaResponse }
+				json.Unmarshal(w.Body.Bytes(), &resp)
+				tokens[i] = resp.Data
+			}
+		}()
+	}
+	wg.Wait()
+
+	code, first := finalizeUpload(t, h, upload.ID)
+	if code != http.StatusOK || first.Token == "" {
+		t.Fatalf("finalize: код %d, %+v", code, first)
+	}
+	for i, c := range codes {
+		switch {
+		case c == http.StatusLocked:
+		case c != http.StatusOK:
+			t.Errorf("одновременный finalize: код %d", c)
+		case tokens[i].Token != first.Token || tokens[i].TokenExpiresAt != first.TokenExpiresAt:
+			t.Errorf("одновременный finalize выдал другой токен: %q (%s), был %q (%s)",
+				tokens[i].Token, tokens[i].TokenExpiresAt, first.Token, first.TokenExpiresAt)
+		}
+	}
+
+	// После поста с токеном повторный finalize возвращает тот же,
+	// уже израсходованный токен, а не выпускает новый
+	threadID, _, err := h.store.CreateThreadWithOP("b", "Тред", "Аноним", "OP", database.Media{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := map[string]interface{}{"thread_id": threadID, "content": "ответ", "media_token": first.Token}
+	if code := serve(t, h.APICreatePost, jsonRequest(t, "/api/v1/posts", post), nil); code != http.StatusOK {
+		t.Fatalf("пост с токеном: код %d", code)
+	}
+	code, again := finalizeUpload(t, h, upload.ID)
+	if code != http.StatusOK || again.Token != first.Token {
+		t.Fatalf("повторный finalize: код %d, токен %q, был %q", code, again.Token, first.Token)
+	}
+	post["media_token"] = again.Token
+	if code := serve(t, h.APICreatePost, jsonRequest(t, "/api/v1/posts", post), nil); code != http.StatusBadRequest {
+		t.Errorf("второй пост с токеном сессии: код %d, ожидался 400", code)
+	}
+}
+
+func TestExpireUploadSessions(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+	data := testPNG(t)
+	active := createUpload(t, h, "pic.png", len(data), "b")
+	abandoned := createUpload(t, h, "pic.png", len(data), "b")
+	patchUpload(h, abandoned.ID, 0, data[:10])
+
+	// В брошенную сессию не писали дольше uploadSessionTTL
+	sess, err := h.uploads.load(abandoned.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := h.uploads.save(sess); err != nil {
+		t.Fatal(err)
+	}
+
+	// Файлы данных без описания: старый удаляется, свежий (описание
+	// ещё пишется) остаётся
+	stale, fresh := strings.Repeat("a", 32), strings.Repeat("b", 32)
+	for _, id := range []string{stale, fresh} {
+		if err := os.WriteFile(h.uploads.dataPath(id), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-uploadSessionTTL - time.Minute)
+	if err := os.Chtimes(h.uploads.dataPath(stale), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := h.uploads.expire(time.Now())
+	if err != nil || n != 1 {
+		t.Fatalf("expire: %d, %v; ожидалась 1 сессия", n, err)
+	}
+	for _, p := range []string{h.uploads.dataPath(abandoned.ID), h.uploads.infoPath(abandoned.ID), h.uploads.dataPath(stale)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s не удалён", filepath.Base(p))
+		}
+	}
+	if _, err := os.Stat(h.uploads.dataPath(fresh)); err != nil {
+		t.Errorf("удалён свежий файл данных: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.APIUploads(w, httptest.NewRequest(http.MethodGet, "/api/v1/uploads/"+abandoned.ID, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("брошенная сессия: код %d, ожидался 404", w.Code)
+	}
+	if offset := uploadOffset(t, h, active.ID); offset != 0 {
+		t.Errorf("активная сессия: смещение %d", offset)
+	}
+}
+
+// servePage выполняет запрос к списку и возвращает код ответа и next_cursor
+func servePage(t *testing.T, handler http.HandlerFunc, r *http.Request, out interface{}) (int, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	var resp struct {
+		Data       json.RawMessage `json:"data"`
+		NextCursor string          `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("ответ не JSON (%d): %s", w.Code, w.Body.String())
+	}
+	if out != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal(resp.Data, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, resp.NextCursor
+}
+
+func TestAPIGetThreadsPages(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+	for _, subject := range []string{"1", "2", "3", "4", "5"} {
+		if _, _, err := h.store.CreateThreadWithOP("b", subject, "Аноним", "OP "+subject, database.Media{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	url := "/api/v1/boards/b/threads?limit=2"
+	for pages := 0; url != ""; pages++ {
+		if pages > 3 {
+			t.Fatal("курсоры не заканчиваются")
+		}
+		var threads []ThreadResponse
+		code, next := servePage(t, h.APIBoardsRouter, httptest.NewRequest(http.MethodGet, url, nil), &threads)
+		if code != http.StatusOK {
+			t.Fatalf("%s: код %d", url, code)
+		}
+		for _, th := range threads {
+			if th.FirstPost == nil || th.FirstPost.Content != "OP "+th.Subject {
+				t.Errorf("тред %s без OP: %+v", th.Subject, th.FirstPost)
+			}
+			got = append(got, th.Subject)
+		}
+		url = ""
+		if next != "" {
+			url = "/api/v1/boards/b/threads?limit=2&cursor=" + next
+		}
+	}
+	if want := "5,4,3,2,1"; strings.Join(got, ",") != want {
+		t.Errorf("треды %v, ожидалось %s", got, want)
+	}
+
+	for _, url := range []string{"/api/v1/boards/b/threads?limit=0", "/api/v1/boards/b/threads?cursor=bad"} {
+		if code, _ := servePage(t, h.APIBoardsRouter, httptest.NewRequest(http.MethodGet, url, nil), nil); code != http.StatusBadRequest {
+			t.Errorf("%s: код %d, ожидался 400", url, code)
+		}
+	}
+	if code, _ := servePage(t, h.APIBoardsRouter, httptest.NewRequest(http.MethodGet, "/api/v1/boards/nope/threads", nil), nil); code != http.StatusNotFound {
+		t.Errorf("несуществующая доска: код %d", code)
+	}
+}
+
+func TestAPIGetCatalog(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+	createTestBoard(t, h, "empty", database.AllMediaTypes...)
+
+	image := database.Media{Path: "/uploads/op.png", Type: "image", MIME: "image/png", ThumbPath: "/uploads/op_thumb.png"}
+	long := strings.Repeat("Длинный текст ", 20)
+	ids := map[string]int{}
+	for _, th := range []struct {
+		subject, content string
+		media            database.Media
+		replies          int
+	}{
+		{"A", long, image, 3},
+		{"B", "короткий", database.Media{}, 0},
+		{"C", "третий", database.Media{}, 1},
+	} {
+		threadID, _, err := h.store.CreateThreadWithOP("b", th.subject, "Автор "+th.subject, th.content, th.media)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[th.subject] = int(threadID)
+		for i := 0; i < th.replies; i++ {
+			media := database.Media{}
+			if i == 0 {
+				media = database.Media{Path: "/uploads/reply.png", Type: "image", MIME: "image/png"}
+			}
+			if _, err := h.store.CreatePost(int(threadID), nil, "Аноним", "ответ", media); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Тред A поднят последним
+	if err := h.store.BumpThread(ids["A"]); err != nil {
+		t.Fatal(err)
+	}
+
+	catalog := func(url string) []CatalogThreadResponse {
+		t.Helper()
+		var threads []CatalogThreadResponse
+		if code := serve(t, h.APIBoardsRouter, httptest.NewRequest(http.MethodGet, url, nil), &threads); code != http.StatusOK {
+			t.Fatalf("%s: код %d", url, code)
+		}
+		return threads
+	}
+
+	sorts := []struct {
+		sort string
+		want string
+	}{
+		{"", "A,C,B"},
+		{"bump", "A,C,B"},
+		{"new", "C,B,A"},
+		{"old", "A,B,C"},
+		{"replies", "A,C,B"},
+		{"unknown", "A,C,B"},
+	}
+	for _, tt := range sorts {
+		var got []string
+		for _, th := range catalog("/api/v1/boards/b/catalog?sort=" + tt.sort) {
+			got = append(got, th.Subject)
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("sort=%s: %v, ожидалось %s", tt.sort, got, tt.want)
+		}
+	}
+
+	// Превью OP и счётчики ответов
+	byID := map[int]CatalogThreadResponse{}
+	for _, th := range catalog("/api/v1/boards/b/catalog") {
+		byID[th.ID] = th
+	}
+	a, b, c := byID[ids["A"]], byID[ids["B"]], byID[ids["C"]]
+	if a.ReplyCount != 3 || b.ReplyCount != 0 || c.ReplyCount != 1 {
+		t.Errorf("reply_count: A=%d B=%d C=%d, ожидалось 3, 0, 1", a.ReplyCount, b.ReplyCount, c.ReplyCount)
+	}
+	if a.ImageCount != 2 || b.ImageCount != 0 {
+		t.Errorf("image_count: A=%d B=%d, ожидалось 2, 0", a.ImageCount, b.ImageCount)
+	}
+	if want := string([]rune(long)[:catalogExcerptLength]) + "..."; a.Excerpt != want {
+		t.Errorf("excerpt длинного OP: %q", a.Excerpt)
+	}
+	if b.Excerpt != "короткий" || b.Author != "Автор B" {
+		t.Errorf("превью OP: %+v", b)
+	}
+	if a.MediaPath != image.Path || a.MediaType != "image" || a.ThumbPath != image.ThumbPath {
+		t.Errorf("файл OP: %q %q %q", a.MediaPath, a.MediaType, a.ThumbPath)
+	}
+	if b.MediaPath != "" || b.ThumbPath != "" {
+		t.Errorf("у OP без файла: %q %q", b.MediaPath, b.ThumbPath)
+	}
+
+	// Пустая доска - пустой массив, а не null
+	w := httptest.NewRecorder()
+	h.APIBoardsRouter(w, httptest.NewRequest(http.MethodGet, "/api/v1/boards/empty/catalog", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"data":[]`) {
+		t.Errorf("пустая доска: код %d: %s", w.Code, w.Body)
+	}
+
+	if code := serve(t, h.APIBoardsRouter, httptest.NewRequest(http.MethodGet, "/api/v1/boards/nope/catalog", nil), nil); code != http.StatusNotFound {
+		t.Errorf("несуществующая доска: код %d, ожидался 404", code)
+	}
+}
+
+func TestAPIGetThreadTree(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+	threadID, opID, err := h.store.CreateThreadWithOP("b", "Тред", "Аноним", "op", database.Media{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := int(opID)
+	if _, err := h.store.CreatePost(int(threadID), nil, "Аноним", "второй", database.Media{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.store.CreatePost(int(threadID), &op, "Аноним", "ответ на op", database.Media{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/api/v1/threads/" + strconv.FormatInt(threadID, 10), "op:0,ответ на op:1,второй:0"},
+		{"/api/v1/threads/" + strconv.FormatInt(threadID, 10) + "?view=flat", "op:0,второй:0,ответ на op:0"},
+	}
+	for _, tt := range tests {
+		var thread ThreadResponse
+		if code := serve(t, h.APIGetThread, httptest.NewRequest(http.MethodGet, tt.url, nil), &thread); code != http.StatusOK {
+			t.Fatalf("%s: код %d", tt.url, code)
+		}
+		var got []string
+		for _, p := range thread.Posts {
+			got = append(got, p.Content+":"+strconv.Itoa(p.Depth))
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: %s, ожидалось %s", tt.url, strings.Join(got, ","), tt.want)
+		}
+		if thread.PostCount != 3 || thread.Subject != "Тред" {
+			t.Errorf("%s: %+v", tt.url, thread)
+		}
+	}
+
+	if code := serve(t, h.APIGetThread, httptest.NewRequest(http.MethodGet, "/api/v1/threads/999", nil), nil); code != http.StatusNotFound {
+		t.Errorf("несуществующий тред: код %d", code)
+	}
+}
+
+func TestAPISearch(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+	threadID, _, err := h.store.CreateThreadWithOP("b", "Коты", "Аноним", "первый пост", database.Media{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.store.CreatePost(int(threadID), nil, "Вася", "рыжий <кот>", database.Media{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var results []SearchResultResponse
+	if code := serve(t, h.APISearch, httptest.NewRequest(http.MethodGet, "/api/v1/search?q=кот", nil), &results); code != http.StatusOK {
+		t.Fatalf("код %d", code)
+	}
+	if len(results) != 2 {
+		t.Fatalf("найдено %d, ожидалось 2: %+v", len(results), results)
+	}
+	if r := results[0]; r.Content != "рыжий <кот>" || r.Subject != "Коты" || r.BoardID != "b" ||
+		r.Snippet != "рыжий &lt;<mark>кот</mark>&gt;" || !strings.HasPrefix(r.URL, "/thread/") {
+		t.Errorf("результат: %+v", r)
+	}
+
+	if code := serve(t, h.APISearch, httptest.NewRequest(http.MethodGet, "/api/v1/search?q=кот&author=Вася", nil), &results); code != http.StatusOK || len(results) != 1 {
+		t.Errorf("по автору: код %d, %d результатов", code, len(results))
+	}
+	if code := serve(t, h.APISearch, httptest.NewRequest(http.MethodGet, "/api/v1/search?q=%20!!", nil), nil); code != http.StatusBadRequest {
+		t.Errorf("пустой запрос: код %d, ожидался 400", code)
+	}
+}
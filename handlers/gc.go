@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"webForum/database"
+	"webForum/storage"
+)
+
+// GCFile неиспользуемый файл, найденный сборщиком
+type GCFile struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// GCReport результат сборки неиспользуемых файлов
+type GCReport struct {
+	Scanned int      // файлов в хранилище
+	Orphans []GCFile // файлы без ссылок из постов старше grace
+	Bytes   int64    // их суммарный размер
+	Deleted int      // удалено файлов (0 при dryRun)
+	Records int      // удалено записей media (0 при dryRun)
+}
+
+// CollectGarbage удаляет из хранилища файлы, на которые не ссылается ни
+// один пост (posts.media_path и thumb_path), если они старше grace, и их
+// записи media. Grace и действующие токены загрузки защищают файлы,
+// загруженные через /api/v1/upload, но ещё не прикреплённые к посту.
+// Оригинал и миниатюра удаляются только вместе. Заодно удаляются истёкшие
+// токены. При dryRun ничего не удаляется, отчёт содержит файлы, которые
+// были бы удалены.
+func CollectGarbage(store database.Store, media storage.MediaStore, grace time.Duration, dryRun bool) (*GCReport, error) {
+	cutoff := time.Now().Add(-grace)
+	report := &GCReport{}
+
+	if !dryRun {
+		if _, err := store.DeleteExpiredUploadTokens(time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	// Сначала список файлов, потом ссылки: пост, созданный между двумя
+	// запросами, ссылается на файл из списка и будет учтён
+	fresh := make(map[string]bool) // публичные пути файлов моложе grace
+	var candidates []GCFile
+	err := media.List(func(obj storage.ObjectInfo) error {
+		report.Scanned++
+		if obj.ModTime.Before(cutoff) {
+			candidates = append(candidates, GCFile{Key: obj.Key, Size: obj.Size, ModTime: obj.ModTime})
+		} else {
+			fresh[publicPath(obj.Key)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	referenced, err := store.ReferencedMediaPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	// Решение по записи media принимается один раз для оригинала и миниатюры
+	deletable := make(map[string]bool) // hash -> файлы записи можно удалять
+	for _, f := range candidates {
+		if referenced[publicPath(f.Key)] {
+			continue
+		}
+
+		if hash := gcHash(f.Key); hash != "" {
+			ok, seen := deletable[hash]
+			if !seen {
+				if ok, err = collectMedia(store, hash, referenced, fresh, dryRun, report); err != nil {
+					return report, err
+				}
+				deletable[hash] = ok
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		report.Orphans = append(report.Orphans, f)
+		report.Bytes += f.Size
+		if dryRun {
+			continue
+		}
+		if err := media.Delete(f.Key); err != nil {
+			return report, err
+		}
+		report.Deleted++
+	}
+	return report, nil
+}
+
+// collectMedia решает, можно ли удалить файлы с хешем hash, и удаляет их
+// запись media. Файлы без записи удаляются; файлы записи - только если ни
+// оригинал, ни миниатюра не используются и не моложе grace, а запись не
+// успели прикрепить к посту.
+func collectMedia(store database.Store, hash string, referenced, fresh map[string]bool, dryRun bool, report *GCReport) (bool, error) {
+	m, err := store.GetMediaByHash(hash)
+	if err != nil || m == nil {
+		return err == nil, err
+	}
+	for _, p := range []string{m.Path, m.ThumbPath} {
+		if p != "" && (referenced[p] || fresh[p]) {
+			return false, nil
+		}
+	}
+	if dryRun {
+		return true, nil
+	}
+	deleted, err := store.DeleteMedia(m.ID)
+	if err != nil || !deleted {
+		return false, err
+	}
+	report.Records++
+	return true, nil
+}
+
+// gcHash хеш содержимого из ключа файла или его миниатюры
+// ({hash}_thumb.ext) либо пустая строка для прочих файлов
+func gcHash(key string) string {
+	name := path.Base(key)
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	hash := strings.TrimSuffix(base, "_thumb")
+	if len(hash) != sha256.Size*2 || hashFromPath(publicPath(contentKey(hash, ext))) != hash {
+		return ""
+	}
+	if key != contentKey(hash, base[len(hash):]+ext) {
+		return ""
+	}
+	return hash
+}
+
+// SweepOrphanedMedia раз в interval удаляет неиспользуемые файлы старше
+// grace (см. CollectGarbage). Запускается в отдельной горутине.
+func (h *Handler) SweepOrphanedMedia(interval, grace time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		report, err := CollectGarbage(h.store, h.media, grace, false)
+		if err != nil {
+			log.Printf("Ошибка сборки неиспользуемых файлов: %v", err)
+			continue
+		}
+		if report.Deleted > 0 {
+			log.Printf("Удалено неиспользуемых файлов: %d (%d байт), записей media: %d",
+				report.Deleted, report.Bytes, report.Records)
+		}
+	}
+}
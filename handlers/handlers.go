@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"bytes"
 	"database/sql"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -14,92 +16,240 @@ import (
 	"time"
 
 	"webForum/database"
+	"webForum/storage"
 )
 
-// Допустимые типы файлов
-var allowedExtensions = map[string]string{
-	// Изображения
-	".jpg":  "image",
-	".jpeg": "image",
-	".png":  "image",
-	".gif":  "image",
-	".webp": "image",
-	".svg":  "image",
-	// Видео
-	".mp4":  "video",
-	".webm": "video",
-	".avi":  "video",
-	".mov":  "video",
-	".mkv":  "video",
-	// Аудио
-	".mp3":  "audio",
-	".wav":  "audio",
-	".ogg":  "audio",
-	".flac": "audio",
-	".m4a":  "audio",
-}
+// saveFile сохраняет файл из поля fieldName формы (см. storeUpload).
+// Если файла в форме нет, возвращается пустой Media.
+func (h *Handler) saveFile(r *http.Request, fieldName string, settings database.BoardSettings) (database.Media, error) {
+	file, header, err := r.FormFile(fieldName)
+	if err != nil {
+		return database.Media{}, nil
+	}
+	defer file.Close()
 
-// FileInfo информация о загруженном файле
-type FileInfo struct {
-	Path string
-	Type string
+	return h.storeUpload(file, header.Filename, settings)
 }
 
-// saveFile сохраняет загруженный файл
-func saveFile(r *http.Request, fieldName string, postID int64) (*FileInfo, error) {
-	file, header, err := r.FormFile(fieldName)
+// storeUpload проверяет содержимое файла по сигнатуре и сохраняет его
+// по SHA-256 вместе с миниатюрой. Повторная загрузка того же содержимого
+// возвращает уже существующую запись media. Настройки доски определяют
+// разрешённые типы файлов, их максимальный размер и то, удаляются ли из
+// фото метаданные.
+func (h *Handler) storeUpload(file io.ReadSeeker, filename string, settings database.BoardSettings) (database.Media, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	fileSize, err := file.Seek(0, io.SeekEnd)
 	if err != nil {
-		return nil, nil
+		return database.Media{}, err
+	}
+	if err := checkMediaPolicy(settings, ext, fileSize); err != nil {
+		return database.Media{}, err
+	}
+	format := allowedExtensions[ext]
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return database.Media{}, err
 	}
-	defer file.Close()
 
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	fileType, ok := allowedExtensions[ext]
-	if !ok {
-		return nil, fmt.Errorf("недопустимый тип файла: %s", ext)
+	// Тип определяется по содержимому, расширению не доверяем
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return database.Media{}, fmt.Errorf("не удалось прочитать файл: %w", err)
+	}
+	mime := sniffMIME(head[:n])
+	if !format.accepts(mime) {
+		return database.Media{}, fmt.Errorf("содержимое файла (%s) не соответствует расширению %s", mime, ext)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return database.Media{}, err
 	}
 
-	if err := os.MkdirAll("uploads", 0755); err != nil {
-		return nil, err
+	var src io.Reader = file
+	var width, height int
+	switch {
+	case mime == "image/svg+xml":
+		// SVG сохраняется только после очистки от скриптов и внешних ссылок
+		clean, err := sanitizeSVG(file)
+		if err != nil {
+			return database.Media{}, err
+		}
+		src = bytes.NewReader(clean)
+	case format.Type == "image":
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return database.Media{}, err
+		}
+		if width, height, err = imageSize(mime, data); err != nil {
+			return database.Media{}, err
+		}
+		// EXIF с координатами и серийными номерами не должен попасть в uploads/
+		if settings.StripMetadata {
+			if data, err = stripMetadata(mime, data); err != nil {
+				return database.Media{}, err
+			}
+		}
+		src = bytes.NewReader(data)
 	}
 
-	filename := fmt.Sprintf("%d_%d%s", postID, time.Now().UnixNano(), ext)
-	filePath := filepath.Join("uploads", filename)
+	// Хеш считается по итоговому содержимому (после очистки метаданных)
+	tmpPath, hash, size, err := writeTemp(src)
+	if err != nil {
+		return database.Media{}, err
+	}
+	defer os.Remove(tmpPath)
+
+	// Запись уже загруженного файла арендуется на срок токена загрузки:
+	// сборщик не удалит её, пока файл прикрепляют к посту. Если сборщик
+	// успел удалить запись или её файлы, файл сохраняется заново.
+	key := contentKey(hash, ext)
+	media := database.Media{
+		Hash:   hash,
+		Path:   publicPath(key),
+		Type:   format.Type,
+		MIME:   mime,
+		Width:  width,
+		Height: height,
+		Size:   size,
+	}
+	existing, err := h.store.LeaseMedia(hash, time.Now().Add(uploadTokenTTL))
+	if err != nil {
+		return database.Media{}, err
+	}
+	if existing != nil {
+		stored, err := h.mediaStored(*existing)
+		if err != nil || stored {
+			return *existing, err
+		}
+		media = *existing
+		key = strings.TrimPrefix(media.Path, uploadsPrefix)
+	}
 
-	dst, err := os.Create(filePath)
+	if err := h.putMedia(&media, key, ext, tmpPath); err != nil {
+		return database.Media{}, err
+	}
+	if media.ID != 0 {
+		return media, nil
+	}
+
+	saved, err := h.store.CreateMedia(media)
 	if err != nil {
-		return nil, err
+		return database.Media{}, err
+	}
+	// Сборщик мог удалить прежнюю запись с этим хешем и затем её файлы
+	// уже после записи новых
+	if stored, err := h.mediaStored(*saved); err != nil || !stored {
+		if err == nil {
+			err = h.putMedia(saved, key, ext, tmpPath)
+		}
+		if err != nil {
+			return database.Media{}, err
+		}
+	}
+	return *saved, nil
+}
+
+// putMedia записывает в хранилище временный файл tmpPath под ключом key
+// и его миниатюру. Миниатюра делается из локального временного файла до
+// записи в хранилище: оригинал может уйти на другой узел или в S3.
+func (h *Handler) putMedia(media *database.Media, key, ext, tmpPath string) error {
+	if thumbnailExtensions[ext] {
+		thumb, thumbExt, err := makeThumbnail(tmpPath)
+		if errors.Is(err, errImageTooLarge) {
+			return err
+		}
+		if err != nil {
+			// Обрезанная или повреждённая картинка с верным заголовком
+			// сохраняется без миниатюры: браузер покажет, что сможет
+			log.Printf("Миниатюра %s не создана: %v", media.Hash, err)
+		}
+		if thumb != nil {
+			thumbKey := contentKey(media.Hash, "_thumb"+thumbExt)
+			if media.ThumbPath != "" {
+				thumbKey = strings.TrimPrefix(media.ThumbPath, uploadsPrefix)
+			}
+			if err := h.media.Put(thumbKey, bytes.NewReader(thumb), int64(len(thumb)), mimeForPath(thumbKey)); err != nil {
+				return err
+			}
+			media.ThumbPath = publicPath(thumbKey)
+		}
+	}
+	return putFile(h.media, key, tmpPath, media.MIME)
+}
+
+// boardSettings настройки доски; для неизвестной доски - настройки
+// по умолчанию (метаданные удаляются)
+func (h *Handler) boardSettings(boardID string) database.BoardSettings {
+	board, err := h.store.GetBoard(boardID)
+	if err != nil || board == nil {
+		return database.DefaultBoardSettings()
 	}
-	defer dst.Close()
+	return board.Settings
+}
+
+// Размеры страниц по умолчанию и верхний предел limit
+const (
+	defaultThreadsLimit = 50
+	defaultPostsLimit   = 200
+	maxPageLimit        = 500
+)
+
+// parsePage разбирает параметры limit и cursor запроса
+func parsePage(r *http.Request, defaultLimit int) (database.PageRequest, error) {
+	page := database.PageRequest{Limit: defaultLimit}
 
-	if _, err := io.Copy(dst, file); err != nil {
-		return nil, err
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return page, fmt.Errorf("неверный limit: %s", limitStr)
+		}
+		page.Limit = min(limit, maxPageLimit)
 	}
 
-	return &FileInfo{
-		Path: "/uploads/" + filename,
-		Type: fileType,
-	}, nil
+	if cursorStr := r.URL.Query().Get("cursor"); cursorStr != "" {
+		cursor, err := database.DecodeCursor(cursorStr)
+		if err != nil {
+			return page, err
+		}
+		page.Cursor = cursor
+	}
+	return page, nil
 }
 
+// truncate обрезает строку до length символов (не байт)
+func truncate(s string, length int) string {
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+	return string(runes[:length]) + "..."
+}
+
+// Handler обработчики HTTP и WebSocket запросов
 type Handler struct {
 	templates *template.Template
+	store     database.Store
+	media     storage.MediaStore
+	uploads   *uploadSessions
+	hub       *Hub
 }
 
-func NewHandler() *Handler {
+// NewHandler создаёт обработчики поверх хранилища данных store и
+// хранилища загруженных файлов media; ws - таймауты WebSocket-соединений
+func NewHandler(store database.Store, media storage.MediaStore, ws WSConfig) *Handler {
 	tmpl := template.Must(template.New("").Funcs(template.FuncMap{
 		"formatTime": func(t time.Time) string {
 			return t.Format("02.01.2006 15:04:05")
 		},
-		"truncate": func(s string, length int) string {
-			if len(s) <= length {
-				return s
-			}
-			return s[:length] + "..."
-		},
+		"truncate":     truncate,
+		"mediaAccept":  mediaAccept,
+		"mediaFormats": mediaFormats,
+		"formatSize":   formatSize,
 		"multiply": func(a, b int) int {
 			return a * b
 		},
+		"add": func(a, b int) int {
+			return a + b
+		},
 		"nullStr": func(ns sql.NullString) string {
 			if ns.Valid {
 				return ns.String
@@ -116,6 +266,10 @@ func NewHandler() *Handler {
 
 	return &Handler{
 		templates: tmpl,
+		store:     store,
+		media:     media,
+		uploads:   newUploadSessions(uploadSessionsDir),
+		hub:       NewHub(ws),
 	}
 }
 
@@ -126,7 +280,11 @@ func (h *Handler) IndexHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	boards, err := database.GetAllBoards()
+	// ID события до чтения досок: страница подключится с ним и получит
+	// доски, созданные после рендера
+	eventID := h.hub.LastEventID()
+
+	boards, err := h.store.GetAllBoards()
 	if err != nil {
 		log.Printf("Ошибка получения досок: %v", err)
 		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
@@ -134,8 +292,9 @@ func (h *Handler) IndexHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := map[string]interface{}{
-		"Title":  "Веб-форум",
-		"Boards": boards,
+		"Title":   "Веб-форум",
+		"Boards":  boards,
+		"EventID": eventID,
 	}
 
 	if err := h.templates.ExecuteTemplate(w, "index.html", data); err != nil {
@@ -152,7 +311,66 @@ func (h *Handler) BoardHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	board, err := database.GetBoard(boardID)
+	if strings.HasSuffix(boardID, "/catalog") {
+		h.CatalogHandler(w, r)
+		return
+	}
+
+	board, err := h.store.GetBoard(boardID)
+	if err != nil {
+		log.Printf("Ошибка получения доски: %v", err)
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if board == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy == "" {
+		sortBy = "bump"
+	}
+
+	page, err := parsePage(r, defaultThreadsLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// ID события до чтения тредов, как в ThreadHandler
+	eventID := h.hub.LastEventID()
+
+	threads, pageInfo, err := h.store.GetThreadsPage(boardID, sortBy, page)
+	if err != nil {
+		log.Printf("Ошибка получения тредов: %v", err)
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":      "/" + boardID + "/ - " + board.Name,
+		"Board":      board,
+		"BoardID":    boardID,
+		"SortBy":     sortBy,
+		"Threads":    threads,
+		"NextCursor": pageInfo.Next,
+		"PrevCursor": pageInfo.Prev,
+		"EventID":    eventID,
+	}
+
+	if err := h.templates.ExecuteTemplate(w, "board.html", data); err != nil {
+		log.Printf("Ошибка рендеринга: %v", err)
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+	}
+}
+
+// CatalogHandler - каталог доски: сетка тредов с превью OP
+// GET /board/{id}/catalog?sort=bump|new|old|replies
+func (h *Handler) CatalogHandler(w http.ResponseWriter, r *http.Request) {
+	boardID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/board/"), "/catalog")
+
+	board, err := h.store.GetBoard(boardID)
 	if err != nil {
 		log.Printf("Ошибка получения доски: %v", err)
 		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
@@ -168,7 +386,7 @@ func (h *Handler) BoardHandler(w http.ResponseWriter, r *http.Request) {
 		sortBy = "bump"
 	}
 
-	threads, err := database.GetThreadsByBoard(boardID, sortBy)
+	threads, err := h.store.GetThreadsByBoard(boardID, sortBy)
 	if err != nil {
 		log.Printf("Ошибка получения тредов: %v", err)
 		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
@@ -176,14 +394,14 @@ func (h *Handler) BoardHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := map[string]interface{}{
-		"Title":   "/" + boardID + "/ - " + board.Name,
+		"Title":   "/" + boardID + "/ - Каталог",
 		"Board":   board,
 		"BoardID": boardID,
 		"SortBy":  sortBy,
 		"Threads": threads,
 	}
 
-	if err := h.templates.ExecuteTemplate(w, "board.html", data); err != nil {
+	if err := h.templates.ExecuteTemplate(w, "catalog.html", data); err != nil {
 		log.Printf("Ошибка рендеринга: %v", err)
 		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
 	}
@@ -198,7 +416,7 @@ func (h *Handler) ThreadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	thread, err := database.GetThread(threadID)
+	thread, err := h.store.GetThread(threadID)
 	if err != nil {
 		log.Printf("Ошибка получения треда: %v", err)
 		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
@@ -209,27 +427,50 @@ func (h *Handler) ThreadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	board, err := database.GetBoard(thread.BoardID)
+	board, err := h.store.GetBoard(thread.BoardID)
 	if err != nil {
 		log.Printf("Ошибка получения доски: %v", err)
 		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
 		return
 	}
 
-	posts, err := database.GetPostsByThread(threadID)
+	page, err := parsePage(r, defaultPostsLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// ID события до чтения постов: страница подключится с ним и получит
+	// посты, созданные после рендера (повторы отбрасываются по ID поста)
+	eventID := h.hub.LastEventID()
+
+	posts, pageInfo, err := h.store.GetPostsPage(threadID, page)
 	if err != nil {
 		log.Printf("Ошибка получения постов: %v", err)
 		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
 		return
 	}
 
+	// Плоский режим для тредов с очень глубокими цепочками ответов
+	view := r.URL.Query().Get("view")
+	if view == "flat" {
+		posts = database.FlattenPosts(posts)
+	} else {
+		view = "tree"
+	}
+
 	data := map[string]interface{}{
-		"Title":    thread.Subject,
-		"Thread":   thread,
-		"ThreadID": threadID,
-		"Board":    board,
-		"BoardID":  thread.BoardID,
-		"Posts":    posts,
+		"Title":      thread.Subject,
+		"Thread":     thread,
+		"ThreadID":   threadID,
+		"Board":      board,
+		"BoardID":    thread.BoardID,
+		"Posts":      posts,
+		"View":       view,
+		"MaxDepth":   database.MaxPostDepth,
+		"NextCursor": pageInfo.Next,
+		"PrevCursor": pageInfo.Prev,
+		"EventID":    eventID,
 	}
 
 	if err := h.templates.ExecuteTemplate(w, "thread.html", data); err != nil {
@@ -268,20 +509,20 @@ func (h *Handler) CreateBoardHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Проверяем существование
-	existing, _ := database.GetBoard(id)
+	existing, _ := h.store.GetBoard(id)
 	if existing != nil {
 		http.Error(w, "Доска с таким ID уже существует", http.StatusBadRequest)
 		return
 	}
 
-	if err := database.CreateBoard(id, name, description); err != nil {
+	if err := h.store.CreateBoard(id, name, description, database.DefaultBoardSettings()); err != nil {
 		log.Printf("Ошибка создания доски: %v", err)
 		http.Error(w, "Ошибка создания доски", http.StatusInternalServerError)
 		return
 	}
 
 	// WebSocket уведомление для главной страницы
-	WsHub.BroadcastToHome(WSMessage{
+	h.hub.BroadcastToHome(WSMessage{
 		Type: "new_board",
 		Data: map[string]interface{}{
 			"id":          id,
@@ -301,8 +542,9 @@ func (h *Handler) CreateThreadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
 	if err := r.ParseMultipartForm(100 << 20); err != nil {
-		http.Error(w, "Ошибка парсинга формы", http.StatusBadRequest)
+		http.Error(w, "Ошибка парсинга формы", uploadErrorStatus(err))
 		return
 	}
 
@@ -321,44 +563,34 @@ func (h *Handler) CreateThreadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Проверяем существование доски
-	board, _ := database.GetBoard(boardID)
+	board, _ := h.store.GetBoard(boardID)
 	if board == nil {
 		http.Error(w, "Доска не найдена", http.StatusNotFound)
 		return
 	}
 
-	// Создаём тред
-	threadID, err := database.CreateThread(boardID, subject)
-	if err != nil {
-		log.Printf("Ошибка создания треда: %v", err)
-		http.Error(w, "Ошибка создания треда", http.StatusInternalServerError)
-		return
-	}
-
 	// Сохраняем медиафайл
-	fileInfo, err := saveFile(r, "media", threadID)
+	media, err := h.saveFile(r, "media", board.Settings)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), uploadErrorStatus(err))
 		return
 	}
 
-	mediaPath := ""
-	mediaType := ""
-	if fileInfo != nil {
-		mediaPath = fileInfo.Path
-		mediaType = fileInfo.Type
-	}
-
-	// Создаём первый пост (OP)
-	postID, err := database.CreatePost(int(threadID), nil, author, content, mediaPath, mediaType)
+	// Создаём тред и первый пост (OP) атомарно
+	threadID, postID, err := h.store.CreateThreadWithOP(boardID, subject, author, content, media)
 	if err != nil {
-		log.Printf("Ошибка создания поста: %v", err)
-		http.Error(w, "Ошибка создания поста", http.StatusInternalServerError)
+		h.discardMedia(media)
+		if errors.Is(err, database.ErrMediaGone) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("Ошибка создания треда: %v", err)
+		http.Error(w, "Ошибка создания треда", http.StatusInternalServerError)
 		return
 	}
 
 	// WebSocket уведомление для доски
-	WsHub.BroadcastToBoard(boardID, WSMessage{
+	h.hub.BroadcastToBoard(boardID, WSMessage{
 		Type:     "new_thread",
 		ThreadID: int(threadID),
 		BoardID:  boardID,
@@ -368,8 +600,9 @@ func (h *Handler) CreateThreadHandler(w http.ResponseWriter, r *http.Request) {
 			"subject":    subject,
 			"author":     author,
 			"content":    content,
-			"media_path": mediaPath,
-			"media_type": mediaType,
+			"media_path": media.Path,
+			"media_type": media.Type,
+			"thumb_path": media.ThumbPath,
 			"created_at": time.Now().Format("02.01.2006 15:04:05"),
 		},
 	})
@@ -385,8 +618,9 @@ func (h *Handler) CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
 	if err := r.ParseMultipartForm(100 << 20); err != nil {
-		http.Error(w, "Ошибка парсинга формы", http.StatusBadRequest)
+		http.Error(w, "Ошибка парсинга формы", uploadErrorStatus(err))
 		return
 	}
 
@@ -419,58 +653,57 @@ func (h *Handler) CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Проверяем существование треда
-	thread, _ := database.GetThread(threadID)
+	thread, _ := h.store.GetThread(threadID)
 	if thread == nil {
 		http.Error(w, "Тред не найден", http.StatusNotFound)
 		return
 	}
 
 	// Сохраняем медиафайл
-	fileInfo, err := saveFile(r, "media", int64(threadID))
+	media, err := h.saveFile(r, "media", h.boardSettings(thread.BoardID))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), uploadErrorStatus(err))
 		return
 	}
 
-	mediaPath := ""
-	mediaType := ""
-	if fileInfo != nil {
-		mediaPath = fileInfo.Path
-		mediaType = fileInfo.Type
-	}
-
 	// Создаём пост
-	postID, err := database.CreatePost(threadID, parentID, author, content, mediaPath, mediaType)
+	postID, err := h.store.CreatePost(threadID, parentID, author, content, media)
 	if err != nil {
+		h.discardMedia(media)
+		if errors.Is(err, database.ErrMediaGone) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		log.Printf("Ошибка создания поста: %v", err)
 		http.Error(w, "Ошибка создания поста", http.StatusInternalServerError)
 		return
 	}
 
 	// Бампаем тред
-	database.BumpThread(threadID)
+	h.store.BumpThread(threadID)
 
 	// Отправляем WebSocket уведомление
 	parentIDVal := 0
 	if parentID != nil {
 		parentIDVal = *parentID
 	}
-	WsHub.BroadcastToThread(threadID, WSMessage{
+	h.hub.BroadcastToThread(threadID, WSMessage{
 		Type:     "new_post",
 		ThreadID: threadID,
 		Data: map[string]interface{}{
 			"id":         postID,
 			"author":     author,
 			"content":    content,
-			"media_path": mediaPath,
-			"media_type": mediaType,
+			"media_path": media.Path,
+			"media_type": media.Type,
+			"thumb_path": media.ThumbPath,
 			"parent_id":  parentIDVal,
 			"created_at": time.Now().Format("02.01.2006 15:04:05"),
 		},
 	})
 
 	// Также уведомляем доску о новом посте
-	WsHub.BroadcastToBoard(thread.BoardID, WSMessage{
+	h.hub.BroadcastToBoard(thread.BoardID, WSMessage{
 		Type:     "thread_updated",
 		ThreadID: threadID,
 		BoardID:  thread.BoardID,
@@ -0,0 +1,426 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"webForum/database"
+	"webForum/storage"
+)
+
+// failingInsertStore хранилище, в котором создание треда и поста
+// завершается ошибкой; запоминает переданный медиафайл
+type failingInsertStore struct {
+	database.Store
+	media database.Media
+}
+
+func (s *failingInsertStore) CreateThreadWithOP(boardID, subject, author, content string, media database.Media) (int64, int64, error) {
+	s.media = media
+	return 0, 0, errors.New("insert failed")
+}
+
+func (s *failingInsertStore) CreatePost(threadID int, parentID *int, author, content string, media database.Media) (int64, error) {
+	s.media = media
+	return 0, errors.New("insert failed")
+}
+
+// gcRaceStore хранилище, в котором сборщик неиспользуемых файлов
+// срабатывает один раз до (before) или после (after) поиска дубликата
+// загружаемого файла
+type gcRaceStore struct {
+	database.Store
+	before, after func()
+}
+
+func (s *gcRaceStore) LeaseMedia(hash string, until time.Time) (*database.Media, error) {
+	if s.before != nil {
+		s.before()
+		s.before = nil
+	}
+	m, err := s.Store.LeaseMedia(hash, until)
+	if s.after != nil {
+		s.after()
+		s.after = nil
+	}
+	return m, err
+}
+
+// formRequest multipart-запрос формы с файлом в поле media
+func formRequest(t *testing.T, url string, fields map[string]string, filename string, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	if filename != "" {
+		fw, err := mw.CreateFormFile("media", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, url, &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+// storedKeys ключи файлов в хранилище
+func storedKeys(t *testing.T, media storage.MediaStore) map[string]bool {
+	t.Helper()
+	keys := make(map[string]bool)
+	err := media.List(func(obj storage.ObjectInfo) error {
+		keys[obj.Key] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// otherPNG картинка 16×16, отличная от testPNG
+func otherPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 3)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCreateThreadDiscardsMediaOnError(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+	failing := &failingInsertStore{Store: h.store}
+	h.store = failing
+
+	fields := map[string]string{"board_id": "b", "subject": "тема", "content": "текст"}
+	w := httptest.NewRecorder()
+	h.CreateThreadHandler(w, formRequest(t, "/create-thread", fields, "pic.png", testPNG(t)))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("код %d, ожидался 500", w.Code)
+	}
+
+	if failing.media.ID == 0 {
+		t.Fatal("медиафайл не был сохранён до создания треда")
+	}
+	if m, err := h.store.GetMediaByHash(failing.media.Hash); err != nil || m != nil {
+		t.Errorf("запись media осталась: %v, %v", m, err)
+	}
+	if keys := storedKeys(t, h.media); len(keys) != 0 {
+		t.Errorf("в хранилище остались файлы: %v", keys)
+	}
+}
+
+func TestCreatePostDiscardsMediaOnError(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+
+	// Тред с картинкой: её запись и файлы используются постом
+	fields := map[string]string{"board_id": "b", "subject": "тема", "content": "текст"}
+	w := httptest.NewRecorder()
+	h.CreateThreadHandler(w, formRequest(t, "/create-thread", fields, "pic.png", testPNG(t)))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("создание треда: код %d", w.Code)
+	}
+	before := storedKeys(t, h.media)
+	if len(before) == 0 {
+		t.Fatal("файлы треда не сохранены")
+	}
+
+	failing := &failingInsertStore{Store: h.store}
+	h.store = failing
+	post := func(data []byte) {
+		t.Helper()
+		fields := map[string]string{"thread_id": "1", "content": "ответ"}
+		w := httptest.NewRecorder()
+		h.CreatePostHandler(w, formRequest(t, "/create-post", fields, "pic.png", data))
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("код %d, ожидался 500", w.Code)
+		}
+	}
+
+	// Та же картинка: запись используется тредом и остаётся
+	post(testPNG(t))
+	if m, _ := h.store.GetMediaByHash(failing.media.Hash); m == nil {
+		t.Error("удалена запись media, на которую ссылается тред")
+	}
+	if keys := storedKeys(t, h.media); len(keys) != len(before) {
+		t.Errorf("файлы треда изменились: %v, было %v", keys, before)
+	}
+
+	// Новая картинка удаляется вместе с миниатюрой
+	post(otherPNG(t))
+	if m, _ := h.store.GetMediaByHash(failing.media.Hash); m != nil {
+		t.Error("запись media нового файла осталась")
+	}
+	if keys := storedKeys(t, h.media); len(keys) != len(before) {
+		t.Errorf("в хранилище остались файлы: %v, было %v", keys, before)
+	}
+}
+
+func TestReuploadRacingGC(t *testing.T) {
+	collect := func(h *Handler, store database.Store) {
+		if _, err := CollectGarbage(store, h.media, -time.Minute, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deleteFiles := func(h *Handler, store database.Store) {
+		for key := range storedKeys(t, h.media) {
+			h.media.Delete(key)
+		}
+	}
+	tests := []struct {
+		name          string
+		before, after func(h *Handler, store database.Store)
+	}{
+		{"сборка после поиска дубликата", nil, collect},
+		{"сборка до поиска дубликата", collect, nil},
+		{"сборщик удалил файлы пересозданной записи", deleteFiles, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			createTestBoard(t, h, "b", database.AllMediaTypes...)
+
+			// Файл загружен, но пост с ним так и не создан
+			orphan, err := h.storeUpload(bytes.NewReader(testPNG(t)), "pic.png", h.boardSettings("b"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			store := h.store
+			race := &gcRaceStore{Store: store}
+			if tt.before != nil {
+				race.before = func() { tt.before(h, store) }
+			}
+			if tt.after != nil {
+				race.after = func() { tt.after(h, store) }
+			}
+			h.store = race
+			fields := map[string]string{"board_id": "b", "subject": "тема", "content": "текст"}
+			w := httptest.NewRecorder()
+			h.CreateThreadHandler(w, formRequest(t, "/create-thread", fields, "pic.png", testPNG(t)))
+			if w.Code != http.StatusSeeOther {
+				t.Fatalf("создание треда: код %d: %s", w.Code, w.Body)
+			}
+
+			// Пост ссылается на существующую запись, файлы на месте
+			op, err := store.GetFirstPost(1)
+			if err != nil || op == nil {
+				t.Fatalf("первый пост: %v, %v", op, err)
+			}
+			m, err := store.GetMediaByHash(orphan.Hash)
+			if err != nil || m == nil || !op.MediaID.Valid || op.MediaID.Int64 != m.ID {
+				t.Fatalf("пост ссылается на media %v, запись %+v, %v", op.MediaID, m, err)
+			}
+			keys := storedKeys(t, h.media)
+			if !op.MediaPath.Valid {
+				t.Fatal("пост без файла")
+			}
+			for _, p := range []string{op.MediaPath.String, op.ThumbPath.String} {
+				if p != "" && !keys[strings.TrimPrefix(p, uploadsPrefix)] {
+					t.Errorf("файла %s нет в хранилище: %v", p, keys)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadsHandlerServesRecordedMIME(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+
+	// .m4a допускает видео MP4: тип по расширению был бы audio/mp4
+	clip := append([]byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00isomiso2"), make([]byte, 64)...)
+	for _, upload := range []struct {
+		name string
+		data []byte
+	}{{"clip.m4a", clip}, {"pic.png", testPNG(t)}} {
+		fields := map[string]string{"board_id": "b", "subject": upload.name, "content": "текст"}
+		w := httptest.NewRecorder()
+		h.CreateThreadHandler(w, formRequest(t, "/create-thread", fields, upload.name, upload.data))
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("%s: код %d", upload.name, w.Code)
+		}
+	}
+
+	uploads := http.StripPrefix(uploadsPrefix, UploadsHandler(h.store, h.media))
+	contentType := func(p string) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		uploads.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: код %d", p, w.Code)
+		}
+		return w.Header().Get("Content-Type")
+	}
+
+	for key := range storedKeys(t, h.media) {
+		p := publicPath(key)
+		want := mimeForPath(key)
+		if hash := hashFromPath(p); hash != "" {
+			m, err := h.store.GetMediaByHash(hash)
+			if err != nil || m == nil {
+				t.Fatalf("нет записи media для %s: %v", key, err)
+			}
+			want = m.MIME
+		}
+		if got := contentType(p); got != want {
+			t.Errorf("%s: Content-Type %q, ожидался %q", key, got, want)
+		}
+	}
+
+	sum := sha256.Sum256(clip)
+	key := contentKey(hex.EncodeToString(sum[:]), ".m4a")
+	if got := contentType(publicPath(key)); got != "video/mp4" {
+		t.Errorf("клип: Content-Type %q, ожидался video/mp4", got)
+	}
+}
+
+func TestPagesPassEventID(t *testing.T) {
+	// Шаблоны загружаются из templates/ относительно корня проекта
+	t.Chdir("..")
+	h := NewHandler(database.NewMemoryStore(), storage.NewLocalStore(t.TempDir()), WSConfig{})
+	h.uploads = newUploadSessions(t.TempDir())
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+	if _, _, err := h.store.CreateThreadWithOP("b", "тема", "", "текст", database.Media{}); err != nil {
+		t.Fatal(err)
+	}
+	h.hub.BroadcastToHome(WSMessage{Type: "new_board"})
+
+	// Страница передаёт ID последнего события при подключении WebSocket и SSE
+	want := regexp.MustCompile(`let lastEventID = \s*` + strconv.FormatUint(h.hub.LastEventID(), 10) + `\s*;`)
+	for _, path := range []string{"/", "/board/b", "/thread/1"} {
+		w := httptest.NewRecorder()
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", h.IndexHandler)
+		mux.HandleFunc("/board/", h.BoardHandler)
+		mux.HandleFunc("/thread/", h.ThreadHandler)
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: код %d", path, w.Code)
+		}
+		body := w.Body.String()
+		if !want.MatchString(body) {
+			t.Errorf("%s: нет %s", path, want)
+		}
+		if strings.Count(body, "last_event_id=' + lastEventID") != 2 {
+			t.Errorf("%s: WebSocket и SSE подключаются без last_event_id", path)
+		}
+	}
+}
+
+func TestPostsBroadcastToWebSocket(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+	conn := dialWS(t, newWSServer(t, h), "/ws")
+	if reply := sendControl(t, conn, "subscribe", "board:b", "thread:1"); reply.Type != "subscribed" {
+		t.Fatalf("ответ на подписку: %+v", reply)
+	}
+
+	// content поля data события
+	content := func(msg WSMessage) string {
+		data, _ := msg.Data.(map[string]interface{})
+		s, _ := data["content"].(string)
+		return s
+	}
+
+	fields := map[string]string{"board_id": "b", "subject": "тема", "content": "OP"}
+	w := httptest.NewRecorder()
+	h.CreateThreadHandler(w, formRequest(t, "/api/thread", fields, "", nil))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("создание треда: код %d", w.Code)
+	}
+	if msg := readWS(t, conn); msg.Type != "new_thread" || msg.Topic != "board:b" || msg.ThreadID != 1 || content(msg) != "OP" {
+		t.Errorf("событие создания треда: %+v", msg)
+	}
+
+	posts := []struct {
+		name string
+		post func()
+	}{
+		{"форма", func() {
+			w := httptest.NewRecorder()
+			h.CreatePostHandler(w, formRequest(t, "/api/post", map[string]string{"thread_id": "1", "content": "форма"}, "", nil))
+			if w.Code != http.StatusSeeOther {
+				t.Fatalf("пост из формы: код %d", w.Code)
+			}
+		}},
+		{"API", func() {
+			post := map[string]interface{}{"thread_id": 1, "content": "API"}
+			if code := serve(t, h.APICreatePost, jsonRequest(t, "/api/v1/posts", post), nil); code != http.StatusOK {
+				t.Fatalf("пост через API: код %d", code)
+			}
+		}},
+	}
+	for _, tt := range posts {
+		tt.post()
+		msg := readWS(t, conn)
+		if msg.Type != "new_post" || msg.Topic != "thread:1" || msg.ThreadID != 1 || content(msg) != tt.name {
+			t.Errorf("%s: событие нового поста %+v", tt.name, msg)
+		}
+		if msg := readWS(t, conn); msg.Type != "thread_updated" || msg.Topic != "board:b" {
+			t.Errorf("%s: событие доски %+v", tt.name, msg)
+		}
+	}
+}
+
+func TestUploadsHandlerSVGPolicy(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+
+	fields := map[string]string{"board_id": "b", "subject": "svg", "content": "текст"}
+	w := httptest.NewRecorder()
+	h.CreateThreadHandler(w, formRequest(t, "/create-thread", fields, "pic.svg", []byte(`<svg><rect width="1" height="1"/></svg>`)))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("загрузка SVG: код %d", w.Code)
+	}
+
+	// SVG, сохранённый до появления санитайзера: записи media нет
+	legacy := []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`)
+	if err := h.media.Put("00/00/legacy.svg", bytes.NewReader(legacy), int64(len(legacy)), "image/svg+xml"); err != nil {
+		t.Fatal(err)
+	}
+	png := []byte("\x89PNG\r\n\x1a\n")
+	if err := h.media.Put("00/00/legacy.png", bytes.NewReader(png), int64(len(png)), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	uploads := http.StripPrefix(uploadsPrefix, UploadsHandler(h.store, h.media))
+	for key := range storedKeys(t, h.media) {
+		w := httptest.NewRecorder()
+		uploads.ServeHTTP(w, httptest.NewRequest(http.MethodGet, publicPath(key), nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: код %d", key, w.Code)
+		}
+		csp := w.Header().Get("Content-Security-Policy")
+		if strings.HasSuffix(key, ".svg") {
+			if w.Header().Get("Content-Type") != "image/svg+xml" || !strings.HasPrefix(csp, "sandbox; default-src 'none'") {
+				t.Errorf("%s: Content-Type %q, CSP %q", key, w.Header().Get("Content-Type"), csp)
+			}
+		} else if csp != "" {
+			t.Errorf("%s: CSP %q у не-SVG", key, csp)
+		}
+	}
+}
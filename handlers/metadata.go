@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+
+	_ "golang.org/x/image/webp" // декодер WebP для image.DecodeConfig
+)
+
+// Метаданные вырезаются из контейнера без перекодирования, поэтому
+// качество картинки не меняется. Оставляется только то, что влияет на
+// отображение: ICC-профиль, JFIF/Adobe-заголовки JPEG и ориентация из EXIF.
+
+var errBadImage = errors.New("повреждённый файл изображения")
+
+// stripMetadata удаляет EXIF, XMP, IPTC, комментарии и текстовые блоки
+// из JPEG, PNG и WebP. Для остальных типов данные возвращаются как есть.
+func stripMetadata(mime string, data []byte) ([]byte, error) {
+	switch mime {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data)
+	}
+	return data, nil
+}
+
+// imageSize размеры картинки с учётом EXIF-ориентации JPEG
+// (при повороте на 90° ширина и высота меняются местами)
+func imageSize(mime string, data []byte) (width, height int, err error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, errBadImage
+	}
+	if mime == "image/jpeg" && jpegOrientation(data) >= 5 {
+		return cfg.Height, cfg.Width, nil
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// === JPEG ===
+
+const (
+	jpegRST0 = 0xD0 // маркеры перезапуска RST0-RST7 внутри сжатых данных
+	jpegRST7 = 0xD7
+	jpegSOI  = 0xD8
+	jpegEOI  = 0xD9
+	jpegSOS  = 0xDA
+	jpegAPP0 = 0xE0
+	jpegAPP1 = 0xE1
+	jpegAPP2 = 0xE2
+	jpegAPPE = 0xEE // Adobe: цветовое преобразование
+	jpegAPPF = 0xEF
+	jpegCOM  = 0xFE
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// jpegSegment сегмент JPEG до начала сжатых данных (SOS)
+type jpegSegment struct {
+	marker  byte
+	raw     []byte // сегмент целиком, включая FF и маркер
+	payload []byte // данные после длины
+}
+
+// jpegSegments разбирает заголовок JPEG. rest - данные с маркера SOS
+// до конца файла, из них jpegImageData оставляет сами сканы.
+func jpegSegments(data []byte) (segments []jpegSegment, rest []byte, err error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != jpegSOI {
+		return nil, nil, errBadImage
+	}
+	pos := 2
+	for {
+		// Перед маркером допускаются байты-заполнители 0xFF
+		for pos < len(data) && data[pos] == 0xFF && pos+1 < len(data) && data[pos+1] == 0xFF {
+			pos++
+		}
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return nil, nil, errBadImage
+		}
+		marker := data[pos+1]
+		if marker == jpegSOS || marker == jpegEOI {
+			return segments, data[pos:], nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, nil, errBadImage
+		}
+		segments = append(segments, jpegSegment{
+			marker:  marker,
+			raw:     data[pos:end],
+			payload: data[pos+4 : end],
+		})
+		pos = end
+	}
+}
+
+// stripJPEG оставляет JFIF (APP0), ICC-профиль (APP2) и Adobe (APP14);
+// EXIF заменяется минимальным блоком с одной ориентацией
+func stripJPEG(data []byte) ([]byte, error) {
+	segments, rest, err := jpegSegments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write([]byte{0xFF, jpegSOI})
+	for _, seg := range segments {
+		switch {
+		case seg.marker == jpegAPP1:
+			if o := exifOrientation(seg.payload); o > 1 {
+				out.Write(orientationSegment(o))
+			}
+		case seg.marker == jpegAPP2:
+			if bytes.HasPrefix(seg.payload, []byte("ICC_PROFILE\x00")) {
+				out.Write(seg.raw)
+			}
+		case seg.marker == jpegAPP0, seg.marker == jpegAPPE:
+			out.Write(seg.raw)
+		case seg.marker > jpegAPP0 && seg.marker <= jpegAPPF, seg.marker == jpegCOM:
+			// IPTC, XMP, данные камер и комментарии
+		default:
+			out.Write(seg.raw)
+		}
+	}
+	scans, err := jpegImageData(rest)
+	if err != nil {
+		return nil, err
+	}
+	out.Write(scans)
+	return out.Bytes(), nil
+}
+
+// jpegImageData сканы с первого SOS до EOI включительно. Всё после EOI
+// отбрасывается: там бывают MPF-кадры и превью со своим EXIF и GPS и
+// дописанные блоки APP1. Сегменты APPn и комментарии между сканами
+// прогрессивного JPEG тоже удаляются.
+func jpegImageData(rest []byte) ([]byte, error) {
+	out := make([]byte, 0, len(rest))
+	pos := 0
+	for pos < len(rest) {
+		if rest[pos] != 0xFF || pos+1 >= len(rest) {
+			return nil, errBadImage
+		}
+		marker := rest[pos+1]
+		switch {
+		case marker == 0xFF: // байт-заполнитель
+			pos++
+			continue
+		case marker == jpegEOI:
+			return append(out, 0xFF, jpegEOI), nil
+		case marker >= jpegRST0 && marker <= jpegRST7:
+			out = append(out, rest[pos:pos+2]...)
+			pos += 2
+			continue
+		}
+
+		if pos+4 > len(rest) {
+			return nil, errBadImage
+		}
+		length := int(binary.BigEndian.Uint16(rest[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(rest) {
+			return nil, errBadImage
+		}
+		if !(marker >= jpegAPP0 && marker <= jpegAPPF) && marker != jpegCOM {
+			out = append(out, rest[pos:end]...)
+		}
+		pos = end
+
+		if marker == jpegSOS {
+			// Сжатые данные идут до первого маркера, кроме FF00
+			// (экранированный байт FF) и RSTn
+			start := pos
+			for pos < len(rest) {
+				if rest[pos] == 0xFF && pos+1 < len(rest) {
+					next := rest[pos+1]
+					if next == 0x00 || (next >= jpegRST0 && next <= jpegRST7) {
+						pos += 2
+						continue
+					}
+					break
+				}
+				pos++
+			}
+			out = append(out, rest[start:pos]...)
+		}
+	}
+	// Файл оборван до EOI: декодеры показывают такие картинки, оставляем
+	// полученные данные
+	return out, nil
+}
+
+// jpegOrientation значение EXIF Orientation (1-8); 1, если его нет
+func jpegOrientation(data []byte) int {
+	segments, _, err := jpegSegments(data)
+	if err != nil {
+		return 1
+	}
+	for _, seg := range segments {
+		if seg.marker == jpegAPP1 {
+			if o := exifOrientation(seg.payload); o > 1 {
+				return o
+			}
+		}
+	}
+	return 1
+}
+
+// exifOrientation читает тег Orientation (0x0112) из IFD0 блока EXIF
+func exifOrientation(payload []byte) int {
+	if !bytes.HasPrefix(payload, exifHeader) {
+		return 1
+	}
+	tiff := payload[len(exifHeader):]
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		// Тег 0x0112, тип SHORT: значение в первых двух байтах поля
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+		}
+	}
+	return 1
+}
+
+// orientationSegment сегмент APP1 с EXIF, в котором есть только ориентация
+func orientationSegment(orientation int) []byte {
+	be := binary.BigEndian
+	payload := append([]byte{}, exifHeader...)
+	payload = append(payload, "MM\x00\x2A"...)              // TIFF, big-endian
+	payload = be.AppendUint32(payload, 8)                   // смещение IFD0
+	payload = be.AppendUint16(payload, 1)                   // одна запись
+	payload = be.AppendUint16(payload, 0x0112)              // Orientation
+	payload = be.AppendUint16(payload, 3)                   // тип SHORT
+	payload = be.AppendUint32(payload, 1)                   // одно значение
+	payload = be.AppendUint16(payload, uint16(orientation)) // значение
+	payload = append(payload, 0, 0)                         // выравнивание поля значения
+	payload = be.AppendUint32(payload, 0)                   // следующего IFD нет
+
+	seg := []byte{0xFF, jpegAPP1}
+	seg = be.AppendUint16(seg, uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// === PNG ===
+
+// pngMetadataChunks текстовые и служебные блоки PNG
+var pngMetadataChunks = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+// stripPNG копирует блоки PNG, кроме текстовых, EXIF и времени изменения
+func stripPNG(data []byte) ([]byte, error) {
+	const sigLen = 8
+	if len(data) < sigLen {
+		return nil, errBadImage
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:sigLen])
+
+	for pos := sigLen; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, errBadImage
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length // длина, тип, данные, CRC
+		if length < 0 || end > len(data) {
+			return nil, errBadImage
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		if !pngMetadataChunks[chunkType] {
+			out.Write(data[pos:end])
+		}
+		pos = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// === WebP ===
+
+// Флаги заголовка VP8X
+const (
+	vp8xFlagEXIF = 0x08
+	vp8xFlagXMP  = 0x04
+)
+
+// stripWebP удаляет блоки EXIF и XMP из RIFF-контейнера WebP
+// и снимает соответствующие флаги в VP8X
+func stripWebP(data []byte) ([]byte, error) {
+	if !riff(data, "WEBP") {
+		return nil, errBadImage
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12]) // размер RIFF исправляется в конце
+
+	for pos := 12; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, errBadImage
+		}
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size + size%2 // блоки выровнены по чётной границе
+		if size < 0 || end > len(data) {
+			return nil, errBadImage
+		}
+		switch string(data[pos : pos+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte{}, data[pos:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= vp8xFlagEXIF | vp8xFlagXMP
+			}
+			out.Write(chunk)
+		default:
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+
+	result := out.Bytes()
+	binary.LittleEndian.PutUint32(result[4:], uint32(len(result)-8))
+	return result, nil
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testImage картинка с шумом: в сжатых данных JPEG встречаются байты FF,
+// экранированные как FF00
+func testImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i*131 + i/7)
+	}
+	return img
+}
+
+// exifSegment сегмент APP1 с EXIF, в котором записаны ориентация и
+// строка secret (как будто GPS-координаты)
+func exifSegment(orientation int, secret string) []byte {
+	seg := orientationSegment(orientation)
+	seg = append(seg, secret...)
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(seg)-2))
+	return seg
+}
+
+// withSegments вставляет сегменты сразу после SOI
+func withSegments(jpg []byte, segments ...[]byte) []byte {
+	out := append([]byte{}, jpg[:2]...)
+	for _, seg := range segments {
+		out = append(out, seg...)
+	}
+	return append(out, jpg[2:]...)
+}
+
+func encodeJPEG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStripJPEG(t *testing.T) {
+	plain := encodeJPEG(t)
+	xmp := append([]byte{0xFF, jpegAPP1, 0, 0}, "http://ns.adobe.com/xap/1.0/\x00GPSDATA"...)
+	binary.BigEndian.PutUint16(xmp[2:], uint16(len(xmp)-2))
+	comment := append([]byte{0xFF, jpegCOM, 0, 9}, "GPSDATA"...)
+
+	// Второй JPEG после EOI, как MPF-кадр, со своим EXIF
+	trailer := withSegments(encodeJPEG(t), exifSegment(1, "GPSDATA"))
+
+	tests := []struct {
+		name        string
+		data        []byte
+		orientation int
+	}{
+		{"без метаданных", plain, 1},
+		{"EXIF с ориентацией", withSegments(plain, exifSegment(6, "GPSDATA")), 6},
+		{"XMP и комментарий", withSegments(plain, xmp, comment), 1},
+		{"JPEG после EOI", append(withSegments(plain, exifSegment(3, "GPSDATA")), trailer...), 3},
+		{"APP1 после EOI", append(append([]byte{}, plain...), exifSegment(1, "GPSDATA")...), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := stripMetadata("image/jpeg", tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(out, []byte("GPSDATA")) {
+				t.Error("метаданные остались в файле")
+			}
+			if !bytes.HasSuffix(out, []byte{0xFF, jpegEOI}) {
+				t.Error("файл не заканчивается EOI")
+			}
+			if o := jpegOrientation(out); o != tt.orientation {
+				t.Errorf("ориентация %d, ожидалась %d", o, tt.orientation)
+			}
+
+			// Сжатые данные не изменились
+			want, err := jpeg.Decode(bytes.NewReader(plain))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := jpeg.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("результат не декодируется: %v", err)
+			}
+			if !bytes.Equal(got.(*image.YCbCr).Y, want.(*image.YCbCr).Y) {
+				t.Error("изображение изменилось")
+			}
+		})
+	}
+}
+
+// Маркеры перезапуска и экранированные FF внутри скана не считаются концом
+// данных
+func TestJPEGImageDataRestartMarkers(t *testing.T) {
+	sos := []byte{0xFF, jpegSOS, 0x00, 0x08, 1, 1, 0x00, 0, 63, 0}
+	scan := []byte{0x12, 0xFF, 0x00, 0x34, 0xFF, jpegRST0, 0x56, 0xFF, jpegRST7, 0x78}
+	rest := append(append(append([]byte{}, sos...), scan...), 0xFF, jpegEOI)
+	rest = append(rest, exifSegment(1, "GPSDATA")...)
+
+	out, err := jpegImageData(rest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(append(append([]byte{}, sos...), scan...), 0xFF, jpegEOI)
+	if !bytes.Equal(out, want) {
+		t.Errorf("получено % X\nожидалось % X", out, want)
+	}
+}
+
+// pngChunk блок PNG с правильной CRC
+func pngChunk(typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func TestStripPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+	const headerEnd = 8 + 25 // сигнатура и IHDR
+
+	var meta []byte
+	meta = append(meta, pngChunk("tEXt", []byte("Comment\x00GPSDATA"))...)
+	meta = append(meta, pngChunk("eXIf", []byte("MM\x00\x2AGPSDATA"))...)
+	meta = append(meta, pngChunk("tIME", []byte{0x07, 0xE9, 1, 1, 0, 0, 0})...)
+	withMeta := append(append(append([]byte{}, plain[:headerEnd]...), meta...), plain[headerEnd:]...)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"без метаданных", plain},
+		{"текст, EXIF и время", withMeta},
+		{"данные после IEND", append(append([]byte{}, withMeta...), exifSegment(1, "GPSDATA")...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := stripMetadata("image/png", tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(out, []byte("GPSDATA")) {
+				t.Error("метаданные остались в файле")
+			}
+			if !bytes.Equal(out, plain) {
+				t.Error("результат отличается от файла без метаданных")
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"webForum/database"
+)
+
+// Ошибки политики загрузок доски; uploadErrorStatus отдаёт для них
+// 415 и 413 вместо 400
+var (
+	errMediaTypeNotAllowed = errors.New("тип файла не разрешён на этой доске")
+	errMediaTooLarge       = errors.New("файл слишком большой")
+)
+
+// maxFormSize предел тела multipart-формы с файлом: сам файл и остальные поля
+const maxFormSize = maxUploadSize + 1<<20
+
+// checkMediaPolicy проверяет расширение и размер файла по настройкам
+// доски. Тип содержимого проверяется позже, в storeUpload.
+func checkMediaPolicy(settings database.BoardSettings, ext string, size int64) error {
+	format, ok := allowedExtensions[ext]
+	if !ok {
+		return fmt.Errorf("недопустимый тип файла: %s", ext)
+	}
+	if !settings.AllowsMediaType(format.Type) {
+		return fmt.Errorf("%w: %s", errMediaTypeNotAllowed, ext)
+	}
+	if size > settings.MaxFileSize {
+		return fmt.Errorf("%w: максимум %s", errMediaTooLarge, formatSize(settings.MaxFileSize))
+	}
+	return nil
+}
+
+// checkStoredMedia проверяет уже сохранённый файл по настройкам доски, на
+// которую его прикрепляют: файл могли загрузить для другой доски
+func checkStoredMedia(settings database.BoardSettings, m database.Media) error {
+	if !settings.AllowsMediaType(m.Type) {
+		return fmt.Errorf("%w: %s", errMediaTypeNotAllowed, m.Type)
+	}
+	if m.Size > settings.MaxFileSize {
+		return fmt.Errorf("%w: максимум %s", errMediaTooLarge, formatSize(settings.MaxFileSize))
+	}
+	return nil
+}
+
+// uploadBoardSettings настройки доски, для которой загружается файл.
+// board_id обязателен: иначе файл проверялся бы по настройкам по
+// умолчанию. Нет board_id - 400, неизвестная доска - 404; ответ клиенту
+// уже отправлен, если ok = false.
+func (h *Handler) uploadBoardSettings(w http.ResponseWriter, boardID string) (database.BoardSettings, bool) {
+	if boardID == "" {
+		sendError(w, http.StatusBadRequest, "board_id обязателен")
+		return database.BoardSettings{}, false
+	}
+	board, err := h.store.GetBoard(boardID)
+	if err != nil {
+		log.Printf("API: ошибка получения доски: %v", err)
+		sendError(w, http.StatusInternalServerError, "Ошибка получения доски")
+		return database.BoardSettings{}, false
+	}
+	if board == nil {
+		sendError(w, http.StatusNotFound, "Доска не найдена")
+		return database.BoardSettings{}, false
+	}
+	return board.Settings, true
+}
+
+// uploadErrorStatus HTTP-код ответа для ошибки разбора формы с файлом
+// или его сохранения
+func uploadErrorStatus(err error) int {
+	var maxBytes *http.MaxBytesError
+	switch {
+	case errors.Is(err, errMediaTooLarge), errors.As(err, &maxBytes):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errMediaTypeNotAllowed):
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// validateMediaSettings проверяет типы файлов и лимит размера из запроса
+// на создание или изменение доски. Лимит доски не может превышать
+// maxUploadSize - предел сервера.
+func validateMediaSettings(settings database.BoardSettings) error {
+	for _, t := range settings.MediaTypes {
+		if !slices.Contains(database.AllMediaTypes, t) {
+			return fmt.Errorf("неизвестный тип файлов: %s (допустимы %s)", t, strings.Join(database.AllMediaTypes, ", "))
+		}
+	}
+	if settings.MaxFileSize <= 0 || settings.MaxFileSize > maxUploadSize {
+		return fmt.Errorf("max_file_size должен быть от 1 до %d байт", maxUploadSize)
+	}
+	return nil
+}
+
+// normalizeMediaTypes проверенные типы файлов без повторов в порядке
+// AllMediaTypes
+func normalizeMediaTypes(types []string) []string {
+	result := []string{}
+	for _, t := range database.AllMediaTypes {
+		if slices.Contains(types, t) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// boardExtensions расширения файлов, разрешённые на доске, по алфавиту
+func boardExtensions(settings database.BoardSettings) []string {
+	exts := []string{}
+	for ext, format := range allowedExtensions {
+		if settings.AllowsMediaType(format.Type) {
+			exts = append(exts, ext)
+		}
+	}
+	slices.Sort(exts)
+	return exts
+}
+
+// MediaPolicyResponse политика загрузок доски для API: клиент может
+// проверить файл до отправки
+type MediaPolicyResponse struct {
+	Types       []string `json:"types"`         // разрешённые типы: image, video, audio
+	Extensions  []string `json:"extensions"`    // разрешённые расширения
+	MaxFileSize int64    `json:"max_file_size"` // байт
+}
+
+// newMediaPolicyResponse преобразует настройки доски в ответ API
+func newMediaPolicyResponse(settings database.BoardSettings) MediaPolicyResponse {
+	return MediaPolicyResponse{
+		Types:       append([]string{}, settings.MediaTypes...),
+		Extensions:  boardExtensions(settings),
+		MaxFileSize: settings.MaxFileSize,
+	}
+}
+
+// mediaAccept значение атрибута accept поля файла в форме доски
+func mediaAccept(settings database.BoardSettings) string {
+	return strings.Join(boardExtensions(settings), ",")
+}
+
+// mediaFormats список форматов для подсказки у поля файла: JPG, PNG, ...
+func mediaFormats(settings database.BoardSettings) string {
+	exts := boardExtensions(settings)
+	for i, ext := range exts {
+		exts[i] = strings.ToUpper(strings.TrimPrefix(ext, "."))
+	}
+	return strings.Join(exts, ", ")
+}
+
+// formatSize размер в байтах для людей: 100 MB, 512 KB
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%d MB", n>>20)
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%d KB", n>>10)
+	}
+	return fmt.Sprintf("%d B", n)
+}
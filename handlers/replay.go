@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"log"
+	"time"
+)
+
+// replayBufferSize сколько последних событий каждой темы хранится для
+// повторной отправки переподключившимся клиентам. Меньше clientSendBuffer,
+// чтобы пропущенные события темы помещались в очередь нового соединения.
+const replayBufferSize = 50
+
+// replayWindow сколько хранится буфер темы без подписчиков и новых
+// событий. Клиент, отключившийся дольше, получит resync_required.
+const replayWindow = 10 * time.Minute
+
+// bufferedEvent событие в буфере темы, уже сериализованное
+type bufferedEvent struct {
+	id   uint64
+	data []byte
+}
+
+// topicLog кольцевой буфер последних событий темы
+type topicLog struct {
+	events  [replayBufferSize]bufferedEvent
+	head    int    // индекс самого старого события
+	n       int    // событий в буфере
+	floor   uint64 // все события темы с ID больше floor есть в буфере
+	updated time.Time
+}
+
+// add добавляет событие, вытесняя самое старое при заполненном буфере
+func (l *topicLog) add(e bufferedEvent, now time.Time) {
+	if l.n == len(l.events) {
+		l.floor = l.events[l.head].id
+		l.head = (l.head + 1) % len(l.events)
+	} else {
+		l.n++
+	}
+	l.events[(l.head+l.n-1)%len(l.events)] = e
+	l.updated = now
+}
+
+// since события с ID больше lastEventID по порядку. false - часть таких
+// событий уже вытеснена из буфера.
+func (l *topicLog) since(lastEventID uint64) ([][]byte, bool) {
+	if lastEventID < l.floor {
+		return nil, false
+	}
+	var missed [][]byte
+	for i := 0; i < l.n; i++ {
+		e := l.events[(l.head+i)%len(l.events)]
+		if e.id > lastEventID {
+			missed = append(missed, e.data)
+		}
+	}
+	return missed, true
+}
+
+// LastEventID ID последнего разосланного события. Страница, отданная
+// после этого момента, передаёт его при подключении как last_event_id,
+// чтобы получить события, случившиеся между рендером и подключением.
+func (h *Hub) LastEventID() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.lastEventID
+}
+
+// record присваивает событию следующий ID и сохраняет его в буфер темы;
+// вызывается под h.mu.Lock
+func (h *Hub) record(topic string, msg WSMessage) ([]byte, bool) {
+	now := time.Now()
+	h.pruneLogs(now)
+
+	h.lastEventID++
+	msg.ID = h.lastEventID
+	data, ok := encodeWSMessage(msg)
+	if !ok {
+		return nil, false
+	}
+
+	l := h.logs[topic]
+	if l == nil {
+		l = &topicLog{floor: h.prunedEventID}
+		h.logs[topic] = l
+	}
+	l.add(bufferedEvent{id: msg.ID, data: data}, now)
+	return data, true
+}
+
+// missed события темы после lastEventID. false - восстановить их нельзя:
+// они вытеснены из буфера или lastEventID выдан не этим процессом.
+// Вызывается под h.mu.
+func (h *Hub) missed(topic string, lastEventID uint64) ([][]byte, bool) {
+	if lastEventID > h.lastEventID {
+		return nil, false
+	}
+	if l := h.logs[topic]; l != nil {
+		return l.since(lastEventID)
+	}
+	// Событий темы нет в памяти: их не было или буфер удалён
+	return nil, lastEventID >= h.prunedEventID
+}
+
+// pruneLogs удаляет буферы тем без подписчиков, в которых не было событий
+// дольше replayWindow. Проверка не чаще раза в минуту; вызывается под
+// h.mu.Lock.
+func (h *Hub) pruneLogs(now time.Time) {
+	if now.Sub(h.prunedAt) < time.Minute {
+		return
+	}
+	h.prunedAt = now
+	for topic, l := range h.logs {
+		if len(h.topics[topic]) > 0 || now.Sub(l.updated) < replayWindow {
+			continue
+		}
+		// События удалённого буфера больше не восстановить
+		last := l.events[(l.head+l.n-1)%len(l.events)].id
+		if last > h.prunedEventID {
+			h.prunedEventID = last
+		}
+		delete(h.logs, topic)
+	}
+}
+
+// replay ставит в очередь клиента события темы после lastEventID или,
+// если их не восстановить или они не помещаются в очередь,
+// resync_required. Вызывается под h.mu.Lock сразу после подписки, поэтому
+// между пропущенными и новыми событиями ничего не теряется. false -
+// очередь клиента заполнена.
+func (h *Hub) replay(client *Client, topic string, lastEventID uint64) bool {
+	events, ok := h.missed(topic, lastEventID)
+	if ok && len(events) <= cap(client.send)-len(client.send) {
+		for _, data := range events {
+			client.enqueue(data)
+		}
+		return true
+	}
+
+	data, ok := encodeWSMessage(WSMessage{Type: "resync_required", Topic: topic})
+	if !ok {
+		return true
+	}
+	log.Printf("%s: события %s после %d не восстановить, клиенту отправлен resync_required", client.transport(), topic, lastEventID)
+	return client.enqueue(data)
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fillLog добавляет в буфер события с ID from..to
+func fillLog(l *topicLog, from, to uint64, now time.Time) {
+	for id := from; id <= to; id++ {
+		l.add(bufferedEvent{id: id, data: []byte{byte(id)}}, now)
+	}
+}
+
+// eventIDs ID событий по данным из fillLog
+func eventIDs(events [][]byte) []uint64 {
+	ids := make([]uint64, len(events))
+	for i, data := range events {
+		ids[i] = uint64(data[0])
+	}
+	return ids
+}
+
+// queued сообщения в очереди клиента без ожидания
+func queued(t *testing.T, c *Client) []WSMessage {
+	t.Helper()
+	var msgs []WSMessage
+	for len(c.send) > 0 {
+		var msg WSMessage
+		if err := json.Unmarshal(<-c.send, &msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// broadcastN отправляет n событий в тему и возвращает их ID
+func broadcastN(hub *Hub, topic string, n int) []uint64 {
+	ids := make([]uint64, n)
+	for i := range ids {
+		hub.Broadcast(topic, WSMessage{Type: "new_post"})
+		ids[i] = hub.LastEventID()
+	}
+	return ids
+}
+
+func TestTopicLogSince(t *testing.T) {
+	l := &topicLog{floor: 100}
+	fillLog(l, 101, 130, time.Now())
+
+	tests := []struct {
+		name string
+		last uint64
+		want []uint64
+		ok   bool
+	}{
+		{"пропущено 20 событий", 110, seq(111, 130), true},
+		{"пропущены все события буфера", 100, seq(101, 130), true},
+		{"ничего не пропущено", 130, nil, true},
+		{"события до начала буфера", 99, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, ok := l.since(tt.last)
+			if ok != tt.ok || !slices.Equal(eventIDs(events), tt.want) {
+				t.Errorf("since(%d) = %v, %v; ожидалось %v, %v", tt.last, eventIDs(events), ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTopicLogOverflow(t *testing.T) {
+	l := &topicLog{floor: 100}
+	last := uint64(100 + replayBufferSize + 10)
+	fillLog(l, 101, last, time.Now())
+
+	// Первые 10 событий вытеснены: клиенту, видевшему только 101, их не восстановить
+	if _, ok := l.since(101); ok {
+		t.Error("since(101): события вытеснены, ожидалось false")
+	}
+	if l.floor != 110 {
+		t.Errorf("floor = %d, ожидалось 110", l.floor)
+	}
+	events, ok := l.since(l.floor)
+	if !ok || !slices.Equal(eventIDs(events), seq(111, last)) {
+		t.Errorf("since(%d) = %v, %v", l.floor, eventIDs(events), ok)
+	}
+}
+
+func TestHubReplayAfterGap(t *testing.T) {
+	hub := NewHub(WSConfig{})
+	ids := broadcastN(hub, "thread:1", 10)
+	broadcastN(hub, "thread:2", 3)
+
+	// Клиент видел третье событие треда и получает остальные семь по порядку
+	client := hub.newClient(nil, "test")
+	if err := hub.Subscribe(client, "thread:1", ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	msgs := queued(t, client)
+	if len(msgs) != 7 {
+		t.Fatalf("получено %d событий, ожидалось 7: %+v", len(msgs), msgs)
+	}
+	for i, msg := range msgs {
+		if msg.ID != ids[3+i] || msg.Topic != "thread:1" {
+			t.Errorf("событие %d: %+v, ожидался ID %d", i, msg, ids[3+i])
+		}
+	}
+
+	// Новые события приходят следом за восстановленными
+	hub.Broadcast("thread:1", WSMessage{Type: "new_post"})
+	if msgs := queued(t, client); len(msgs) != 1 || msgs[0].ID != hub.LastEventID() {
+		t.Errorf("новое событие: %+v", msgs)
+	}
+}
+
+func TestHubReplayResync(t *testing.T) {
+	hub := NewHub(WSConfig{})
+	ids := broadcastN(hub, "thread:1", replayBufferSize+5)
+
+	tests := []struct {
+		name string
+		last uint64
+	}{
+		{"пропуск больше буфера", ids[0]},
+		{"ID из будущего (другой процесс)", hub.LastEventID() + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := hub.newClient(nil, "test")
+			if err := hub.Subscribe(client, "thread:1", tt.last); err != nil {
+				t.Fatal(err)
+			}
+			msgs := queued(t, client)
+			if len(msgs) != 1 || msgs[0].Type != "resync_required" || msgs[0].Topic != "thread:1" {
+				t.Errorf("ожидался resync_required, получено %+v", msgs)
+			}
+		})
+	}
+
+	// Пропущенные события не помещаются в очередь клиента
+	t.Run("очередь почти заполнена", func(t *testing.T) {
+		client := hub.newClient(nil, "test")
+		for i := 0; i < clientSendBuffer-10; i++ {
+			client.send <- []byte(`{"type":"new_post"}`)
+		}
+		if err := hub.Subscribe(client, "thread:1", ids[len(ids)-replayBufferSize]); err != nil {
+			t.Fatal(err)
+		}
+		msgs := queued(t, client)
+		if last := msgs[len(msgs)-1]; len(msgs) != clientSendBuffer-9 || last.Type != "resync_required" {
+			t.Errorf("ожидался resync_required после %d сообщений, получено %d", clientSendBuffer-10, len(msgs))
+		}
+	})
+}
+
+func TestHubReplayExpiredLog(t *testing.T) {
+	hub := NewHub(WSConfig{})
+	ids := broadcastN(hub, "thread:1", 2)
+
+	// Буфер темы без подписчиков старше replayWindow удаляется при
+	// следующей рассылке
+	hub.mu.Lock()
+	hub.logs["thread:1"].updated = time.Now().Add(-replayWindow - time.Minute)
+	hub.prunedAt = time.Now().Add(-2 * time.Minute)
+	hub.mu.Unlock()
+	hub.Broadcast("thread:2", WSMessage{Type: "new_post"})
+	if hub.logs["thread:1"] != nil {
+		t.Fatal("буфер темы не удалён")
+	}
+
+	stale := hub.newClient(nil, "test")
+	hub.Subscribe(stale, "thread:1", ids[0])
+	if msgs := queued(t, stale); len(msgs) != 1 || msgs[0].Type != "resync_required" {
+		t.Errorf("клиент, пропустивший удалённые события: %+v", msgs)
+	}
+
+	// Клиенту, получившему все события темы, восстанавливать нечего
+	current := hub.newClient(nil, "test")
+	hub.Subscribe(current, "thread:1", ids[1])
+	if msgs := queued(t, current); len(msgs) != 0 {
+		t.Errorf("клиент без пропусков: %+v", msgs)
+	}
+}
+
+func TestHubEventIDsAcrossTopics(t *testing.T) {
+	hub := NewHub(WSConfig{})
+	clients := map[string]*Client{}
+	for _, topic := range []string{"thread:1", "board:b", homeTopic} {
+		clients[topic] = hub.newClient(nil, "test")
+		hub.Subscribe(clients[topic], topic, 0)
+	}
+
+	prev := hub.LastEventID()
+	for i := 0; i < 9; i++ {
+		topic := []string{"thread:1", "board:b", homeTopic}[i%3]
+		hub.Broadcast(topic, WSMessage{Type: "new_post"})
+		msgs := queued(t, clients[topic])
+		if len(msgs) != 1 {
+			t.Fatalf("%s: получено %d событий", topic, len(msgs))
+		}
+		if msgs[0].ID <= prev {
+			t.Errorf("%s: event_id %d не больше предыдущего %d", topic, msgs[0].ID, prev)
+		}
+		prev = msgs[0].ID
+	}
+}
+
+func TestHubPruneLogs(t *testing.T) {
+	hub := NewHub(WSConfig{})
+	expired := broadcastN(hub, "thread:1", 3)
+	broadcastN(hub, "thread:2", 1)
+	broadcastN(hub, "thread:3", 1)
+	hub.Subscribe(hub.newClient(nil, "test"), "thread:2", 0)
+
+	now := time.Now()
+	old := now.Add(-replayWindow - time.Second)
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.logs["thread:1"].updated = old
+	hub.logs["thread:2"].updated = old
+
+	// Не чаще раза в минуту
+	hub.pruneLogs(now)
+	if len(hub.logs) != 3 {
+		t.Fatalf("проверка раньше чем через минуту удалила буферы: %d", len(hub.logs))
+	}
+
+	hub.prunedAt = now.Add(-time.Minute)
+	hub.pruneLogs(now)
+	if hub.logs["thread:1"] != nil {
+		t.Error("устаревший буфер без подписчиков не удалён")
+	}
+	if hub.logs["thread:2"] == nil {
+		t.Error("удалён буфер темы с подписчиками")
+	}
+	if hub.logs["thread:3"] == nil {
+		t.Error("удалён свежий буфер")
+	}
+	if hub.prunedEventID != expired[2] {
+		t.Errorf("prunedEventID = %d, ожидалось %d", hub.prunedEventID, expired[2])
+	}
+}
+
+// seq ID от from до to включительно
+func seq(from, to uint64) []uint64 {
+	var ids []uint64
+	for id := from; id <= to; id++ {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"webForum/database"
+)
+
+const (
+	defaultSearchLimit = 50
+	snippetLength      = 200 // длина фрагмента в символах
+	snippetContext     = 40  // символов перед первым совпадением
+)
+
+// SearchResultResponse результат поиска для API
+type SearchResultResponse struct {
+	PostResponse
+	Subject string `json:"subject"`
+	BoardID string `json:"board_id"`
+	Snippet string `json:"snippet"` // HTML: экранированный текст, совпадения в <mark>
+	URL     string `json:"url"`
+}
+
+// searchItem результат поиска для HTML-страницы
+type searchItem struct {
+	database.SearchResult
+	Snippet template.HTML
+	URL     string
+}
+
+// parseSearchQuery разбирает параметры q, board, author и has_media
+func parseSearchQuery(r *http.Request) (database.SearchQuery, error) {
+	params := r.URL.Query()
+	q := database.SearchQuery{
+		Text:    strings.TrimSpace(params.Get("q")),
+		BoardID: strings.TrimSpace(params.Get("board")),
+		Author:  strings.TrimSpace(params.Get("author")),
+	}
+
+	if hasMedia := params.Get("has_media"); hasMedia != "" {
+		v, err := strconv.ParseBool(hasMedia)
+		if err != nil {
+			return q, fmt.Errorf("неверный has_media: %s", hasMedia)
+		}
+		q.HasMedia = &v
+	}
+	return q, nil
+}
+
+// postURL ссылка на пост внутри страницы треда
+func postURL(threadID, postID int) string {
+	return fmt.Sprintf("/thread/%d#post-%d", threadID, postID)
+}
+
+// highlightSnippet вырезает из текста фрагмент вокруг первого совпадения
+// и выделяет все вхождения слов запроса тегом <mark>.
+// Текст экранируется, поэтому результат безопасно вставлять в HTML.
+func highlightSnippet(text string, terms []string) string {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	// Длина совпадения, начинающегося с позиции i (0 - нет совпадения)
+	matchAt := func(i int) int {
+		best := 0
+		for _, t := range terms {
+			tr := []rune(t)
+			if len(tr) > best && i+len(tr) <= len(lower) && string(lower[i:i+len(tr)]) == t {
+				best = len(tr)
+			}
+		}
+		return best
+	}
+
+	start := 0
+	for i := range lower {
+		if matchAt(i) > 0 {
+			start = max(i-snippetContext, 0)
+			break
+		}
+	}
+	end := min(start+snippetLength, len(runes))
+	start = max(end-snippetLength, 0)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	plain := start
+	for i := start; i < end; {
+		n := matchAt(i)
+		if n == 0 {
+			i++
+			continue
+		}
+		n = min(n, end-i)
+		b.WriteString(template.HTMLEscapeString(string(runes[plain:i])))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(string(runes[i : i+n])))
+		b.WriteString("</mark>")
+		i += n
+		plain = i
+	}
+	b.WriteString(template.HTMLEscapeString(string(runes[plain:end])))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// SearchHandler - страница поиска по постам и темам тредов
+// GET /search?q=&board=&author=&has_media=
+func (h *Handler) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := parseSearchQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := parsePage(r, defaultSearchLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	boards, err := h.store.GetAllBoards()
+	if err != nil {
+		log.Printf("Ошибка получения досок: %v", err)
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+
+	results, pageInfo, err := h.store.Search(q, page)
+	if err != nil {
+		log.Printf("Ошибка поиска: %v", err)
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+
+	terms := database.SearchTerms(q.Text)
+	items := make([]searchItem, 0, len(results))
+	for _, res := range results {
+		items = append(items, searchItem{
+			SearchResult: res,
+			Snippet:      template.HTML(highlightSnippet(res.Content, terms)),
+			URL:          postURL(res.ThreadID, res.ID),
+		})
+	}
+
+	// Ссылки на соседние страницы сохраняют параметры запроса
+	pageURL := func(cursor string) template.URL {
+		if cursor == "" {
+			return ""
+		}
+		params := r.URL.Query()
+		params.Set("cursor", cursor)
+		return template.URL("/search?" + params.Encode())
+	}
+
+	hasMedia := ""
+	if q.HasMedia != nil {
+		hasMedia = strconv.FormatBool(*q.HasMedia)
+	}
+
+	data := map[string]interface{}{
+		"Title":    "Поиск",
+		"Query":    q,
+		"HasMedia": hasMedia,
+		"Searched": len(terms) > 0,
+		"Boards":   boards,
+		"Results":  items,
+		"NextURL":  pageURL(pageInfo.Next),
+		"PrevURL":  pageURL(pageInfo.Prev),
+	}
+
+	if err := h.templates.ExecuteTemplate(w, "search.html", data); err != nil {
+		log.Printf("Ошибка рендеринга: %v", err)
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+	}
+}
+
+// APISearch GET /api/v1/search?q=&board=&author=&has_media= - поиск
+func (h *Handler) APISearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" {
+		sendJSON(w, http.StatusOK, nil)
+		return
+	}
+
+	q, err := parseSearchQuery(r)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	terms := database.SearchTerms(q.Text)
+	if len(terms) == 0 {
+		sendError(w, http.StatusBadRequest, "Пустой поисковый запрос")
+		return
+	}
+
+	page, err := parsePage(r, defaultSearchLimit)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results, pageInfo, err := h.store.Search(q, page)
+	if err != nil {
+		log.Printf("API: ошибка поиска: %v", err)
+		sendError(w, http.StatusInternalServerError, "Ошибка поиска")
+		return
+	}
+
+	response := make([]SearchResultResponse, 0, len(results))
+	for i := range results {
+		res := &results[i]
+		response = append(response, SearchResultResponse{
+			PostResponse: newPostResponse(&res.Post),
+			Subject:      res.Subject,
+			BoardID:      res.BoardID,
+			Snippet:      highlightSnippet(res.Content, terms),
+			URL:          postURL(res.ThreadID, res.ID),
+		})
+	}
+
+	sendPage(w, response, pageInfo)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLen сколько байт из начала файла читается для определения типа
+const sniffLen = 512
+
+// uploadFormat допустимый формат загрузки
+type uploadFormat struct {
+	Type  string   // image, video или audio
+	MIMEs []string // MIME-типы содержимого, допустимые для расширения; первый - основной
+}
+
+// Допустимые типы файлов
+var allowedExtensions = map[string]uploadFormat{
+	// Изображения
+	".jpg":  {"image", []string{"image/jpeg"}},
+	".jpeg": {"image", []string{"image/jpeg"}},
+	".png":  {"image", []string{"image/png"}},
+	".gif":  {"image", []string{"image/gif"}},
+	".webp": {"image", []string{"image/webp"}},
+	".svg":  {"image", []string{"image/svg+xml"}},
+	// Видео
+	".mp4":  {"video", []string{"video/mp4"}},
+	".webm": {"video", []string{"video/webm"}},
+	".avi":  {"video", []string{"video/x-msvideo"}},
+	".mov":  {"video", []string{"video/quicktime", "video/mp4"}},
+	".mkv":  {"video", []string{"video/x-matroska", "video/webm"}},
+	// Аудио
+	".mp3":  {"audio", []string{"audio/mpeg"}},
+	".wav":  {"audio", []string{"audio/wav"}},
+	".ogg":  {"audio", []string{"audio/ogg"}},
+	".flac": {"audio", []string{"audio/flac"}},
+	".m4a":  {"audio", []string{"audio/mp4", "video/mp4"}},
+}
+
+// accepts проверяет, что содержимое с типом mime допустимо для формата
+func (f uploadFormat) accepts(mime string) bool {
+	for _, m := range f.MIMEs {
+		if m == mime {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffMIME определяет MIME-тип по сигнатуре (magic bytes) в начале файла.
+// Покрывает все форматы из allowedExtensions; остальное определяет
+// http.DetectContentType.
+func sniffMIME(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("\xFF\xD8\xFF")):
+		return "image/jpeg"
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1A\n")):
+		return "image/png"
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return "image/gif"
+	case riff(head, "WEBP"):
+		return "image/webp"
+	case riff(head, "AVI "):
+		return "video/x-msvideo"
+	case riff(head, "WAVE"):
+		return "audio/wav"
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		switch string(head[8:12]) {
+		case "qt  ":
+			return "video/quicktime"
+		case "M4A ", "M4B ":
+			return "audio/mp4"
+		}
+		return "video/mp4"
+	case bytes.HasPrefix(head, []byte("\x1A\x45\xDF\xA3")):
+		// EBML: WebM - подмножество Matroska с doctype "webm"
+		if bytes.Contains(head, []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	case bytes.HasPrefix(head, []byte("ID3")), len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0:
+		return "audio/mpeg"
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return "audio/ogg"
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return "audio/flac"
+	case looksLikeSVG(head):
+		return "image/svg+xml"
+	}
+
+	mime := http.DetectContentType(head)
+	if i := strings.IndexByte(mime, ';'); i >= 0 {
+		mime = mime[:i]
+	}
+	return mime
+}
+
+// riff проверяет RIFF-контейнер с указанным типом формы (WEBP, AVI, WAVE)
+func riff(head []byte, form string) bool {
+	return len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == form
+}
+
+// looksLikeSVG текстовый файл с корневым элементом <svg в начале.
+// Полная проверка структуры выполняется при санитизации.
+func looksLikeSVG(head []byte) bool {
+	// Последний символ мог обрезаться на границе sniffLen
+	for i := 0; i < utf8.UTFMax && len(head) > 0 && !utf8.Valid(head); i++ {
+		head = head[:len(head)-1]
+	}
+	if !utf8.Valid(head) || bytes.IndexByte(head, 0) >= 0 {
+		return false
+	}
+	return bytes.Contains(bytes.ToLower(head), []byte("<svg"))
+}
+
+// mimeForPath основной MIME-тип файла по расширению (для миниатюр и файлов
+// без записи media в /uploads/)
+func mimeForPath(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if f, ok := allowedExtensions[ext]; ok {
+		return f.MIMEs[0]
+	}
+	return "application/octet-stream"
+}
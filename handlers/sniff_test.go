@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"webForum/database"
+)
+
+func TestSniffMIME(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want string
+	}{
+		{"JPEG", "\xFF\xD8\xFF\xE0\x00\x10JFIF", "image/jpeg"},
+		{"PNG", "\x89PNG\r\n\x1A\n\x00\x00\x00\rIHDR", "image/png"},
+		{"GIF87a", "GIF87a\x01\x00", "image/gif"},
+		{"GIF89a", "GIF89a\x01\x00", "image/gif"},
+		{"WebP", "RIFF\x24\x00\x00\x00WEBPVP8 ", "image/webp"},
+		{"AVI", "RIFF\x24\x00\x00\x00AVI LIST", "video/x-msvideo"},
+		{"WAV", "RIFF\x24\x00\x00\x00WAVEfmt ", "audio/wav"},
+		{"MP4", "\x00\x00\x00\x20ftypisom\x00\x00\x02\x00", "video/mp4"},
+		{"QuickTime", "\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00", "video/quicktime"},
+		{"M4A", "\x00\x00\x00\x20ftypM4A \x00\x00\x00\x00", "audio/mp4"},
+		{"WebM", "\x1A\x45\xDF\xA3\x9F\x42\x86\x81\x01\x42\x82\x84webm", "video/webm"},
+		{"Matroska", "\x1A\x45\xDF\xA3\xA3\x42\x86\x81\x01\x42\x82\x88matroska", "video/x-matroska"},
+		{"MP3 с ID3", "ID3\x03\x00\x00\x00\x00\x00\x00", "audio/mpeg"},
+		{"MP3 без тегов", "\xFF\xFB\x90\x64\x00", "audio/mpeg"},
+		{"Ogg", "OggS\x00\x02", "audio/ogg"},
+		{"FLAC", "fLaC\x00\x00\x00\x22", "audio/flac"},
+		{"SVG", `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"/>`, "image/svg+xml"},
+		{"HTML", "<!DOCTYPE html><html><script>alert(1)</script></html>", "text/html"},
+		{"обрезанный RIFF", "RIFF\x24\x00", "application/octet-stream"},
+		{"пустой файл", "", "text/plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffMIME([]byte(tt.head)); got != tt.want {
+				t.Errorf("sniffMIME = %q, ожидалось %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// utf16LE текст s в UTF-16LE
+func utf16LE(s string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(r), byte(r>>8))
+	}
+	return b
+}
+
+func TestLooksLikeSVG(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg"><title>Привет</title></svg>`
+	cut := []byte(svg[:strings.Index(svg, "ривет")+1]) // первый байт «р»
+
+	tests := []struct {
+		name string
+		head []byte
+		want bool
+	}{
+		{"SVG", []byte(svg), true},
+		{"заглавные буквы", []byte(`<SVG WIDTH="1"></SVG>`), true},
+		{"после XML-декларации и комментария", []byte("<?xml version=\"1.0\"?>\n<!-- logo -->\n" + svg), true},
+		{"символ обрезан на границе sniffLen", cut, true},
+		{"Latin-1 внутри файла", []byte("<svg><title>caf\xe9 cr\xe8me</title></svg>"), false},
+		{"UTF-16", utf16LE(svg), false},
+		{"нулевой байт", []byte("<svg>\x00</svg>"), false},
+		{"HTML без svg", []byte("<html><body>svg</body></html>"), false},
+		{"пустой файл", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := looksLikeSVG(tt.head); got != tt.want {
+				t.Errorf("looksLikeSVG(%q) = %v, ожидалось %v", tt.head, got, tt.want)
+			}
+		})
+	}
+}
+
+// Расширению не доверяем: содержимое другого типа отклоняется
+func TestStoreUploadChecksContent(t *testing.T) {
+	h := newTestHandler(t)
+	settings := database.DefaultBoardSettings()
+
+	tests := []struct {
+		name     string
+		filename string
+		data     []byte
+		ok       bool
+	}{
+		{"PNG с расширением .png", "pic.png", testPNG(t), true},
+		{"PNG с расширением .jpg", "pic.jpg", testPNG(t), false},
+		{"HTML с расширением .png", "pic.png", []byte("<!DOCTYPE html><html><script>alert(1)</script></html>"), false},
+		{"HTML с расширением .svg", "pic.svg", []byte("<html><body>svg</body></html>"), false},
+		{"MP4 с расширением .mov", "clip.mov", []byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00isomiso2mp41"), true},
+		{"MP4 с расширением .mp3", "song.mp3", []byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00isomiso2mp41"), false},
+		{"пустой файл", "pic.png", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := h.storeUpload(bytes.NewReader(tt.data), tt.filename, settings)
+			if tt.ok && err != nil {
+				t.Fatalf("файл отклонён: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatalf("файл принят: %+v", m)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// sseRetry через сколько миллисекунд EventSource переподключается после
+// обрыва; столько же ждут шаблоны перед повторным подключением WebSocket
+const sseRetry = 3000
+
+// serveSSE отдаёт события темы потоком text/event-stream - для клиентов за
+// прокси, которые не пропускают WebSocket. Каждое событие - тот же JSON,
+// что и в WebSocket, с event_id в поле id:, поэтому EventSource при
+// переподключении сам присылает Last-Event-ID и получает пропущенные
+// события или resync_required. Раз в PingInterval уходит комментарий,
+// чтобы прокси не закрывали простаивающее соединение.
+func (h *Handler) serveSSE(w http.ResponseWriter, r *http.Request, topic string) {
+	lastEventID, ok := lastEventIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx: без буферизации ответа
+
+	client := h.hub.newClient(nil, r.RemoteAddr)
+	if err := h.hub.Subscribe(client, topic, lastEventID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer h.hub.Unregister(client)
+
+	// Каждая запись ограничена WriteTimeout, как в writePump
+	write := func(format string, args ...interface{}) error {
+		rc.SetWriteDeadline(time.Now().Add(client.config.WriteTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if err := write("retry: %d\n\n", sseRetry); err != nil {
+		log.Printf("SSE: поток не поддерживается: %v", err)
+		return
+	}
+
+	ticker := time.NewTicker(client.config.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data, ok := <-client.send:
+			if !ok {
+				return // клиент отключён хабом
+			}
+			if err := write("%sdata: %s\n\n", sseID(data), data); err != nil {
+				client.logEvicted("ошибка отправки: %v", err)
+				return
+			}
+		case <-ticker.C:
+			if err := write(": ping\n\n"); err != nil {
+				client.logEvicted("ping не отправлен: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// sseID строка id: с event_id сообщения или пустая строка для сообщений
+// без него (resync_required): EventSource сохраняет последний полученный ID
+func sseID(data []byte) string {
+	var msg struct {
+		ID uint64 `json:"event_id"`
+	}
+	if json.Unmarshal(data, &msg) != nil || msg.ID == 0 {
+		return ""
+	}
+	return "id: " + strconv.FormatUint(msg.ID, 10) + "\n"
+}
+
+// SSEThreadHandler поток событий треда: /sse/thread?thread_id={id}
+func (h *Handler) SSEThreadHandler(w http.ResponseWriter, r *http.Request) {
+	if topic, ok := threadTopicParam(w, r); ok {
+		h.serveSSE(w, r, topic)
+	}
+}
+
+// SSEBoardHandler поток событий доски: /sse/board?board_id={id}
+func (h *Handler) SSEBoardHandler(w http.ResponseWriter, r *http.Request) {
+	if topic, ok := boardTopicParam(w, r); ok {
+		h.serveSSE(w, r, topic)
+	}
+}
+
+// SSEHomeHandler поток событий главной страницы: /sse/home
+func (h *Handler) SSEHomeHandler(w http.ResponseWriter, r *http.Request) {
+	h.serveSSE(w, r, homeTopic)
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent событие потока text/event-stream
+type sseEvent struct {
+	id    string
+	retry string
+	data  string
+}
+
+// openSSE открывает поток url с заголовками header и читает первое
+// событие (retry:): к этому моменту клиент уже подписан
+func openSSE(ctx context.Context, t *testing.T, url string, header map[string]string) *bufio.Reader {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: код %d", url, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	if ev := readSSE(t, r); ev.retry != strconv.Itoa(sseRetry) {
+		t.Fatalf("первое событие %+v, ожидалось retry: %d", ev, sseRetry)
+	}
+	return r
+}
+
+// readSSE читает следующее событие потока, пропуская комментарии
+func readSSE(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	var ev sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("чтение потока: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if ev != (sseEvent{}) {
+				return ev
+			}
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "retry: "):
+			ev.retry = strings.TrimPrefix(line, "retry: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		default:
+			t.Fatalf("неожиданная строка потока: %q", line)
+		}
+	}
+}
+
+// readSSEMessage читает событие и проверяет, что id: совпадает с event_id
+func readSSEMessage(t *testing.T, r *bufio.Reader) WSMessage {
+	t.Helper()
+	ev := readSSE(t, r)
+	var msg WSMessage
+	if err := json.Unmarshal([]byte(ev.data), &msg); err != nil {
+		t.Fatalf("data не JSON: %q", ev.data)
+	}
+	want := ""
+	if msg.ID != 0 {
+		want = strconv.FormatUint(msg.ID, 10)
+	}
+	if ev.id != want {
+		t.Errorf("id: %q, event_id %d", ev.id, msg.ID)
+	}
+	return msg
+}
+
+func TestSSEStream(t *testing.T) {
+	h := newTestHandler(t)
+	srv := newWSServer(t, h)
+	ctx, cancel := context.WithTimeout(context.Background(), wsTestTimeout)
+	defer cancel()
+
+	r := openSSE(ctx, t, srv.URL+"/sse/thread?thread_id=1", nil)
+	h.hub.BroadcastToThread(2, WSMessage{Type: "new_post"})
+	h.hub.BroadcastToThread(1, WSMessage{Type: "new_post", ThreadID: 1})
+
+	msg := readSSEMessage(t, r)
+	if msg.Type != "new_post" || msg.Topic != "thread:1" || msg.ID != h.hub.LastEventID() {
+		t.Errorf("неожиданное событие: %+v", msg)
+	}
+}
+
+func TestSSEReplay(t *testing.T) {
+	h := newTestHandler(t)
+	srv := newWSServer(t, h)
+	ids := broadcastN(h.hub, "board:b", 3)
+
+	tests := []struct {
+		name   string
+		url    string
+		header map[string]string
+	}{
+		{"заголовок Last-Event-ID", "/sse/board?board_id=b", map[string]string{"Last-Event-ID": strconv.FormatUint(ids[0], 10)}},
+		{"параметр last_event_id", "/sse/board?board_id=b&last_event_id=" + strconv.FormatUint(ids[0], 10), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), wsTestTimeout)
+			defer cancel()
+
+			r := openSSE(ctx, t, srv.URL+tt.url, tt.header)
+			for _, want := range ids[1:] {
+				if msg := readSSEMessage(t, r); msg.ID != want || msg.Topic != "board:b" {
+					t.Errorf("событие %+v, ожидался event_id %d", msg, want)
+				}
+			}
+		})
+	}
+
+	// Восстановить события нельзя: resync_required без id:, чтобы
+	// EventSource не запомнил его как последнее событие
+	ctx, cancel := context.WithTimeout(context.Background(), wsTestTimeout)
+	defer cancel()
+	r := openSSE(ctx, t, srv.URL+"/sse/board?board_id=b", map[string]string{"Last-Event-ID": strconv.FormatUint(h.hub.LastEventID()+1, 10)})
+	if msg := readSSEMessage(t, r); msg.Type != "resync_required" {
+		t.Errorf("ожидался resync_required, получено %+v", msg)
+	}
+
+	w := httptest.NewRecorder()
+	h.SSEBoardHandler(w, httptest.NewRequest(http.MethodGet, "/sse/board?board_id=b&last_event_id=x", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("неверный last_event_id: код %d", w.Code)
+	}
+}
+
+func TestSSEDisconnect(t *testing.T) {
+	h := newTestHandler(t)
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		h.SSEHomeHandler(w, r)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	openSSE(ctx, t, srv.URL, nil)
+	if n := len(subscribers(h.hub, homeTopic)); n != 1 {
+		t.Fatalf("подписчиков: %d", n)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(wsTestTimeout):
+		t.Fatal("обработчик не завершился после отключения клиента")
+	}
+	if n := len(subscribers(h.hub, homeTopic)); n != 0 {
+		t.Errorf("отключившийся клиент остался подписан (%d)", n)
+	}
+}
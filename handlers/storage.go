@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"webForum/database"
+	"webForum/storage"
+)
+
+// Файлы лежат в MediaStore по SHA-256 содержимого: ключ ab/cd/abcd…ef.jpg,
+// поэтому одинаковые загрузки хранятся один раз. Публичный адрес файла -
+// /uploads/{ключ} независимо от бэкенда.
+const uploadsPrefix = "/uploads/"
+
+// tmpDir временные файлы загрузок на локальном диске узла: здесь файл
+// проверяется, хешируется и из него делается миниатюра до записи в хранилище
+var tmpDir = filepath.Join(os.TempDir(), "webforum-uploads")
+
+// contentKey ключ файла с хешем hash в хранилище
+func contentKey(hash, ext string) string {
+	return hash[:2] + "/" + hash[2:4] + "/" + hash + ext
+}
+
+// publicPath публичный путь /uploads/... для ключа хранилища
+func publicPath(key string) string {
+	return uploadsPrefix + key
+}
+
+// hashFromPath хеш содержимого из публичного пути файла
+// или пустая строка, если путь не из хранилища
+func hashFromPath(p string) string {
+	name := path.Base(p)
+	hash := strings.TrimSuffix(name, path.Ext(name))
+	if len(hash) != sha256.Size*2 || p != publicPath(contentKey(hash, path.Ext(name))) {
+		return ""
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return ""
+	}
+	return hash
+}
+
+// writeTemp пишет src во временный файл и считает SHA-256 содержимого
+func writeTemp(src io.Reader) (tmpPath, hash string, size int64, err error) {
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return "", "", 0, err
+	}
+	tmp, err := os.CreateTemp(tmpDir, "upload-*")
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	hasher := sha256.New()
+	size, err = io.Copy(io.MultiWriter(tmp, hasher), src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", "", 0, err
+	}
+	return tmp.Name(), hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+// putFile переносит временный файл в хранилище под ключом key
+func putFile(media storage.MediaStore, key, tmpPath, contentType string) error {
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return media.Put(key, f, info.Size(), contentType)
+}
+
+// mediaStored проверяет, что оригинал и миниатюра записи m есть в хранилище
+func (h *Handler) mediaStored(m database.Media) (bool, error) {
+	for _, p := range []string{m.Path, m.ThumbPath} {
+		if p == "" {
+			continue
+		}
+		obj, err := h.media.Open(strings.TrimPrefix(p, uploadsPrefix))
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		obj.Body.Close()
+	}
+	return true, nil
+}
+
+// discardMedia удаляет запись media и её файлы, если пост с ними так и не
+// был создан. Запись, на которую ссылается другой пост или действующий
+// токен (дубликат уже загруженного файла), остаётся вместе с файлами.
+func (h *Handler) discardMedia(m database.Media) {
+	if m.ID == 0 {
+		return
+	}
+	deleted, err := h.store.DeleteMedia(m.ID)
+	if err != nil {
+		log.Printf("Ошибка удаления медиафайла #%d: %v", m.ID, err)
+		return
+	}
+	if !deleted {
+		return
+	}
+	for _, p := range []string{m.Path, m.ThumbPath} {
+		if p == "" {
+			continue
+		}
+		key := strings.TrimPrefix(p, uploadsPrefix)
+		if err := h.media.Delete(key); err != nil {
+			log.Printf("Ошибка удаления файла %s: %v", key, err)
+		}
+	}
+}
+
+// svgCSP политика для SVG из /uploads/: открытый напрямую файл не
+// исполняет скрипты и ничего не загружает. Нужна и для SVG, загруженных до
+// появления санитайзера (у них нет записи media). Встроенные стили
+// разрешены: style= уже очищен при загрузке.
+const svgCSP = "sandbox; default-src 'none'; style-src 'unsafe-inline'"
+
+// uploadMIME Content-Type файла с ключом key: MIME-тип, определённый по
+// содержимому при загрузке и записанный в media. Для миниатюр и файлов без
+// записи - основной тип по расширению из allowedExtensions.
+func uploadMIME(store database.Store, key string) string {
+	hash := hashFromPath(publicPath(key))
+	if hash == "" {
+		return mimeForPath(key)
+	}
+	m, err := store.GetMediaByHash(hash)
+	if err != nil {
+		log.Printf("Ошибка получения медиафайла %s: %v", key, err)
+		return mimeForPath(key)
+	}
+	if m == nil || m.MIME == "" || m.Path != publicPath(key) {
+		return mimeForPath(key)
+	}
+	return m.MIME
+}
+
+// UploadsHandler раздаёт загруженные файлы из хранилища media. Если
+// хранилище выдаёт подписанные ссылки, клиент перенаправляется на них,
+// иначе файл проксируется через сервер. Content-Type - тип, записанный при
+// загрузке (тот же, с которым файл положен в хранилище), а не из системных
+// mime-таблиц, и браузеру запрещено угадывать тип самому. SVG отдаются
+// с svgCSP.
+func UploadsHandler(store database.Store, media storage.MediaStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Служебные файлы (недописанные, с точкой в начале имени) не отдаются
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		for _, part := range strings.Split(key, "/") {
+			if part == "" || strings.HasPrefix(part, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+
+		url, err := media.PresignGet(key)
+		if err != nil {
+			log.Printf("Ошибка подписи ссылки на %s: %v", key, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if url != "" {
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		}
+
+		obj, err := media.Open(key)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка чтения %s из хранилища: %v", key, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer obj.Body.Close()
+
+		// Содержимое по ключу-хешу не меняется
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		contentType := uploadMIME(store, key)
+		w.Header().Set("Content-Type", contentType)
+		if contentType == "image/svg+xml" {
+			w.Header().Set("Content-Security-Policy", svgCSP)
+		}
+
+		// Локальные файлы отдаются с поддержкой Range и If-Modified-Since
+		if rs, ok := obj.Body.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, key, obj.ModTime, rs)
+			return
+		}
+		if obj.Size >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+		}
+		if !obj.ModTime.IsZero() {
+			w.Header().Set("Last-Modified", obj.ModTime.UTC().Format(http.TimeFormat))
+		}
+		if r.Method == http.MethodHead {
+			return
+		}
+		io.Copy(w, obj.Body)
+	})
+}
@@ -0,0 +1,345 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxSVGSize наибольший размер SVG, который разбирается санитайзером
+const maxSVGSize = 5 << 20
+
+var errBadSVG = errors.New("не удалось обработать SVG-файл")
+
+// Пространства имён, в которых записывается очищенный SVG
+const (
+	svgNamespace   = "http://www.w3.org/2000/svg"
+	xlinkNamespace = "http://www.w3.org/1999/xlink"
+	xmlNamespace   = "http://www.w3.org/XML/1998/namespace"
+)
+
+// svgElements элементы SVG, которые сохраняются (имена в нижнем регистре).
+// Всё остальное удаляется вместе с содержимым: script, foreignObject,
+// style, a, image, feImage, элементы HTML и других пространств имён.
+var svgElements = map[string]bool{
+	"svg": true, "g": true, "defs": true, "symbol": true, "use": true,
+	"switch": true, "view": true, "title": true, "desc": true,
+	// Фигуры и текст
+	"path": true, "rect": true, "circle": true, "ellipse": true, "line": true,
+	"polyline": true, "polygon": true, "text": true, "tspan": true, "textpath": true,
+	// Заливки, обрезка, маркеры
+	"lineargradient": true, "radialgradient": true, "stop": true, "pattern": true,
+	"clippath": true, "mask": true, "marker": true,
+	// Фильтры
+	"filter": true, "feblend": true, "fecolormatrix": true, "fecomponenttransfer": true,
+	"fecomposite": true, "feconvolvematrix": true, "fediffuselighting": true,
+	"fedisplacementmap": true, "fedistantlight": true, "fedropshadow": true,
+	"feflood": true, "fefunca": true, "fefuncb": true, "fefuncg": true, "fefuncr": true,
+	"fegaussianblur": true, "femerge": true, "femergenode": true, "femorphology": true,
+	"feoffset": true, "fepointlight": true, "fespecularlighting": true,
+	"fespotlight": true, "fetile": true, "feturbulence": true,
+	// Анимация (кроме подмены ссылок, см. dropSVGElement)
+	"animate": true, "animatemotion": true, "animatetransform": true, "set": true, "mpath": true,
+}
+
+// svgAttributes атрибуты без пространства имён, которые сохраняются (имена
+// в нижнем регистре) помимо свойств оформления из svgStyleProperties.
+// href и style проверяются отдельно.
+var svgAttributes = map[string]bool{
+	"id": true, "class": true, "lang": true, "version": true, "baseprofile": true,
+	"x": true, "y": true, "x1": true, "y1": true, "x2": true, "y2": true,
+	"cx": true, "cy": true, "r": true, "rx": true, "ry": true, "fx": true, "fy": true, "fr": true,
+	"width": true, "height": true, "d": true, "points": true, "pathlength": true,
+	"viewbox": true, "preserveaspectratio": true,
+	"dx": true, "dy": true, "rotate": true, "textlength": true, "lengthadjust": true,
+	"startoffset": true, "method": true, "spacing": true, "side": true,
+	"offset": true, "gradientunits": true, "gradienttransform": true, "spreadmethod": true,
+	"patternunits": true, "patterncontentunits": true, "patterntransform": true,
+	"clippathunits": true, "maskunits": true, "maskcontentunits": true,
+	"markerwidth": true, "markerheight": true, "markerunits": true,
+	"refx": true, "refy": true, "orient": true,
+	// Фильтры
+	"filterunits": true, "primitiveunits": true, "in": true, "in2": true, "result": true,
+	"stddeviation": true, "mode": true, "operator": true, "k1": true, "k2": true, "k3": true, "k4": true,
+	"values": true, "type": true, "tablevalues": true, "slope": true, "intercept": true,
+	"amplitude": true, "exponent": true, "basefrequency": true, "numoctaves": true,
+	"seed": true, "stitchtiles": true, "scale": true, "xchannelselector": true,
+	"ychannelselector": true, "radius": true, "kernelmatrix": true, "order": true,
+	"divisor": true, "bias": true, "targetx": true, "targety": true, "edgemode": true,
+	"preservealpha": true, "surfacescale": true, "diffuseconstant": true,
+	"specularconstant": true, "specularexponent": true, "kernelunitlength": true,
+	"azimuth": true, "elevation": true, "pointsatx": true, "pointsaty": true,
+	"pointsatz": true, "limitingconeangle": true, "z": true,
+	// Анимация
+	"attributename": true, "attributetype": true, "begin": true, "dur": true, "end": true,
+	"from": true, "to": true, "by": true, "keytimes": true, "keysplines": true,
+	"keypoints": true, "calcmode": true, "repeatcount": true, "repeatdur": true,
+	"additive": true, "accumulate": true, "restart": true, "min": true, "max": true, "path": true,
+}
+
+// svgStyleProperties свойства, которые сохраняются в атрибуте style=.
+// Только оформление: остальные объявления отбрасываются.
+var svgStyleProperties = map[string]bool{
+	"fill":                        true,
+	"fill-opacity":                true,
+	"fill-rule":                   true,
+	"stroke":                      true,
+	"stroke-width":                true,
+	"stroke-opacity":              true,
+	"stroke-linecap":              true,
+	"stroke-linejoin":             true,
+	"stroke-dasharray":            true,
+	"stroke-dashoffset":           true,
+	"stroke-miterlimit":           true,
+	"opacity":                     true,
+	"color":                       true,
+	"display":                     true,
+	"visibility":                  true,
+	"overflow":                    true,
+	"font-family":                 true,
+	"font-size":                   true,
+	"font-style":                  true,
+	"font-weight":                 true,
+	"letter-spacing":              true,
+	"word-spacing":                true,
+	"text-anchor":                 true,
+	"text-decoration":             true,
+	"dominant-baseline":           true,
+	"stop-color":                  true,
+	"stop-opacity":                true,
+	"flood-color":                 true,
+	"flood-opacity":               true,
+	"lighting-color":              true,
+	"color-interpolation-filters": true,
+	"clip-path":                   true,
+	"clip-rule":                   true,
+	"mask":                        true,
+	"filter":                      true,
+	"marker-start":                true,
+	"marker-mid":                  true,
+	"marker-end":                  true,
+	"transform":                   true,
+	"transform-origin":            true,
+	"paint-order":                 true,
+	"vector-effect":               true,
+	"shape-rendering":             true,
+	"mix-blend-mode":              true,
+	"isolation":                   true,
+}
+
+// svgResourceFunctions функции CSS, которые загружают ресурс без url()
+var svgResourceFunctions = []string{"image-set(", "image(", "cross-fade(", "element(", "src("}
+
+// sanitizeSVG разбирает SVG и собирает его заново только из элементов
+// svgElements и атрибутов svgAttributes: без скриптов, foreignObject,
+// элементов <style>, HTML, обработчиков событий и ссылок на внешние ресурсы.
+// Элементы без пространства имён считаются элементами SVG: результат
+// записывается без префиксов, с xmlns SVG на корне, а элементы других
+// пространств имён удаляются вместе с содержимым.
+// Комментарии, DOCTYPE и инструкции обработки (кроме <?xml?>)
+// отбрасываются. Файл, который не является корректным XML с корнем
+// <svg>, отклоняется целиком.
+func sanitizeSVG(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSVGSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSVGSize {
+		return nil, fmt.Errorf("SVG-файл больше %d МБ", maxSVGSize>>20)
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = true
+
+	var out bytes.Buffer
+	depth := 0 // открытые элементы; вложенность проверяет декодер
+	skip := 0  // глубина внутри удаляемого элемента
+	rootSeen := false
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errBadSVG, err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			root := depth == 0
+			if root {
+				if rootSeen || !strings.EqualFold(t.Name.Local, "svg") || !inSVGNamespace(t.Name) {
+					return nil, fmt.Errorf("%w: корневой элемент должен быть <svg>", errBadSVG)
+				}
+				rootSeen = true
+			}
+			depth++
+
+			if skip > 0 || dropSVGElement(t) {
+				skip++
+				continue
+			}
+			out.WriteString("<" + t.Name.Local)
+			if root {
+				out.WriteString(` xmlns="` + svgNamespace + `" xmlns:xlink="` + xlinkNamespace + `"`)
+			}
+			for _, attr := range t.Attr {
+				name, value, ok := sanitizeSVGAttr(attr)
+				if !ok {
+					continue
+				}
+				out.WriteString(" " + name + `="`)
+				xml.EscapeText(&out, []byte(value))
+				out.WriteString(`"`)
+			}
+			out.WriteString(">")
+
+		case xml.EndElement:
+			depth--
+			if skip > 0 {
+				skip--
+				continue
+			}
+			out.WriteString("</" + t.Name.Local + ">")
+
+		case xml.CharData:
+			if depth == 0 {
+				if len(bytes.TrimSpace(t)) > 0 {
+					return nil, fmt.Errorf("%w: текст вне корневого элемента", errBadSVG)
+				}
+				continue
+			}
+			if skip > 0 {
+				continue
+			}
+			xml.EscapeText(&out, t)
+
+		case xml.ProcInst:
+			if t.Target == "xml" && out.Len() == 0 {
+				out.WriteString("<?xml " + string(t.Inst) + "?>")
+			}
+		}
+		// xml.Comment и xml.Directive (DOCTYPE, ENTITY) отбрасываются
+	}
+
+	if !rootSeen || depth != 0 {
+		return nil, fmt.Errorf("%w: документ оборван", errBadSVG)
+	}
+	return out.Bytes(), nil
+}
+
+// inSVGNamespace элемент SVG: в пространстве имён SVG или без него
+func inSVGNamespace(n xml.Name) bool {
+	return n.Space == svgNamespace || n.Space == ""
+}
+
+// dropSVGElement элементы, которые удаляются вместе с содержимым: не из
+// svgElements или не из пространства имён SVG, а также анимации,
+// подменяющие ссылки или обработчики событий
+// (<set attributeName="href" to="javascript:...">)
+func dropSVGElement(t xml.StartElement) bool {
+	local := strings.ToLower(t.Name.Local)
+	if !inSVGNamespace(t.Name) || !svgElements[local] {
+		return true
+	}
+	if local == "set" || strings.HasPrefix(local, "animate") {
+		for _, attr := range t.Attr {
+			if attr.Name.Local != "attributeName" {
+				continue
+			}
+			target := strings.ToLower(attr.Value)
+			if i := strings.IndexByte(target, ':'); i >= 0 {
+				target = target[i+1:]
+			}
+			if target == "href" || strings.HasPrefix(target, "on") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sanitizeSVGAttr имя и значение атрибута для записи; false - атрибут
+// отбрасывается. Сохраняются атрибуты из svgAttributes и
+// svgStyleProperties, href и xlink:href только на элементы того же
+// документа и xml:space. Объявления xmlns, xml:base, обработчики событий
+// и прочие атрибуты отбрасываются, как и значения со скриптами или
+// внешними ресурсами; из style= остаются только объявления из
+// svgStyleProperties.
+func sanitizeSVGAttr(attr xml.Attr) (string, string, bool) {
+	local := strings.ToLower(attr.Name.Local)
+	var name string
+	switch {
+	case attr.Name.Space == xlinkNamespace && local == "href":
+		name = "xlink:href"
+	case attr.Name.Space == xmlNamespace && local == "space":
+		return "xml:space", attr.Value, true
+	case attr.Name.Space != "":
+		return "", "", false
+	default:
+		name = attr.Name.Local
+	}
+
+	switch {
+	case local == "href":
+		return name, attr.Value, strings.HasPrefix(strings.TrimSpace(attr.Value), "#")
+	case local == "style":
+		value := sanitizeSVGStyleAttr(attr.Value)
+		return name, value, value != ""
+	case svgAttributes[local] || svgStyleProperties[local]:
+		return name, attr.Value, safeSVGValue(attr.Value)
+	}
+	return "", "", false
+}
+
+// sanitizeSVGStyleAttr оставляет в style= разрешённые свойства с
+// безопасными значениями
+func sanitizeSVGStyleAttr(style string) string {
+	var kept []string
+	for _, decl := range strings.Split(style, ";") {
+		name, value, ok := strings.Cut(decl, ":")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if !svgStyleProperties[name] || value == "" || !safeSVGValue(value) {
+			continue
+		}
+		kept = append(kept, name+": "+value)
+	}
+	return strings.Join(kept, "; ")
+}
+
+// safeSVGValue проверяет значение атрибута или свойства CSS: допускаются
+// только url() на элементы того же документа. Экранирование (u\72l(,
+// @\69mport), комментарии и at-правила отклоняются целиком, потому что
+// подстрокой их не проверить.
+func safeSVGValue(value string) bool {
+	v := strings.ToLower(value)
+	if strings.ContainsAny(v, `\@`) || strings.Contains(v, "/*") {
+		return false
+	}
+	if strings.Contains(v, "javascript:") || strings.Contains(v, "expression(") {
+		return false
+	}
+	for _, fn := range svgResourceFunctions {
+		if strings.Contains(v, fn) {
+			return false
+		}
+	}
+	for rest := v; ; {
+		i := strings.Index(rest, "url(")
+		if i < 0 {
+			return true
+		}
+		rest = strings.TrimLeft(rest[i+len("url("):], " \t\r\n'\"")
+		if !strings.HasPrefix(rest, "#") {
+			return false
+		}
+	}
+}
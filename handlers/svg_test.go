@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeSVG(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []string // подстроки, которые должны остаться
+		notWant []string // подстроки, которых быть не должно
+	}{
+		{
+			name: "обычный рисунок",
+			in:   `<svg xmlns="http://www.w3.org/2000/svg"><rect width="10" height="10" fill="red"/></svg>`,
+			want: []string{`<rect width="10" height="10" fill="red">`},
+		},
+		{
+			name:    "script и обработчики",
+			in:      `<svg onload="alert(1)"><script>alert(1)</script><circle r="1" onclick="alert(2)"/></svg>`,
+			want:    []string{`<circle r="1">`},
+			notWant: []string{"script", "alert", "onload", "onclick"},
+		},
+		{
+			name:    "элемент style удаляется",
+			in:      `<svg><style>rect { fill: red }</style><rect/></svg>`,
+			want:    []string{"<rect>"},
+			notWant: []string{"style", "fill"},
+		},
+		{
+			name:    "экранированный @import в style",
+			in:      `<svg><style>@\69mport "https://evil.example/x.css";</style></svg>`,
+			notWant: []string{"evil.example", "mport"},
+		},
+		{
+			name:    "style с префиксом",
+			in:      `<svg xmlns:s="http://www.w3.org/2000/svg"><s:style>@import "https://evil.example/x.css";</s:style></svg>`,
+			notWant: []string{"evil.example"},
+		},
+		{
+			name:    "image-set без url",
+			in:      `<svg><rect style="fill: red; background-image: image-set(&quot;https://evil.example/a.png&quot; 1x)"/></svg>`,
+			want:    []string{`style="fill: red"`},
+			notWant: []string{"evil.example", "image-set"},
+		},
+		{
+			name:    "image-set в атрибуте",
+			in:      `<svg><rect fill="image-set(&quot;https://evil.example/a.png&quot; 1x)"/></svg>`,
+			want:    []string{"<rect>"},
+			notWant: []string{"evil.example"},
+		},
+		{
+			name:    "image() и src()",
+			in:      `<svg><rect fill="image('https://evil.example/a.png')"/><rect stroke="src('https://evil.example/b.png')"/></svg>`,
+			notWant: []string{"evil.example"},
+		},
+		{
+			name:    "экранированный url в style=",
+			in:      `<svg><rect style="fill: u\72l(https://evil.example/a.png)"/></svg>`,
+			notWant: []string{"evil.example", "style="},
+		},
+		{
+			name:    "обратная косая без скобок",
+			in:      `<svg><rect style="fill: red; stroke: \62lue"/></svg>`,
+			want:    []string{`style="fill: red"`},
+			notWant: []string{`\`},
+		},
+		{
+			name:    "at-правило в style=",
+			in:      `<svg><rect style="fill: red; @import 'https://evil.example/x.css'"/></svg>`,
+			want:    []string{`style="fill: red"`},
+			notWant: []string{"evil.example", "@"},
+		},
+		{
+			name:    "комментарий между url и скобкой",
+			in:      `<svg><rect style="fill: url/**/(https://evil.example/a.png)"/></svg>`,
+			notWant: []string{"evil.example"},
+		},
+		{
+			name:    "неразрешённое свойство в style=",
+			in:      `<svg><rect style="fill: blue; background: url(https://evil.example/a.png); behavior: url(#x)"/></svg>`,
+			want:    []string{`style="fill: blue"`},
+			notWant: []string{"evil.example", "behavior", "background"},
+		},
+		{
+			name:    "внешний url в атрибуте",
+			in:      `<svg><rect fill="url(https://evil.example/a.png)"/></svg>`,
+			want:    []string{"<rect>"},
+			notWant: []string{"evil.example"},
+		},
+		{
+			name: "url на элемент документа",
+			in:   `<svg><linearGradient id="g"/><rect fill="url(#g)" style="stroke: url( '#g' )"/></svg>`,
+			want: []string{`fill="url(#g)"`, `style="stroke: url( &#39;#g&#39; )"`},
+		},
+		{
+			name:    "внешние ссылки href",
+			in:      `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use href="#a"/><use xlink:href="https://evil.example/x.svg#a"/><a href="javascript:alert(1)"/></svg>`,
+			want:    []string{`<use href="#a">`},
+			notWant: []string{"evil.example", "javascript"},
+		},
+		{
+			name:    "анимация подменяет ссылку",
+			in:      `<svg><a href="#x"><set attributeName="href" to="javascript:alert(1)"/></a></svg>`,
+			notWant: []string{"set", "javascript"},
+		},
+		{
+			name:    "XHTML в другом пространстве имён",
+			in:      `<svg xmlns="http://www.w3.org/2000/svg"><g xmlns="http://www.w3.org/1999/xhtml"><form action="https://evil.example"><button formaction="https://evil.example">x</button></form></g><rect/></svg>`,
+			want:    []string{"<rect>"},
+			notWant: []string{"form", "button", "evil.example", "1999/xhtml"},
+		},
+		{
+			name:    "XHTML с префиксом",
+			in:      `<svg xmlns:h="http://www.w3.org/1999/xhtml"><h:iframe src="https://evil.example"/><h:a href="https://evil.example">x</h:a></svg>`,
+			notWant: []string{"iframe", "evil.example", "<a"},
+		},
+		{
+			name:    "неизвестные элементы удаляются с содержимым",
+			in:      `<svg><a href="#x"><rect id="inside-a"/></a><image href="#i"/><feImage href="#i"/><form><rect id="inside-form"/></form><circle r="2"/></svg>`,
+			want:    []string{`<circle r="2">`},
+			notWant: []string{"<a", "image", "form", "inside-"},
+		},
+		{
+			name:    "неразрешённые атрибуты",
+			in:      `<svg xml:base="https://evil.example/"><rect action="https://evil.example" formaction="https://evil.example" data-x="1" xml:space="preserve" width="1"/></svg>`,
+			want:    []string{`<rect xml:space="preserve" width="1">`},
+			notWant: []string{"evil.example", "action", "data-x", "base"},
+		},
+		{
+			name: "префикс SVG и xlink",
+			in:   `<s:svg xmlns:s="http://www.w3.org/2000/svg" xmlns:l="http://www.w3.org/1999/xlink"><s:circle id="c" r="1"/><s:use l:href="#c"/></s:svg>`,
+			want: []string{`<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">`, `<circle id="c" r="1">`, `<use xlink:href="#c">`},
+		},
+		{
+			name:    "foreignObject",
+			in:      `<svg><foreignObject><iframe xmlns="http://www.w3.org/1999/xhtml" src="https://evil.example"/></foreignObject></svg>`,
+			notWant: []string{"foreignObject", "iframe", "evil.example"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := sanitizeSVG(strings.NewReader(tt.in))
+			if err != nil {
+				t.Fatalf("sanitizeSVG: %v", err)
+			}
+			got := string(out)
+
+			// Очищенный файл проходит санитайзер без изменений
+			again, err := sanitizeSVG(bytes.NewReader(out))
+			if err != nil || string(again) != got {
+				t.Errorf("повторная очистка изменила файл: %s -> %s (%v)", got, again, err)
+			}
+
+			for _, s := range tt.want {
+				if !strings.Contains(got, s) {
+					t.Errorf("нет %q в %s", s, got)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(got, s) {
+					t.Errorf("осталось %q в %s", s, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizeSVGRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"не XML", `<svg><rect></svg>`},
+		{"корень не svg", `<html><body/></html>`},
+		{"два корня", `<svg/><svg/>`},
+		{"текст вне корня", `<svg/>text`},
+		{"оборванный документ", `<svg><g>`},
+		{"пустой файл", ``},
+		{"корень из XHTML", `<svg xmlns="http://www.w3.org/1999/xhtml"><rect/></svg>`},
+		{"незакрытый тег с префиксом", `<svg xmlns:s="http://www.w3.org/2000/svg"><s:g></g></svg>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sanitizeSVG(strings.NewReader(tt.in))
+			if !errors.Is(err, errBadSVG) {
+				t.Errorf("ожидалась errBadSVG, получено %v", err)
+			}
+		})
+	}
+}
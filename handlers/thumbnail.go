@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // декодер GIF для image.Decode
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	thumbMaxSize   = 250        // наибольшая сторона миниатюры, px
+	thumbQuality   = 85         // качество JPEG-миниатюр
+	maxImagePixels = 50_000_000 // защита от «бомб» при декодировании
+)
+
+// errImageTooLarge картинка больше maxImagePixels: такой файл не
+// принимается, даже если его заголовок и данные в порядке
+var errImageTooLarge = errors.New("слишком большое изображение")
+
+// thumbnailExtensions форматы, для которых создаются миниатюры
+var thumbnailExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+// makeThumbnail создаёт миниатюру картинки из файла filePath: JPEG для
+// JPEG и PNG для PNG/GIF (сохраняется прозрачность, у GIF берётся первый
+// кадр). Возвращает закодированную миниатюру и её расширение или nil,
+// если картинка и так не больше thumbMaxSize. Ошибка декодирования
+// означает повреждённые данные картинки при корректном заголовке.
+func makeThumbnail(filePath string) ([]byte, string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("не удалось прочитать изображение: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, "", errImageTooLarge
+	}
+	if cfg.Width <= thumbMaxSize && cfg.Height <= thumbMaxSize {
+		return nil, "", nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("не удалось прочитать изображение: %w", err)
+	}
+
+	w, h := fitSize(cfg.Width, cfg.Height, thumbMaxSize)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbQuality})
+		return buf.Bytes(), ".jpg", err
+	}
+	err = png.Encode(&buf, dst)
+	return buf.Bytes(), ".png", err
+}
+
+// fitSize вписывает w×h в квадрат size×size с сохранением пропорций
+func fitSize(w, h, size int) (int, int) {
+	if w >= h {
+		return size, max(h*size/w, 1)
+	}
+	return max(w*size/h, 1), size
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"webForum/database"
+)
+
+// encodeImage картинка w×h в формате format (jpeg, png или gif)
+func encodeImage(t *testing.T, format string, w, h int) []byte {
+	t.Helper()
+	img := image.NewPaletted(image.Rect(0, 0, w, h), color.Palette{color.Black, color.White, color.Transparent})
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i % 3)
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeImage пишет data во временный файл и возвращает путь
+func writeImage(t *testing.T, data []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "image")
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestMakeThumbnail(t *testing.T) {
+	tests := []struct {
+		name           string
+		format         string
+		w, h           int
+		ext            string // "" - миниатюра не нужна
+		thumbW, thumbH int
+	}{
+		{"JPEG альбомная", "jpeg", 1000, 500, ".jpg", 250, 125},
+		{"PNG портретная", "png", 300, 600, ".png", 125, 250},
+		{"GIF квадратная", "gif", 500, 500, ".png", 250, 250},
+		{"узкая полоса", "png", 2000, 4, ".png", 250, 1},
+		{"меньше миниатюры", "jpeg", 100, 50, "", 0, 0},
+		{"ровно thumbMaxSize", "png", thumbMaxSize, thumbMaxSize, "", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thumb, ext, err := makeThumbnail(writeImage(t, encodeImage(t, tt.format, tt.w, tt.h)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ext != tt.ext {
+				t.Fatalf("расширение %q, ожидалось %q", ext, tt.ext)
+			}
+			if tt.ext == "" {
+				if thumb != nil {
+					t.Errorf("создана ненужная миниатюра (%d байт)", len(thumb))
+				}
+				return
+			}
+
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(thumb))
+			if err != nil {
+				t.Fatalf("миниатюра не декодируется: %v", err)
+			}
+			if "."+format != ext && !(format == "jpeg" && ext == ".jpg") {
+				t.Errorf("формат миниатюры %s, расширение %s", format, ext)
+			}
+			if cfg.Width != tt.thumbW || cfg.Height != tt.thumbH {
+				t.Errorf("размер %d×%d, ожидалось %d×%d", cfg.Width, cfg.Height, tt.thumbW, tt.thumbH)
+			}
+		})
+	}
+}
+
+func TestMakeThumbnailErrors(t *testing.T) {
+	full := encodeImage(t, "png", 600, 400)
+
+	// Логический экран GIF 20000×20000: заголовок без контрольной суммы
+	bomb := []byte("GIF89a\x20\x4E\x20\x4E\x00\x00\x00")
+
+	tests := []struct {
+		name     string
+		data     []byte
+		tooLarge bool
+	}{
+		{"обрезанные данные", full[:len(full)/2], false},
+		{"не картинка", []byte("не картинка"), false},
+		{"пустой файл", nil, false},
+		{"больше maxImagePixels", bomb, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thumb, _, err := makeThumbnail(writeImage(t, tt.data))
+			if err == nil || thumb != nil {
+				t.Fatalf("ожидалась ошибка, получено %d байт, %v", len(thumb), err)
+			}
+			if errors.Is(err, errImageTooLarge) != tt.tooLarge {
+				t.Errorf("ошибка %v, errImageTooLarge: %v", err, tt.tooLarge)
+			}
+		})
+	}
+}
+
+func TestFitSize(t *testing.T) {
+	tests := []struct {
+		w, h, size   int
+		wantW, wantH int
+	}{
+		{1000, 500, 250, 250, 125},
+		{500, 1000, 250, 125, 250},
+		{333, 333, 250, 250, 250},
+		{3000, 1, 250, 250, 1},
+		{1, 3000, 250, 1, 250},
+	}
+	for _, tt := range tests {
+		if w, h := fitSize(tt.w, tt.h, tt.size); w != tt.wantW || h != tt.wantH {
+			t.Errorf("fitSize(%d, %d, %d) = %d×%d, ожидалось %d×%d", tt.w, tt.h, tt.size, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+// Повреждённая картинка с верным заголовком не мешает создать пост:
+// файл сохраняется без миниатюры
+func TestCorruptImageWithoutThumbnail(t *testing.T) {
+	h := newTestHandler(t)
+	createTestBoard(t, h, "b", database.AllMediaTypes...)
+
+	// Заголовок и структура файла в порядке, данные картинки испорчены
+	jpegData := encodeImage(t, "jpeg", 600, 400)
+	pngData := encodeImage(t, "png", 600, 400)
+	gifData := encodeImage(t, "gif", 600, 400)
+	corrupt := map[string][]byte{
+		"jpeg": jpegData[:len(jpegData)*2/3],
+		// Блоки до первого IDAT, сразу за ними IEND: данных картинки нет
+		"png": append(append([]byte(nil), pngData[:bytes.Index(pngData, []byte("IDAT"))-4]...), pngData[len(pngData)-12:]...),
+		"gif": gifData[:len(gifData)*2/3],
+	}
+
+	for _, format := range []string{"jpeg", "png", "gif"} {
+		data := corrupt[format]
+		filename := "pic." + format
+
+		fields := map[string]string{"board_id": "b", "subject": format, "content": "текст"}
+		w := httptest.NewRecorder()
+		h.CreateThreadHandler(w, formRequest(t, "/create-thread", fields, filename, data))
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("%s: код %d: %s", format, w.Code, w.Body)
+		}
+
+		threads, err := h.store.GetThreadsByBoard("b", "new")
+		if err != nil || len(threads) == 0 {
+			t.Fatalf("%s: треды %v, %v", format, threads, err)
+		}
+		op, err := h.store.GetFirstPost(threads[0].ID)
+		if err != nil || op == nil || !op.MediaPath.Valid {
+			t.Fatalf("%s: первый пост %+v, %v", format, op, err)
+		}
+		if op.ThumbPath.Valid && op.ThumbPath.String != "" {
+			t.Errorf("%s: у повреждённой картинки миниатюра %s", format, op.ThumbPath.String)
+		}
+		if !storedKeys(t, h.media)[strings.TrimPrefix(op.MediaPath.String, uploadsPrefix)] {
+			t.Errorf("%s: файл %s не сохранён", format, op.MediaPath.String)
+		}
+	}
+}
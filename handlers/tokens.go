@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"webForum/database"
+)
+
+// uploadTokenTTL срок действия токена загрузки. Меньше MEDIA_GC_GRACE,
+// поэтому файл с действующим токеном сборщик не удалит.
+const uploadTokenTTL = time.Hour
+
+// hashUploadToken в БД хранится только SHA-256 токена
+func hashUploadToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueUploadToken выдаёт новый токен загрузки медиафайла mediaID
+func (h *Handler) issueUploadToken(mediaID int64) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	expiresAt := time.Now().Add(uploadTokenTTL).UTC()
+	if err := h.store.CreateUploadToken(hashUploadToken(token), mediaID, expiresAt); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// tokenMediaResponse ответ на загрузку файла с токеном, которым клиент
+// прикрепляет файл к треду или посту
+func tokenMediaResponse(m database.Media, token string, expiresAt time.Time) MediaResponse {
+	resp := newMediaResponse(m)
+	resp.Token = token
+	resp.TokenExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+	return resp
+}
+
+// uploadedMediaResponse ответ на загрузку файла с новым одноразовым токеном
+func (h *Handler) uploadedMediaResponse(m database.Media) (MediaResponse, error) {
+	token, expiresAt, err := h.issueUploadToken(m.ID)
+	if err != nil {
+		return MediaResponse{}, err
+	}
+	return tokenMediaResponse(m, token, expiresAt), nil
+}
+
+// isUploadTokenError ошибка токена, о которой сообщается клиенту с кодом 400
+func isUploadTokenError(err error) bool {
+	return errors.Is(err, database.ErrUploadTokenUnknown) || errors.Is(err, database.ErrUploadTokenUsed) ||
+		errors.Is(err, database.ErrUploadTokenExpired)
+}
+
+// mediaFromToken медиафайл по токену из /api/v1/upload. Пустой токен -
+// пост без файла. Неизвестный, использованный или истёкший токен
+// отклоняется с кодом 400. Файл проверяется по настройкам доски, куда
+// отправляется пост, а не той, для которой его загружали: тип не с этой
+// доски - 415, больше её лимита - 413. Здесь токен не расходуется: это
+// делает создание поста с возвращённым Media (Media.UploadToken) в той же
+// транзакции. Ответ клиенту уже отправлен, если ok = false.
+func (h *Handler) mediaFromToken(w http.ResponseWriter, token string, settings database.BoardSettings) (media database.Media, ok bool) {
+	if token == "" {
+		return database.Media{}, true
+	}
+	tokenHash := hashUploadToken(token)
+	m, err := h.store.GetUploadToken(tokenHash)
+	if isUploadTokenError(err) {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return database.Media{}, false
+	}
+	if err != nil {
+		log.Printf("API: ошибка проверки токена загрузки: %v", err)
+		sendError(w, http.StatusInternalServerError, "Ошибка проверки токена загрузки")
+		return database.Media{}, false
+	}
+	if err := checkStoredMedia(settings, *m); err != nil {
+		sendError(w, uploadErrorStatus(err), err.Error())
+		return database.Media{}, false
+	}
+	m.UploadToken = tokenHash
+	return *m, true
+}
@@ -0,0 +1,623 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"webForum/database"
+)
+
+// Возобновляемые загрузки совместимы с ядром протокола tus 1.0.0 и
+// расширениями creation, expiration и termination. Клиент создаёт сессию,
+// дописывает файл кусками (PATCH), после обрыва узнаёт смещение (HEAD или
+// GET) и продолжает с него, а затем вызывает finalize: файл проходит те же
+// проверки, что и обычная загрузка, и попадает в MediaStore.
+//
+// Сессия - два файла в каталоге сессий: {id} с данными и {id}.json с
+// описанием. Смещение - размер файла данных, поэтому оборванный PATCH не
+// теряет уже записанного. Каталог локальный, запросы одной сессии должны
+// приходить на один узел (или каталог должен быть общим).
+const (
+	tusVersion       = "1.0.0"
+	tusExtensions    = "creation,expiration,termination"
+	maxUploadSize    = 100 << 20      // предел сервера; max_file_size доски не больше него
+	uploadSessionTTL = 24 * time.Hour // сессия живёт сутки с последнего куска
+)
+
+// uploadSessionsDir каталог сессий возобновляемых загрузок
+var uploadSessionsDir = filepath.Join(tmpDir, "sessions")
+
+var (
+	errSessionNotFound = errors.New("сессия загрузки не найдена")
+	errSessionBusy     = errors.New("сессия загрузки занята другим запросом")
+)
+
+// uploadSession описание сессии загрузки ({id}.json)
+type uploadSession struct {
+	ID        string    `json:"id"`
+	Filename  string    `json:"filename"`
+	BoardID   string    `json:"board_id,omitempty"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	MediaHash string    `json:"media_hash,omitempty"` // заполняется после finalize
+
+	// Токен загрузки выдаётся один раз на сессию: повторный finalize
+	// возвращает его же, а не выпускает новый. Знать его может только
+	// владелец ID сессии, поэтому описание доступно лишь процессу форума.
+	Token          string    `json:"token,omitempty"`
+	TokenExpiresAt time.Time `json:"token_expires_at"`
+}
+
+// uploadSessions сессии загрузок в каталоге dir
+type uploadSessions struct {
+	dir string
+
+	mu   sync.Mutex
+	busy map[string]bool // сессии, в которые сейчас пишут
+}
+
+func newUploadSessions(dir string) *uploadSessions {
+	return &uploadSessions{dir: dir, busy: make(map[string]bool)}
+}
+
+func (s *uploadSessions) dataPath(id string) string { return filepath.Join(s.dir, id) }
+func (s *uploadSessions) infoPath(id string) string { return filepath.Join(s.dir, id+".json") }
+
+// validSessionID ID сессии - 32 шестнадцатеричных символа
+func validSessionID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// create создаёт сессию с пустым файлом данных
+func (s *uploadSessions) create(filename, boardID string, size int64) (*uploadSession, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	sess := &uploadSession{
+		ID:        hex.EncodeToString(buf),
+		Filename:  filename,
+		BoardID:   boardID,
+		Size:      size,
+		CreatedAt: now,
+		ExpiresAt: now.Add(uploadSessionTTL),
+	}
+	f, err := os.OpenFile(s.dataPath(sess.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	if err := s.save(sess); err != nil {
+		os.Remove(s.dataPath(sess.ID))
+		return nil, err
+	}
+	return sess, nil
+}
+
+// load читает сессию; истёкшая сессия удаляется и считается ненайденной
+func (s *uploadSessions) load(id string) (*uploadSession, error) {
+	if !validSessionID(id) {
+		return nil, errSessionNotFound
+	}
+	data, err := os.ReadFile(s.infoPath(id))
+	if os.IsNotExist(err) {
+		return nil, errSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sess uploadSession
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return nil, err
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		s.remove(id)
+		return nil, errSessionNotFound
+	}
+	return &sess, nil
+}
+
+// save записывает описание сессии через временный файл
+func (s *uploadSessions) save(sess *uploadSession) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	tmp := s.infoPath(sess.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.infoPath(sess.ID))
+}
+
+// offset сколько байт уже получено
+func (s *uploadSessions) offset(sess *uploadSession) (int64, error) {
+	if sess.MediaHash != "" {
+		return sess.Size, nil
+	}
+	info, err := os.Stat(s.dataPath(sess.ID))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// remove удаляет файлы сессии
+func (s *uploadSessions) remove(id string) {
+	os.Remove(s.dataPath(id))
+	os.Remove(s.infoPath(id))
+}
+
+// lock занимает сессию на время записи; параллельные PATCH в одну
+// сессию перемешали бы данные
+func (s *uploadSessions) lock(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busy[id] {
+		return false
+	}
+	s.busy[id] = true
+	return true
+}
+
+func (s *uploadSessions) unlock(id string) {
+	s.mu.Lock()
+	delete(s.busy, id)
+	s.mu.Unlock()
+}
+
+// expire удаляет истёкшие сессии и файлы данных без описания.
+// Возвращает число удалённых сессий.
+func (s *uploadSessions) expire(now time.Time) (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, e := range entries {
+		name := e.Name()
+		id := strings.TrimSuffix(name, ".json")
+		if !validSessionID(id) {
+			continue
+		}
+		if name == id {
+			// Файл данных: удаляется, только если описание потеряно
+			if _, err := os.Stat(s.infoPath(id)); !os.IsNotExist(err) {
+				continue
+			}
+			if info, err := e.Info(); err == nil && now.Sub(info.ModTime()) > uploadSessionTTL {
+				os.Remove(s.dataPath(id))
+			}
+			continue
+		}
+		if !s.lock(id) {
+			continue
+		}
+		data, err := os.ReadFile(s.infoPath(id))
+		var sess uploadSession
+		if err == nil && json.Unmarshal(data, &sess) == nil && now.After(sess.ExpiresAt) {
+			s.remove(id)
+			removed++
+		}
+		s.unlock(id)
+	}
+	return removed, nil
+}
+
+// ExpireUploadSessions раз в interval удаляет незавершённые загрузки,
+// в которые ничего не писали дольше uploadSessionTTL. Запускается
+// в отдельной горутине.
+func (h *Handler) ExpireUploadSessions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := h.uploads.expire(time.Now())
+		if err != nil {
+			log.Printf("Ошибка очистки сессий загрузки: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Удалено истёкших сессий загрузки: %d", n)
+		}
+	}
+}
+
+// UploadResponse состояние возобновляемой загрузки для API
+type UploadResponse struct {
+	ID        string         `json:"id"`
+	Filename  string         `json:"filename"`
+	BoardID   string         `json:"board_id,omitempty"`
+	Size      int64          `json:"size"`
+	Offset    int64          `json:"offset"`
+	Complete  bool           `json:"complete"` // все байты получены
+	ExpiresAt string         `json:"expires_at"`
+	Media     *MediaResponse `json:"media,omitempty"` // после finalize
+}
+
+// setTusHeaders общие заголовки ответов tus и CORS для них
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers",
+		"Content-Type, Authorization, Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset")
+	w.Header().Set("Access-Control-Expose-Headers",
+		"Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Expires")
+}
+
+// setSessionHeaders смещение, длина и срок жизни сессии в заголовках tus
+func setSessionHeaders(w http.ResponseWriter, sess *uploadSession, offset int64) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(sess.Size, 10))
+	w.Header().Set("Upload-Expires", sess.ExpiresAt.Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// APIUploads роутер возобновляемых загрузок:
+//
+//	POST   /api/v1/uploads               - создать сессию
+//	HEAD   /api/v1/uploads/{id}          - смещение (tus)
+//	GET    /api/v1/uploads/{id}          - состояние сессии
+//	PATCH  /api/v1/uploads/{id}          - дописать кусок
+//	DELETE /api/v1/uploads/{id}          - отменить загрузку
+//	POST   /api/v1/uploads/{id}/finalize - проверить и сохранить файл
+func (h *Handler) APIUploads(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+
+	if v := r.Header.Get("Tus-Resumable"); v != "" && v != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		sendError(w, http.StatusPreconditionFailed, "Неподдерживаемая версия tus: "+v)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/uploads"), "/")
+	id, action, _ := strings.Cut(path, "/")
+
+	switch {
+	case r.Method == "OPTIONS":
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.Itoa(maxUploadSize))
+		w.WriteHeader(http.StatusNoContent)
+	case id == "" && r.Method == http.MethodPost:
+		h.apiCreateUpload(w, r)
+	case id != "" && action == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.apiUploadStatus(w, r, id)
+	case id != "" && action == "" && r.Method == http.MethodPatch:
+		h.apiAppendUpload(w, r, id)
+	case id != "" && action == "" && r.Method == http.MethodDelete:
+		h.apiDeleteUpload(w, id)
+	case id != "" && action == "finalize" && r.Method == http.MethodPost:
+		h.apiFinalizeUpload(w, id)
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Метод не поддерживается")
+	}
+}
+
+// parseUploadMetadata разбирает заголовок tus Upload-Metadata:
+// пары «ключ base64(значение)» через запятую
+func parseUploadMetadata(header string) map[string]string {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		meta[key] = string(decoded)
+	}
+	return meta
+}
+
+// apiCreateUpload POST /api/v1/uploads - создать сессию. Параметры
+// принимаются в заголовках tus (Upload-Length, Upload-Metadata с ключами
+// filename и board_id) или JSON: {"filename", "size", "board_id"}.
+func (h *Handler) apiCreateUpload(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Filename string `json:"filename"`
+		Size     int64  `json:"size"`
+		BoardID  string `json:"board_id"`
+	}
+
+	if length := r.Header.Get("Upload-Length"); length != "" {
+		size, err := strconv.ParseInt(length, 10, 64)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Неверный Upload-Length")
+			return
+		}
+		meta := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+		req.Filename, req.Size, req.BoardID = meta["filename"], size, meta["board_id"]
+	} else if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Неверный формат данных")
+		return
+	}
+
+	req.Filename = filepath.Base(strings.TrimSpace(req.Filename))
+	if req.Size <= 0 {
+		sendError(w, http.StatusBadRequest, "Размер файла обязателен")
+		return
+	}
+	// Политика доски проверяется до приёма данных, а в finalize - ещё раз
+	settings, ok := h.uploadBoardSettings(w, req.BoardID)
+	if !ok {
+		return
+	}
+	ext := strings.ToLower(filepath.Ext(req.Filename))
+	if err := checkMediaPolicy(settings, ext, req.Size); err != nil {
+		sendError(w, uploadErrorStatus(err), err.Error())
+		return
+	}
+	if req.Size > maxUploadSize {
+		sendError(w, http.StatusRequestEntityTooLarge, "Файл слишком большой")
+		return
+	}
+
+	sess, err := h.uploads.create(req.Filename, req.BoardID, req.Size)
+	if err != nil {
+		log.Printf("API: ошибка создания сессии загрузки: %v", err)
+		sendError(w, http.StatusInternalServerError, "Ошибка создания загрузки")
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/uploads/"+sess.ID)
+	setSessionHeaders(w, sess, 0)
+	sendJSON(w, http.StatusCreated, APIResponse{Success: true, Data: h.uploadResponse(sess, 0)})
+}
+
+// uploadResponse состояние сессии; после finalize - с медиафайлом
+func (h *Handler) uploadResponse(sess *uploadSession, offset int64) UploadResponse {
+	resp := UploadResponse{
+		ID:        sess.ID,
+		Filename:  sess.Filename,
+		BoardID:   sess.BoardID,
+		Size:      sess.Size,
+		Offset:    offset,
+		Complete:  offset == sess.Size,
+		ExpiresAt: sess.ExpiresAt.Format(time.RFC3339),
+	}
+	if sess.MediaHash != "" {
+		if m, err := h.store.GetMediaByHash(sess.MediaHash); err == nil && m != nil {
+			media := newMediaResponse(*m)
+			resp.Media = &media
+		}
+	}
+	return resp
+}
+
+// loadUpload сессия по ID с ответом клиенту при ошибке
+func (h *Handler) loadUpload(w http.ResponseWriter, id string) (*uploadSession, bool) {
+	sess, err := h.uploads.load(id)
+	if errors.Is(err, errSessionNotFound) {
+		sendError(w, http.StatusNotFound, "Загрузка не найдена или истекла")
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("API: ошибка чтения сессии загрузки %s: %v", id, err)
+		sendError(w, http.StatusInternalServerError, "Ошибка чтения загрузки")
+		return nil, false
+	}
+	return sess, true
+}
+
+// apiUploadStatus HEAD - смещение в заголовках (tus), GET - JSON
+func (h *Handler) apiUploadStatus(w http.ResponseWriter, r *http.Request, id string) {
+	sess, ok := h.loadUpload(w, id)
+	if !ok {
+		return
+	}
+	offset, err := h.uploads.offset(sess)
+	if err != nil {
+		log.Printf("API: ошибка чтения сессии загрузки %s: %v", id, err)
+		sendError(w, http.StatusInternalServerError, "Ошибка чтения загрузки")
+		return
+	}
+
+	setSessionHeaders(w, sess, offset)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	sendSuccess(w, h.uploadResponse(sess, offset))
+}
+
+// apiAppendUpload PATCH /api/v1/uploads/{id} - дописать кусок. Тело -
+// байты файла (Content-Type: application/offset+octet-stream), заголовок
+// Upload-Offset должен совпадать с текущим смещением сессии.
+func (h *Handler) apiAppendUpload(w http.ResponseWriter, r *http.Request, id string) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/offset+octet-stream" {
+		sendError(w, http.StatusUnsupportedMediaType, "Ожидается Content-Type: application/offset+octet-stream")
+		return
+	}
+	clientOffset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || clientOffset < 0 {
+		sendError(w, http.StatusBadRequest, "Неверный Upload-Offset")
+		return
+	}
+
+	if !h.uploads.lock(id) {
+		sendError(w, http.StatusLocked, errSessionBusy.Error())
+		return
+	}
+	defer h.uploads.unlock(id)
+
+	sess, ok := h.loadUpload(w, id)
+	if !ok {
+		return
+	}
+	offset, err := h.uploads.offset(sess)
+	if err != nil {
+		log.Printf("API: ошибка чтения сессии загрузки %s: %v", id, err)
+		sendError(w, http.StatusInternalServerError, "Ошибка чтения загрузки")
+		return
+	}
+	if clientOffset != offset {
+		setSessionHeaders(w, sess, offset)
+		sendError(w, http.StatusConflict, fmt.Sprintf("Смещение %d не совпадает с полученным: %d", clientOffset, offset))
+		return
+	}
+	if sess.MediaHash != "" {
+		sendError(w, http.StatusConflict, "Загрузка уже завершена")
+		return
+	}
+
+	f, err := os.OpenFile(h.uploads.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("API: ошибка открытия сессии загрузки %s: %v", id, err)
+		sendError(w, http.StatusInternalServerError, "Ошибка записи загрузки")
+		return
+	}
+	// Всё, что успело прийти до обрыва, остаётся в файле: клиент
+	// продолжит с нового смещения
+	written, copyErr := io.Copy(f, io.LimitReader(r.Body, sess.Size-offset))
+	if err := f.Close(); copyErr == nil {
+		copyErr = err
+	}
+	offset += written
+
+	sess.ExpiresAt = time.Now().UTC().Add(uploadSessionTTL)
+	if err := h.uploads.save(sess); err != nil {
+		log.Printf("API: ошибка сохранения сессии загрузки %s: %v", id, err)
+	}
+	setSessionHeaders(w, sess, offset)
+
+	if copyErr != nil {
+		sendError(w, http.StatusBadRequest, "Передача прервана")
+		return
+	}
+	if offset == sess.Size {
+		// Данные сверх Upload-Length не принимаются
+		if n, _ := r.Body.Read(make([]byte, 1)); n > 0 {
+			sendError(w, http.StatusRequestEntityTooLarge, "Данных больше, чем заявлено в Upload-Length")
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiDeleteUpload DELETE /api/v1/uploads/{id} - отменить загрузку
+func (h *Handler) apiDeleteUpload(w http.ResponseWriter, id string) {
+	if !h.uploads.lock(id) {
+		sendError(w, http.StatusLocked, errSessionBusy.Error())
+		return
+	}
+	defer h.uploads.unlock(id)
+
+	if _, ok := h.loadUpload(w, id); !ok {
+		return
+	}
+	h.uploads.remove(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiFinalizeUpload POST /api/v1/uploads/{id}/finalize - проверить
+// полученный файл так же, как обычную загрузку, и сохранить его в
+// хранилище. Повторный вызов возвращает тот же медиафайл и тот же токен.
+func (h *Handler) apiFinalizeUpload(w http.ResponseWriter, id string) {
+	if !h.uploads.lock(id) {
+		sendError(w, http.StatusLocked, errSessionBusy.Error())
+		return
+	}
+	defer h.uploads.unlock(id)
+
+	sess, ok := h.loadUpload(w, id)
+	if !ok {
+		return
+	}
+	if sess.MediaHash != "" {
+		m, err := h.store.GetMediaByHash(sess.MediaHash)
+		if err != nil || m == nil {
+			sendError(w, http.StatusNotFound, "Медиафайл не найден")
+			return
+		}
+		h.sendUploadedMedia(w, sess, *m)
+		return
+	}
+
+	offset, err := h.uploads.offset(sess)
+	if err != nil {
+		log.Printf("API: ошибка чтения сессии загрузки %s: %v", id, err)
+		sendError(w, http.StatusInternalServerError, "Ошибка чтения загрузки")
+		return
+	}
+	if offset != sess.Size {
+		setSessionHeaders(w, sess, offset)
+		sendError(w, http.StatusConflict, fmt.Sprintf("Загрузка не завершена: получено %d из %d байт", offset, sess.Size))
+		return
+	}
+
+	f, err := os.Open(h.uploads.dataPath(id))
+	if err != nil {
+		log.Printf("API: ошибка открытия сессии загрузки %s: %v", id, err)
+		sendError(w, http.StatusInternalServerError, "Ошибка чтения загрузки")
+		return
+	}
+	media, err := h.storeUpload(f, sess.Filename, h.boardSettings(sess.BoardID))
+	f.Close()
+	if err != nil {
+		sendError(w, uploadErrorStatus(err), err.Error())
+		return
+	}
+
+	// Данные больше не нужны; описание остаётся до истечения срока,
+	// чтобы повторный finalize вернул тот же файл
+	os.Remove(h.uploads.dataPath(id))
+	sess.MediaHash = media.Hash
+	if err := h.uploads.save(sess); err != nil {
+		log.Printf("API: ошибка сохранения сессии загрузки %s: %v", id, err)
+	}
+
+	h.sendUploadedMedia(w, sess, media)
+}
+
+// sendUploadedMedia ответ finalize: медиафайл с токеном загрузки сессии.
+// Токен выпускается при первом finalize и сохраняется в сессии; если ответ
+// с ним потерялся, повторный finalize вернёт тот же токен.
+func (h *Handler) sendUploadedMedia(w http.ResponseWriter, sess *uploadSession, m database.Media) {
+	if sess.Token == "" {
+		token, expiresAt, err := h.issueUploadToken(m.ID)
+		if err != nil {
+			log.Printf("API: ошибка выдачи токена загрузки: %v", err)
+			sendError(w, http.StatusInternalServerError, "Ошибка загрузки файла")
+			return
+		}
+		sess.Token, sess.TokenExpiresAt = token, expiresAt
+		if err := h.uploads.save(sess); err != nil {
+			// Несохранённый токен не отдаётся: следующий finalize выпустит
+			// новый, и у клиента не окажется двух
+			log.Printf("API: ошибка сохранения сессии загрузки %s: %v", sess.ID, err)
+			sendError(w, http.StatusInternalServerError, "Ошибка загрузки файла")
+			return
+		}
+	}
+	sendSuccess(w, tokenMediaResponse(m, sess.Token, sess.TokenExpiresAt))
+}
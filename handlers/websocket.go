@@ -2,10 +2,15 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -21,219 +26,494 @@ var upgrader = websocket.Upgrader{
 
 // Сообщение для отправки клиентам
 type WSMessage struct {
-	Type     string      `json:"type"` // "new_post", "new_thread", "new_board"
+	Type     string      `json:"type"`               // "new_post", "new_thread", "new_board"
+	ID       uint64      `json:"event_id,omitempty"` // номер события, растёт монотонно
+	Topic    string      `json:"topic,omitempty"`    // тема, по которой пришло событие
 	ThreadID int         `json:"thread_id,omitempty"`
 	BoardID  string      `json:"board_id,omitempty"`
 	Data     interface{} `json:"data,omitempty"`
 }
 
-// Hub управляет всеми WebSocket соединениями
-type Hub struct {
-	// Клиенты по тредам: thread_id -> []*websocket.Conn
-	threadClients map[int]map[*websocket.Conn]bool
-	// Клиенты по доскам: board_id -> []*websocket.Conn
-	boardClients map[string]map[*websocket.Conn]bool
-	// Клиенты главной страницы
-	homeClients map[*websocket.Conn]bool
-	// Мьютекс для безопасного доступа
-	mu sync.RWMutex
+// Темы подписки: события треда, доски и главной страницы
+const homeTopic = "home"
+
+// ThreadTopic тема событий треда: thread:{id}
+func ThreadTopic(threadID int) string { return "thread:" + strconv.Itoa(threadID) }
+
+// BoardTopic тема событий доски: board:{id}
+func BoardTopic(boardID string) string { return "board:" + boardID }
+
+// validTopic проверяет формат темы: home, thread:{число}, board:{a-z0-9}
+func validTopic(topic string) bool {
+	if topic == homeTopic {
+		return true
+	}
+	if id, ok := strings.CutPrefix(topic, "thread:"); ok {
+		n, err := strconv.Atoi(id)
+		return err == nil && n > 0 && strconv.Itoa(n) == id
+	}
+	if id, ok := strings.CutPrefix(topic, "board:"); ok && id != "" && len(id) <= 50 {
+		for _, c := range id {
+			if !((c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
 }
 
-// Глобальный хаб
-var WsHub = &Hub{
-	threadClients: make(map[int]map[*websocket.Conn]bool),
-	boardClients:  make(map[string]map[*websocket.Conn]bool),
-	homeClients:   make(map[*websocket.Conn]bool),
+// WSConfig таймауты WebSocket-соединений
+type WSConfig struct {
+	PingInterval   time.Duration // как часто сервер отправляет ping
+	PongTimeout    time.Duration // сколько ждать любого кадра от клиента (pong продлевает)
+	WriteTimeout   time.Duration // предел записи одного сообщения
+	MaxMessageSize int64         // максимальный размер сообщения от клиента, байт
 }
 
-// RegisterThreadClient регистрирует клиента для треда
-func (h *Hub) RegisterThreadClient(threadID int, conn *websocket.Conn) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+// DefaultWSConfig таймауты по умолчанию
+func DefaultWSConfig() WSConfig {
+	return WSConfig{
+		PingInterval:   30 * time.Second,
+		PongTimeout:    60 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxMessageSize: 4096,
+	}
+}
 
-	if h.threadClients[threadID] == nil {
-		h.threadClients[threadID] = make(map[*websocket.Conn]bool)
+// normalize заменяет нулевые значения на значения по умолчанию. Ping
+// должен уходить чаще, чем истекает ожидание pong, иначе живые клиенты
+// будут отключаться.
+func (c WSConfig) normalize() WSConfig {
+	def := DefaultWSConfig()
+	if c.PingInterval <= 0 {
+		c.PingInterval = def.PingInterval
 	}
-	h.threadClients[threadID][conn] = true
-	log.Printf("WebSocket: клиент подключился к треду #%d (всего: %d)", threadID, len(h.threadClients[threadID]))
+	if c.PongTimeout <= 0 {
+		c.PongTimeout = def.PongTimeout
+	}
+	if c.WriteTimeout <= 0 {
+		c.WriteTimeout = def.WriteTimeout
+	}
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = def.MaxMessageSize
+	}
+	if c.PingInterval >= c.PongTimeout {
+		log.Printf("WebSocket: интервал ping (%s) не меньше ожидания pong (%s), используется %s",
+			c.PingInterval, c.PongTimeout, c.PongTimeout*9/10)
+		c.PingInterval = c.PongTimeout * 9 / 10
+	}
+	return c
 }
 
-// UnregisterThreadClient удаляет клиента из треда
-func (h *Hub) UnregisterThreadClient(threadID int, conn *websocket.Conn) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+// clientSendBuffer сколько сообщений может ждать отправки клиенту.
+// Клиент, у которого очередь заполнена, не успевает читать и отключается.
+const clientSendBuffer = 64
+
+// Client подписчик хаба с собственной очередью отправки: WebSocket-
+// соединение или поток SSE (conn == nil). Писать в conn может только
+// writePump: gorilla/websocket не допускает параллельной записи в одно
+// соединение.
+type Client struct {
+	conn   *websocket.Conn
+	addr   string // адрес клиента для логов
+	send   chan []byte
+	config WSConfig
+	topics map[string]bool // подписки; защищены Hub.mu
+	closed bool            // очередь закрыта; защищено Hub.mu
+}
 
-	if h.threadClients[threadID] != nil {
-		delete(h.threadClients[threadID], conn)
-		log.Printf("WebSocket: клиент отключился от треда #%d (осталось: %d)", threadID, len(h.threadClients[threadID]))
+// newClient создаёт клиента WebSocket-соединения conn или, при conn == nil,
+// потока SSE; writePump и readLoop запускает вызывающий
+func (h *Hub) newClient(conn *websocket.Conn, addr string) *Client {
+	return &Client{
+		conn:   conn,
+		addr:   addr,
+		send:   make(chan []byte, clientSendBuffer),
+		config: h.config,
+		topics: make(map[string]bool),
 	}
 }
 
-// RegisterBoardClient регистрирует клиента для доски
-func (h *Hub) RegisterBoardClient(boardID string, conn *websocket.Conn) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+// transport название канала клиента для логов
+func (c *Client) transport() string {
+	if c.conn == nil {
+		return "SSE"
+	}
+	return "WebSocket"
+}
 
-	if h.boardClients[boardID] == nil {
-		h.boardClients[boardID] = make(map[*websocket.Conn]bool)
+// enqueue ставит сообщение в очередь без ожидания.
+// false - очередь заполнена.
+func (c *Client) enqueue(data []byte) bool {
+	select {
+	case c.send <- data:
+		return true
+	default:
+		return false
 	}
-	h.boardClients[boardID][conn] = true
-	log.Printf("WebSocket: клиент подключился к доске /%s/ (всего: %d)", boardID, len(h.boardClients[boardID]))
 }
 
-// UnregisterBoardClient удаляет клиента из доски
-func (h *Hub) UnregisterBoardClient(boardID string, conn *websocket.Conn) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+// close закрывает очередь; writePump отправит оставшиеся сообщения и
+// закроет соединение. Вызывается хабом под h.mu.Lock, а запись в очередь -
+// под h.mu.RLock с проверкой closed, поэтому в закрытую очередь никто не
+// пишет.
+func (c *Client) close() {
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+// writePump отправляет сообщения из очереди до её закрытия и раз в
+// PingInterval - ping. Каждая запись ограничена WriteTimeout: клиент,
+// который не принимает данные, не держит горутину вечно.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(c.config.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				c.logEvicted("ошибка отправки: %v", err)
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.logEvicted("ping не отправлен: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// readLoop читает управляющие сообщения клиента до ошибки чтения, затем
+// отключает его от хаба. Любой кадр от клиента, в том числе pong,
+// продлевает ожидание на PongTimeout; клиент, от которого ничего не
+// пришло дольше, считается отключившимся (полуоткрытое соединение).
+func (c *Client) readLoop(hub *Hub) {
+	defer hub.Unregister(c)
+
+	c.conn.SetReadLimit(c.config.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.config.PongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.config.PongTimeout))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.logReadError(err)
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(c.config.PongTimeout))
+		hub.handleControl(c, data)
+	}
+}
+
+// logReadError записывает в лог причину отключения клиента. Обычное
+// закрытие соединения клиентом не логируется.
+func (c *Client) logReadError(err error) {
+	var netErr net.Error
+	switch {
+	case websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived):
+	case errors.Is(err, net.ErrClosed):
+		// Соединение закрыл сервер (writePump или отключение медленного клиента)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		c.logEvicted("нет ответа на ping дольше %s", c.config.PongTimeout)
+	case errors.Is(err, websocket.ErrReadLimit):
+		c.logEvicted("сообщение больше %d байт", c.config.MaxMessageSize)
+	default:
+		c.logEvicted("ошибка чтения: %v", err)
+	}
+}
+
+// logEvicted записывает в лог, почему клиент отключён
+func (c *Client) logEvicted(format string, args ...interface{}) {
+	log.Printf("%s: клиент %s отключён: %s", c.transport(), c.addr, fmt.Sprintf(format, args...))
+}
+
+// maxClientTopics сколько тем может слушать одно соединение
+const maxClientTopics = 100
 
-	if h.boardClients[boardID] != nil {
-		delete(h.boardClients[boardID], conn)
-		log.Printf("WebSocket: клиент отключился от доски /%s/ (осталось: %d)", boardID, len(h.boardClients[boardID]))
+// wsControl управляющее сообщение клиента:
+// {"action": "subscribe", "topics": ["thread:123", "board:b", "home"], "last_event_id": 42}
+type wsControl struct {
+	Action      string   `json:"action"` // subscribe или unsubscribe
+	Topics      []string `json:"topics"`
+	LastEventID uint64   `json:"last_event_id"` // для subscribe: прислать события после него
+}
+
+// Hub управляет всеми WebSocket соединениями и рассылает события по темам
+type Hub struct {
+	// Подписчики по темам: тема -> клиенты
+	topics map[string]map[*Client]bool
+	// Последние события тем для переподключившихся клиентов
+	logs map[string]*topicLog
+	// ID последнего события. Начинается с времени запуска в микросекундах,
+	// поэтому растёт и между перезапусками сервера.
+	lastEventID uint64
+	// События с ID не больше этого удалены вместе с буферами тем
+	prunedEventID uint64
+	prunedAt      time.Time
+	// Мьютекс для безопасного доступа
+	mu sync.RWMutex
+	// Таймауты соединений
+	config WSConfig
+}
+
+// NewHub создаёт пустой хаб с таймаутами config
+func NewHub(config WSConfig) *Hub {
+	start := uint64(time.Now().UnixMicro())
+	return &Hub{
+		topics:        make(map[string]map[*Client]bool),
+		logs:          make(map[string]*topicLog),
+		lastEventID:   start,
+		prunedEventID: start,
+		prunedAt:      time.Now(),
+		config:        config.normalize(),
 	}
 }
 
-// RegisterHomeClient регистрирует клиента для главной страницы
-func (h *Hub) RegisterHomeClient(conn *websocket.Conn) {
+// Subscribe подписывает клиента на тему. Если lastEventID не 0, клиент
+// сначала получает события темы после него или resync_required.
+func (h *Hub) Subscribe(client *Client, topic string, lastEventID uint64) error {
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	if client.closed || client.topics[topic] {
+		h.mu.Unlock()
+		return nil // отключённого клиента не подписываем: в его очередь писать нельзя
+	}
+	if len(client.topics) >= maxClientTopics {
+		h.mu.Unlock()
+		return fmt.Errorf("не больше %d подписок на соединение", maxClientTopics)
+	}
+	if h.topics[topic] == nil {
+		h.topics[topic] = make(map[*Client]bool)
+	}
+	h.topics[topic][client] = true
+	client.topics[topic] = true
+	log.Printf("%s: клиент подписался на %s (всего: %d)", client.transport(), topic, len(h.topics[topic]))
+
+	ok := lastEventID == 0 || h.replay(client, topic, lastEventID)
+	h.mu.Unlock()
 
-	h.homeClients[conn] = true
-	log.Printf("WebSocket: клиент подключился к главной (всего: %d)", len(h.homeClients))
+	if !ok {
+		h.dropSlow([]*Client{client}, "пропущенные события "+topic)
+	}
+	return nil
 }
 
-// UnregisterHomeClient удаляет клиента с главной страницы
-func (h *Hub) UnregisterHomeClient(conn *websocket.Conn) {
+// Unsubscribe отписывает клиента от темы
+func (h *Hub) Unsubscribe(client *Client, topic string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	delete(h.homeClients, conn)
-	log.Printf("WebSocket: клиент отключился от главной (осталось: %d)", len(h.homeClients))
+	h.unsubscribe(client, topic)
 }
 
-// BroadcastToHome отправляет сообщение всем клиентам главной страницы
-func (h *Hub) BroadcastToHome(msg WSMessage) {
-	h.mu.RLock()
-	clients := make(map[*websocket.Conn]bool)
-	for conn := range h.homeClients {
-		clients[conn] = true
+// unsubscribe отписывает клиента от темы; вызывается под h.mu
+func (h *Hub) unsubscribe(client *Client, topic string) {
+	if !client.topics[topic] {
+		return
 	}
-	h.mu.RUnlock()
-
+	delete(client.topics, topic)
+	clients := h.topics[topic]
+	delete(clients, client)
 	if len(clients) == 0 {
-		return
+		delete(h.topics, topic)
 	}
+	log.Printf("%s: клиент отписался от %s (осталось: %d)", client.transport(), topic, len(clients))
+}
 
-	data, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("WebSocket: ошибка сериализации: %v", err)
+// Unregister отписывает клиента от всех тем и закрывает его очередь
+func (h *Hub) Unregister(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for topic := range client.topics {
+		h.unsubscribe(client, topic)
+	}
+	client.close()
+}
+
+// handleControl выполняет управляющее сообщение клиента и отвечает
+// subscribed/unsubscribed со списком тем или error
+func (h *Hub) handleControl(client *Client, data []byte) {
+	var ctl wsControl
+	if err := json.Unmarshal(data, &ctl); err != nil {
+		h.reply(client, WSMessage{Type: "error", Data: map[string]string{"error": "неверный формат сообщения"}})
 		return
 	}
+	for _, topic := range ctl.Topics {
+		if !validTopic(topic) {
+			h.reply(client, WSMessage{Type: "error", Data: map[string]string{"error": "неизвестная тема: " + topic}})
+			return
+		}
+	}
 
-	for conn := range clients {
-		err := conn.WriteMessage(websocket.TextMessage, data)
-		if err != nil {
-			log.Printf("WebSocket: ошибка отправки: %v", err)
-			conn.Close()
-			h.UnregisterHomeClient(conn)
+	switch ctl.Action {
+	case "subscribe":
+		for _, topic := range ctl.Topics {
+			if err := h.Subscribe(client, topic, ctl.LastEventID); err != nil {
+				h.reply(client, WSMessage{Type: "error", Data: map[string]string{"error": err.Error()}})
+				return
+			}
+		}
+		h.reply(client, WSMessage{Type: "subscribed", Data: map[string][]string{"topics": ctl.Topics}})
+	case "unsubscribe":
+		for _, topic := range ctl.Topics {
+			h.Unsubscribe(client, topic)
 		}
+		h.reply(client, WSMessage{Type: "unsubscribed", Data: map[string][]string{"topics": ctl.Topics}})
+	default:
+		h.reply(client, WSMessage{Type: "error", Data: map[string]string{"error": "неизвестное действие: " + ctl.Action}})
 	}
 }
 
-// BroadcastToThread отправляет сообщение всем клиентам треда
-func (h *Hub) BroadcastToThread(threadID int, msg WSMessage) {
+// reply отправляет ответ одному клиенту. Под h.mu.RLock, чтобы не писать
+// в очередь, которую закрывает Unregister.
+func (h *Hub) reply(client *Client, msg WSMessage) {
+	data, ok := encodeWSMessage(msg)
+	if !ok {
+		return
+	}
+
 	h.mu.RLock()
-	clients := h.threadClients[threadID]
+	slow := !client.closed && !client.enqueue(data)
 	h.mu.RUnlock()
 
-	if clients == nil {
-		return
+	if slow {
+		h.dropSlow([]*Client{client}, "ответы")
 	}
+}
 
+// encodeWSMessage сериализует сообщение один раз для всех получателей
+func encodeWSMessage(msg WSMessage) ([]byte, bool) {
 	data, err := json.Marshal(msg)
 	if err != nil {
 		log.Printf("WebSocket: ошибка сериализации: %v", err)
-		return
+		return nil, false
 	}
+	return data, true
+}
 
-	for conn := range clients {
-		err := conn.WriteMessage(websocket.TextMessage, data)
-		if err != nil {
-			log.Printf("WebSocket: ошибка отправки: %v", err)
-			conn.Close()
-			h.UnregisterThreadClient(threadID, conn)
+// dropSlow отключает клиентов, не успевающих читать сообщения.
+// WebSocket-соединение закрывается сразу, чтобы прервать зависшую запись
+// в writePump; поток SSE завершается по закрытию очереди, его запись
+// ограничена WriteTimeout.
+func (h *Hub) dropSlow(slow []*Client, what string) {
+	for _, client := range slow {
+		client.logEvicted("не успевает получать %s", what)
+		h.Unregister(client)
+		if client.conn != nil {
+			client.conn.Close()
+		}
+	}
+}
+
+// Broadcast присваивает сообщению следующий ID события, сохраняет его в
+// буфер темы и отправляет всем подписчикам темы. Сообщение ставится в
+// очереди клиентов без ожидания, поэтому медленный клиент не задерживает
+// обработчик, создавший пост; клиент с заполненной очередью отключается.
+// Всё под h.mu.Lock: клиенты получают события в порядке ID.
+func (h *Hub) Broadcast(topic string, msg WSMessage) {
+	msg.Topic = topic
+
+	var slow []*Client
+	h.mu.Lock()
+	data, ok := h.record(topic, msg)
+	if ok {
+		for client := range h.topics[topic] {
+			if !client.enqueue(data) {
+				slow = append(slow, client)
+			}
 		}
 	}
+	h.mu.Unlock()
+
+	h.dropSlow(slow, "события "+topic)
+}
+
+// BroadcastToHome отправляет сообщение всем клиентам главной страницы
+func (h *Hub) BroadcastToHome(msg WSMessage) {
+	h.Broadcast(homeTopic, msg)
+}
+
+// BroadcastToThread отправляет сообщение всем клиентам треда
+func (h *Hub) BroadcastToThread(threadID int, msg WSMessage) {
+	h.Broadcast(ThreadTopic(threadID), msg)
 }
 
 // BroadcastToBoard отправляет сообщение всем клиентам доски
 func (h *Hub) BroadcastToBoard(boardID string, msg WSMessage) {
-	h.mu.RLock()
-	clients := h.boardClients[boardID]
-	h.mu.RUnlock()
+	h.Broadcast(BoardTopic(boardID), msg)
+}
 
-	if clients == nil {
-		return
+// lastEventIDParam ID последнего полученного события из заголовка
+// Last-Event-ID (его шлёт EventSource при переподключении) или из
+// ?last_event_id=; 0 - не передан. При неверном значении отвечает 400.
+func lastEventIDParam(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("last_event_id")
 	}
-
-	data, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("WebSocket: ошибка сериализации: %v", err)
-		return
+	if s == "" {
+		return 0, true
 	}
-
-	for conn := range clients {
-		err := conn.WriteMessage(websocket.TextMessage, data)
-		if err != nil {
-			log.Printf("WebSocket: ошибка отправки: %v", err)
-			conn.Close()
-			h.UnregisterBoardClient(boardID, conn)
-		}
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid last_event_id", http.StatusBadRequest)
+		return 0, false
 	}
+	return id, true
 }
 
-// WebSocketThreadHandler обрабатывает WebSocket соединения для треда
-func WebSocketThreadHandler(w http.ResponseWriter, r *http.Request) {
+// threadTopicParam тема треда из ?thread_id=. При ошибке отвечает 400.
+func threadTopicParam(w http.ResponseWriter, r *http.Request) (string, bool) {
 	threadIDStr := r.URL.Query().Get("thread_id")
 	if threadIDStr == "" {
 		http.Error(w, "thread_id required", http.StatusBadRequest)
-		return
+		return "", false
 	}
 
 	threadID, err := strconv.Atoi(threadIDStr)
-	if err != nil {
+	if err != nil || threadID <= 0 {
 		http.Error(w, "invalid thread_id", http.StatusBadRequest)
-		return
-	}
-
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
-		return
+		return "", false
 	}
-
-	WsHub.RegisterThreadClient(threadID, conn)
-
-	// Читаем сообщения (для поддержания соединения)
-	go func() {
-		defer func() {
-			WsHub.UnregisterThreadClient(threadID, conn)
-			conn.Close()
-		}()
-
-		for {
-			_, _, err := conn.ReadMessage()
-			if err != nil {
-				break
-			}
-		}
-	}()
+	return ThreadTopic(threadID), true
 }
 
-// WebSocketBoardHandler обрабатывает WebSocket соединения для доски
-func WebSocketBoardHandler(w http.ResponseWriter, r *http.Request) {
+// boardTopicParam тема доски из ?board_id=. При ошибке отвечает 400.
+func boardTopicParam(w http.ResponseWriter, r *http.Request) (string, bool) {
 	boardID := r.URL.Query().Get("board_id")
 	if boardID == "" {
 		http.Error(w, "board_id required", http.StatusBadRequest)
+		return "", false
+	}
+	if !validTopic(BoardTopic(boardID)) {
+		http.Error(w, "invalid board_id", http.StatusBadRequest)
+		return "", false
+	}
+	return BoardTopic(boardID), true
+}
+
+// serveWS принимает WebSocket-соединение и подписывает его на topics.
+// С ?last_event_id= переподключившийся клиент получает пропущенные
+// события. Дальнейшие подписки клиент меняет управляющими сообщениями.
+func (h *Handler) serveWS(w http.ResponseWriter, r *http.Request, topics ...string) {
+	lastEventID, ok := lastEventIDParam(w, r)
+	if !ok {
 		return
 	}
 
@@ -243,46 +523,55 @@ func WebSocketBoardHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	WsHub.RegisterBoardClient(boardID, conn)
+	client := h.hub.newClient(conn, conn.RemoteAddr().String())
+	for _, topic := range topics {
+		h.hub.Subscribe(client, topic, lastEventID)
+	}
 
-	// Читаем сообщения (для поддержания соединения)
-	go func() {
-		defer func() {
-			WsHub.UnregisterBoardClient(boardID, conn)
-			conn.Close()
-		}()
+	go client.writePump()
+	go client.readLoop(h.hub)
+}
 
-		for {
-			_, _, err := conn.ReadMessage()
-			if err != nil {
-				break
+// WebSocketHandler - единое WebSocket-соединение для любых тем:
+// /ws?topics=thread:1,board:b&last_event_id=42 (необязательно), затем
+// сообщения {"action": "subscribe"|"unsubscribe", "topics": [...]}
+func (h *Handler) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	var topics []string
+	if list := r.URL.Query().Get("topics"); list != "" {
+		topics = strings.Split(list, ",")
+		if len(topics) > maxClientTopics {
+			http.Error(w, "too many topics", http.StatusBadRequest)
+			return
+		}
+		for _, topic := range topics {
+			if !validTopic(topic) {
+				http.Error(w, "invalid topic: "+topic, http.StatusBadRequest)
+				return
 			}
 		}
-	}()
+	}
+
+	h.serveWS(w, r, topics...)
 }
 
-// WebSocketHomeHandler обрабатывает WebSocket соединения для главной страницы
-func WebSocketHomeHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
-		return
+// WebSocketThreadHandler обрабатывает WebSocket соединения для треда.
+// Совместимость: то же, что /ws?topics=thread:{id}.
+func (h *Handler) WebSocketThreadHandler(w http.ResponseWriter, r *http.Request) {
+	if topic, ok := threadTopicParam(w, r); ok {
+		h.serveWS(w, r, topic)
 	}
+}
 
-	WsHub.RegisterHomeClient(conn)
-
-	// Читаем сообщения (для поддержания соединения)
-	go func() {
-		defer func() {
-			WsHub.UnregisterHomeClient(conn)
-			conn.Close()
-		}()
+// WebSocketBoardHandler обрабатывает WebSocket соединения для доски.
+// Совместимость: то же, что /ws?topics=board:{id}.
+func (h *Handler) WebSocketBoardHandler(w http.ResponseWriter, r *http.Request) {
+	if topic, ok := boardTopicParam(w, r); ok {
+		h.serveWS(w, r, topic)
+	}
+}
 
-		for {
-			_, _, err := conn.ReadMessage()
-			if err != nil {
-				break
-			}
-		}
-	}()
+// WebSocketHomeHandler обрабатывает WebSocket соединения для главной страницы.
+// Совместимость: то же, что /ws?topics=home.
+func (h *Handler) WebSocketHomeHandler(w http.ResponseWriter, r *http.Request) {
+	h.serveWS(w, r, homeTopic)
 }
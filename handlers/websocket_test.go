@@ -0,0 +1,467 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsTestTimeout предел ожидания сообщения в тестах: тест с ошибкой
+// падает, а не зависает
+const wsTestTimeout = 5 * time.Second
+
+// newWSServer тестовый сервер с маршрутами /ws и /sse как в main.go
+func newWSServer(t *testing.T, h *Handler) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", h.WebSocketHandler)
+	mux.HandleFunc("/ws/thread", h.WebSocketThreadHandler)
+	mux.HandleFunc("/ws/board", h.WebSocketBoardHandler)
+	mux.HandleFunc("/ws/home", h.WebSocketHomeHandler)
+	mux.HandleFunc("/sse/thread", h.SSEThreadHandler)
+	mux.HandleFunc("/sse/board", h.SSEBoardHandler)
+	mux.HandleFunc("/sse/home", h.SSEHomeHandler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dialWS подключается к WebSocket-адресу path тестового сервера
+func dialWS(t *testing.T, srv *httptest.Server, path string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + path
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("подключение к %s: %v", path, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readWS читает следующее сообщение сервера
+func readWS(t *testing.T, conn *websocket.Conn) WSMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(wsTestTimeout))
+	var msg WSMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("чтение сообщения: %v", err)
+	}
+	return msg
+}
+
+// sendControl отправляет управляющее сообщение и возвращает ответ на него.
+// Ответ приходит после подписок, сделанных при подключении, поэтому
+// sendControl(t, conn, "subscribe") без тем дожидается их.
+func sendControl(t *testing.T, conn *websocket.Conn, action string, topics ...string) WSMessage {
+	t.Helper()
+	if topics == nil {
+		topics = []string{}
+	}
+	if err := conn.WriteJSON(wsControl{Action: action, Topics: topics}); err != nil {
+		t.Fatalf("отправка %s: %v", action, err)
+	}
+	return readWS(t, conn)
+}
+
+// subscribers клиенты хаба, подписанные на тему
+func subscribers(hub *Hub, topic string) []*Client {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	var clients []*Client
+	for c := range hub.topics[topic] {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// eventIndex номер события из Data тестовой рассылки
+func eventIndex(t *testing.T, msg WSMessage) int {
+	t.Helper()
+	data, ok := msg.Data.(map[string]interface{})
+	if !ok {
+		t.Fatalf("неожиданное сообщение: %+v", msg)
+	}
+	return int(data["i"].(float64))
+}
+
+func TestHubConcurrentBroadcast(t *testing.T) {
+	h := newTestHandler(t)
+	conn := dialWS(t, newWSServer(t, h), "/ws")
+	if reply := sendControl(t, conn, "subscribe", "thread:1"); reply.Type != "subscribed" {
+		t.Fatalf("ответ на подписку: %+v", reply)
+	}
+
+	// Все события помещаются в очередь клиента, даже если он ещё не читал
+	const senders, perSender = 8, 7
+	var wg sync.WaitGroup
+	for g := 0; g < senders; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perSender; i++ {
+				h.hub.BroadcastToThread(1, WSMessage{Type: "new_post", Data: map[string]int{"i": g*perSender + i}})
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	seen := make(map[int]bool)
+	var lastID uint64
+	for n := 0; n < senders*perSender; n++ {
+		msg := readWS(t, conn)
+		if msg.Type != "new_post" || msg.Topic != "thread:1" {
+			t.Fatalf("неожиданное сообщение: %+v", msg)
+		}
+		if msg.ID <= lastID {
+			t.Errorf("event_id %d после %d: события не по порядку", msg.ID, lastID)
+		}
+		lastID = msg.ID
+		seen[eventIndex(t, msg)] = true
+	}
+	if len(seen) != senders*perSender {
+		t.Errorf("получено %d разных событий из %d", len(seen), senders*perSender)
+	}
+}
+
+func TestHubDropsClientThatDoesNotRead(t *testing.T) {
+	h := newTestHandler(t)
+	srv := newWSServer(t, h)
+	slow := dialWS(t, srv, "/ws")
+	if reply := sendControl(t, slow, "subscribe", "thread:1"); reply.Type != "subscribed" {
+		t.Fatalf("ответ на подписку: %+v", reply)
+	}
+	fast := dialWS(t, srv, "/ws")
+	if reply := sendControl(t, fast, "subscribe", "thread:2"); reply.Type != "subscribed" {
+		t.Fatalf("ответ на подписку: %+v", reply)
+	}
+
+	// slow больше не читает: когда заполнятся буферы сокета и очередь из
+	// clientSendBuffer сообщений, хаб должен его отключить, не дожидаясь
+	payload := strings.Repeat("x", 64<<10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for n := 0; len(subscribers(h.hub, "thread:1")) > 0 && n < 10000; n++ {
+			h.hub.BroadcastToThread(1, WSMessage{Type: "new_post", Data: payload})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(wsTestTimeout):
+		t.Fatal("Broadcast заблокирован клиентом, который не читает")
+	}
+	if n := len(subscribers(h.hub, "thread:1")); n != 0 {
+		t.Fatalf("клиент, который не читает, не отключён (подписчиков: %d)", n)
+	}
+
+	// Остальные клиенты продолжают получать события
+	h.hub.BroadcastToThread(2, WSMessage{Type: "new_post", Data: map[string]int{"i": 1}})
+	if msg := readWS(t, fast); msg.Topic != "thread:2" {
+		t.Errorf("неожиданное сообщение: %+v", msg)
+	}
+}
+
+func TestHubUnregisterClosesQueueOnce(t *testing.T) {
+	h := newTestHandler(t)
+	conn := dialWS(t, newWSServer(t, h), "/ws")
+	if reply := sendControl(t, conn, "subscribe", "thread:1", "home"); reply.Type != "subscribed" {
+		t.Fatalf("ответ на подписку: %+v", reply)
+	}
+	clients := subscribers(h.hub, "thread:1")
+	if len(clients) != 1 {
+		t.Fatalf("подписчиков: %d", len(clients))
+	}
+	client := clients[0]
+
+	// Повторное закрытие канала вызвало бы панику; параллельные рассылки
+	// не должны писать в закрытую очередь (проверяется под -race)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			h.hub.Unregister(client)
+		}()
+		go func(i int) {
+			defer wg.Done()
+			h.hub.BroadcastToThread(1, WSMessage{Type: "new_post", Data: map[string]int{"i": i}})
+		}(i)
+	}
+	wg.Wait()
+
+	if !client.closed {
+		t.Error("очередь клиента не закрыта")
+	}
+	if n := len(subscribers(h.hub, "thread:1")) + len(subscribers(h.hub, homeTopic)); n != 0 {
+		t.Errorf("клиент остался подписан (%d)", n)
+	}
+	// Отключённого клиента нельзя подписать снова: в его очередь писать нельзя
+	if err := h.hub.Subscribe(client, "thread:1", 0); err != nil || len(subscribers(h.hub, "thread:1")) != 0 {
+		t.Errorf("отключённый клиент подписан снова: %v", err)
+	}
+
+	// writePump отправляет оставшиеся события и закрывает соединение
+	for {
+		conn.SetReadDeadline(time.Now().Add(wsTestTimeout))
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			t.Errorf("соединение закрыто не штатно: %v", err)
+		}
+		break
+	}
+}
+
+// newShortWSHandler обработчики с короткими таймаутами WebSocket
+func newShortWSHandler(t *testing.T) *Handler {
+	t.Helper()
+	h := newTestHandler(t)
+	h.hub = NewHub(WSConfig{
+		PingInterval:   20 * time.Millisecond,
+		PongTimeout:    60 * time.Millisecond,
+		WriteTimeout:   time.Second,
+		MaxMessageSize: 256,
+	})
+	return h
+}
+
+func TestWSEvictsPeerWithoutPong(t *testing.T) {
+	h := newShortWSHandler(t)
+	conn := dialWS(t, newWSServer(t, h), "/ws?topics=thread:1")
+	if reply := sendControl(t, conn, "subscribe"); reply.Type != "subscribed" {
+		t.Fatalf("ответ на подписку: %+v", reply)
+	}
+
+	// Клиент получает ping, но не отвечает pong
+	pings := 0
+	conn.SetPingHandler(func(string) error {
+		pings++
+		return nil
+	})
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(wsTestTimeout))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("ожидалось закрытие соединения сервером, получено %v", err)
+	}
+	if pings == 0 {
+		t.Error("сервер не отправлял ping")
+	}
+	if elapsed := time.Since(start); elapsed < h.hub.config.PongTimeout/2 {
+		t.Errorf("клиент отключён через %s, раньше ожидания pong", elapsed)
+	}
+	// Хаб отписывает клиента до закрытия очереди, которое закрывает соединение
+	if n := len(subscribers(h.hub, "thread:1")); n != 0 {
+		t.Errorf("клиент без pong остался в хабе (подписчиков: %d)", n)
+	}
+}
+
+func TestWSKeepsPeerAnsweringPings(t *testing.T) {
+	h := newShortWSHandler(t)
+	conn := dialWS(t, newWSServer(t, h), "/ws?topics=thread:1")
+	if reply := sendControl(t, conn, "subscribe"); reply.Type != "subscribed" {
+		t.Fatalf("ответ на подписку: %+v", reply)
+	}
+
+	// Клиент отвечает pong; после нескольких PongTimeout сервер присылает
+	// событие - соединение к этому моменту должно быть живо
+	wantPings := int(3 * h.hub.config.PongTimeout / h.hub.config.PingInterval)
+	pings := 0
+	conn.SetPingHandler(func(data string) error {
+		pings++
+		if pings == wantPings {
+			h.hub.BroadcastToThread(1, WSMessage{Type: "new_post"})
+		}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	if msg := readWS(t, conn); msg.Type != "new_post" {
+		t.Fatalf("неожиданное сообщение: %+v", msg)
+	}
+	if n := len(subscribers(h.hub, "thread:1")); n != 1 {
+		t.Errorf("подписчиков: %d, клиент с pong отключён", n)
+	}
+}
+
+func TestWSMaxMessageSize(t *testing.T) {
+	h := newShortWSHandler(t)
+	conn := dialWS(t, newWSServer(t, h), "/ws")
+
+	// Сообщение в пределах MaxMessageSize обрабатывается
+	if reply := sendControl(t, conn, "subscribe", "thread:1"); reply.Type != "subscribed" {
+		t.Fatalf("ответ на подписку: %+v", reply)
+	}
+
+	big := `{"action":"subscribe","topics":["` + strings.Repeat("x", int(h.hub.config.MaxMessageSize)) + `"]}`
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(big)); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(wsTestTimeout))
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+			t.Errorf("ожидалось закрытие с кодом 1009, получено %v", err)
+		}
+		break
+	}
+}
+
+func TestWSConfigNormalize(t *testing.T) {
+	def := DefaultWSConfig()
+	tests := []struct {
+		name string
+		in   WSConfig
+		want WSConfig
+	}{
+		{"нулевая", WSConfig{}, def},
+		{"отрицательная", WSConfig{PingInterval: -1, PongTimeout: -time.Second, WriteTimeout: -1, MaxMessageSize: -1}, def},
+		{
+			"частично заданная",
+			WSConfig{PongTimeout: 2 * time.Minute, MaxMessageSize: 100},
+			WSConfig{PingInterval: def.PingInterval, PongTimeout: 2 * time.Minute, WriteTimeout: def.WriteTimeout, MaxMessageSize: 100},
+		},
+		{
+			"ping не чаще ожидания pong",
+			WSConfig{PingInterval: time.Minute, PongTimeout: 10 * time.Second, WriteTimeout: time.Second, MaxMessageSize: 1},
+			WSConfig{PingInterval: 9 * time.Second, PongTimeout: 10 * time.Second, WriteTimeout: time.Second, MaxMessageSize: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.in.normalize(); got != tt.want {
+				t.Errorf("normalize() = %+v, ожидалось %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWSSubscribeTopics(t *testing.T) {
+	h := newTestHandler(t)
+	conn := dialWS(t, newWSServer(t, h), "/ws")
+
+	reply := sendControl(t, conn, "subscribe", "thread:1", "board:b", "home")
+	if reply.Type != "subscribed" {
+		t.Fatalf("ответ на подписку: %+v", reply)
+	}
+
+	h.hub.BroadcastToThread(1, WSMessage{Type: "new_post"})
+	h.hub.BroadcastToBoard("b", WSMessage{Type: "new_thread"})
+	h.hub.BroadcastToHome(WSMessage{Type: "new_board"})
+	for _, want := range []string{"thread:1", "board:b", "home"} {
+		if msg := readWS(t, conn); msg.Topic != want {
+			t.Errorf("событие темы %q, ожидалась %q", msg.Topic, want)
+		}
+	}
+
+	// После отписки события доски не приходят: следующим приходит событие
+	// главной страницы, отправленное позже
+	if reply := sendControl(t, conn, "unsubscribe", "board:b"); reply.Type != "unsubscribed" {
+		t.Fatalf("ответ на отписку: %+v", reply)
+	}
+	h.hub.BroadcastToBoard("b", WSMessage{Type: "new_thread"})
+	h.hub.BroadcastToHome(WSMessage{Type: "new_board"})
+	if msg := readWS(t, conn); msg.Topic != "home" {
+		t.Errorf("после отписки пришло событие %q", msg.Topic)
+	}
+}
+
+func TestWSControlErrors(t *testing.T) {
+	h := newTestHandler(t)
+	conn := dialWS(t, newWSServer(t, h), "/ws")
+
+	for _, topic := range []string{"thread:abc", "thread:0", "thread:01", "board:B", "board:", "news"} {
+		if reply := sendControl(t, conn, "subscribe", topic); reply.Type != "error" {
+			t.Errorf("подписка на %q: ответ %+v, ожидалась ошибка", topic, reply)
+		}
+	}
+	if len(subscribers(h.hub, "thread:abc")) != 0 {
+		t.Error("подписка на неверную тему выполнена")
+	}
+
+	if reply := sendControl(t, conn, "publish", "home"); reply.Type != "error" {
+		t.Errorf("неизвестное действие: ответ %+v", reply)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+	if reply := readWS(t, conn); reply.Type != "error" {
+		t.Errorf("неверный JSON: ответ %+v", reply)
+	}
+}
+
+func TestWSMaxClientTopics(t *testing.T) {
+	h := newTestHandler(t)
+	conn := dialWS(t, newWSServer(t, h), "/ws")
+
+	topics := make([]string, maxClientTopics)
+	for i := range topics {
+		topics[i] = ThreadTopic(i + 1)
+	}
+	if reply := sendControl(t, conn, "subscribe", topics...); reply.Type != "subscribed" {
+		t.Fatalf("подписка на %d тем: %+v", maxClientTopics, reply)
+	}
+
+	extra := ThreadTopic(maxClientTopics + 1)
+	if reply := sendControl(t, conn, "subscribe", extra); reply.Type != "error" {
+		t.Errorf("подписка сверх %d тем: ответ %+v", maxClientTopics, reply)
+	}
+	if len(subscribers(h.hub, extra)) != 0 {
+		t.Errorf("подписка на %s выполнена", extra)
+	}
+
+	// Повторная подписка на ту же тему не считается новой
+	if reply := sendControl(t, conn, "subscribe", topics[0]); reply.Type != "subscribed" {
+		t.Errorf("повторная подписка: %+v", reply)
+	}
+}
+
+func TestWSLegacyEndpoints(t *testing.T) {
+	h := newTestHandler(t)
+	srv := newWSServer(t, h)
+
+	tests := []struct {
+		path  string
+		topic string
+	}{
+		{"/ws/thread?thread_id=5", "thread:5"},
+		{"/ws/board?board_id=b", "board:b"},
+		{"/ws/home", "home"},
+		{"/ws?topics=thread:5,board:b", "board:b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			conn := dialWS(t, srv, tt.path)
+			if reply := sendControl(t, conn, "subscribe"); reply.Type != "subscribed" {
+				t.Fatalf("ответ: %+v", reply)
+			}
+
+			// Событие чужой темы не приходит: первым приходит событие своей
+			other := "board:other"
+			h.hub.Broadcast(other, WSMessage{Type: "new_thread"})
+			h.hub.Broadcast(tt.topic, WSMessage{Type: "new_post"})
+			if msg := readWS(t, conn); msg.Topic != tt.topic {
+				t.Errorf("событие темы %q, ожидалась %q", msg.Topic, tt.topic)
+			}
+		})
+	}
+
+	// Неверные параметры отклоняются до установки соединения
+	for _, path := range []string{"/ws/thread", "/ws/thread?thread_id=x", "/ws/board?board_id=B!", "/ws?topics=news"} {
+		url := "ws" + strings.TrimPrefix(srv.URL, "http") + path
+		_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: ожидался ответ 400, получено %v", path, err)
+		}
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 
 	"webForum/database"
 	"webForum/handlers"
+	"webForum/storage"
 )
 
 func main() {
@@ -18,24 +21,65 @@ func main() {
 	}
 	// Конфигурация БД(можно вынести в переменные окружения)
 	dbConfig := database.Config{
+		Driver:   getEnv("DB_DRIVER", database.DriverMySQL),
 		Host:     getEnv("DB_HOST", "localhost"),
 		Port:     getEnv("DB_PORT", "3306"),
 		User:     getEnv("DB_USER", "root"),
 		Password: getEnv("DB_PASSWORD", ""),
 		DBName:   getEnv("DB_NAME", "webforum"),
+		Path:     getEnv("DB_PATH", "webforum.db"),
 	}
 
-	// Подключение к MySQL
-	if err := database.Connect(dbConfig); err != nil {
+	// Подключение к БД (MySQL или SQLite)
+	store, err := database.Connect(dbConfig)
+	if err != nil {
 		log.Fatal("Ошибка подключения к БД: ", err)
 	}
-	defer database.Close()
+	defer store.Close()
+
+	// Подкоманда: webForum migrate up|down|status
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(store, os.Args[2:]); err != nil {
+			log.Fatal("Ошибка миграции: ", err)
+		}
+		return
+	}
 
-	// Создание таблиц
-	if err := database.InitSchema(); err != nil {
+	// Применение миграций схемы: и сервер, и media gc работают с таблицами
+	// media и upload_tokens последней версии
+	if err := store.InitSchema(); err != nil {
 		log.Fatal("Ошибка инициализации схемы: ", err)
 	}
 
+	// Хранилище загруженных файлов: локальный каталог или S3-совместимое
+	media, err := storage.New(storage.Config{
+		Backend: getEnv("MEDIA_BACKEND", storage.BackendLocal),
+		Dir:     getEnv("MEDIA_DIR", "uploads"),
+		S3: storage.S3Config{
+			Endpoint:   getEnv("S3_ENDPOINT", ""),
+			Region:     getEnv("S3_REGION", "us-east-1"),
+			Bucket:     getEnv("S3_BUCKET", ""),
+			AccessKey:  getEnv("S3_ACCESS_KEY", ""),
+			SecretKey:  getEnv("S3_SECRET_KEY", ""),
+			PathStyle:  getEnv("S3_PATH_STYLE", "true") == "true",
+			Presign:    getEnv("S3_READ_MODE", "proxy") == "presign",
+			PresignTTL: getEnvDuration("S3_PRESIGN_TTL", 15*time.Minute),
+		},
+	})
+	if err != nil {
+		log.Fatal("Ошибка настройки хранилища медиа: ", err)
+	}
+	log.Printf("Хранилище медиа: %s", getEnv("MEDIA_BACKEND", storage.BackendLocal))
+
+	// Подкоманда: webForum media gc [-grace 24h] [-dry-run]
+	mediaGCGrace := getEnvDuration("MEDIA_GC_GRACE", 24*time.Hour)
+	if len(os.Args) > 1 && os.Args[1] == "media" {
+		if err := runMedia(store, media, mediaGCGrace, os.Args[2:]); err != nil {
+			log.Fatal("Ошибка обслуживания медиа: ", err)
+		}
+		return
+	}
+
 	// Настройка маршрутизатора
 	mux := http.NewServeMux()
 
@@ -43,12 +87,26 @@ func main() {
 	fs := http.FileServer(http.Dir("./static"))
 	mux.Handle("/static/", http.StripPrefix("/static/", fs))
 
-	// Загруженные файлы пользователей
-	imgFs := http.FileServer(http.Dir("./uploads"))
-	mux.Handle("/uploads/", http.StripPrefix("/uploads/", imgFs))
+	// Загруженные файлы пользователей: из хранилища (прокси) или редиректом
+	// на подписанную ссылку S3. Content-Type - тип, определённый по
+	// содержимому при загрузке, и X-Content-Type-Options: nosniff
+	mux.Handle("/uploads/", http.StripPrefix("/uploads/", handlers.UploadsHandler(store, media)))
 
 	// Инициализация обработчиков
-	h := handlers.NewHandler()
+	h := handlers.NewHandler(store, media, handlers.WSConfig{
+		PingInterval:   getEnvDuration("WS_PING_INTERVAL", 30*time.Second),
+		PongTimeout:    getEnvDuration("WS_PONG_TIMEOUT", 60*time.Second),
+		WriteTimeout:   getEnvDuration("WS_WRITE_TIMEOUT", 10*time.Second),
+		MaxMessageSize: getEnvInt64("WS_MAX_MESSAGE_SIZE", 4096),
+	})
+
+	// Удаление брошенных возобновляемых загрузок
+	go h.ExpireUploadSessions(10 * time.Minute)
+
+	// Удаление файлов, которые так и не прикрепили к посту
+	if interval := getEnvDuration("MEDIA_GC_INTERVAL", 6*time.Hour); interval > 0 {
+		go h.SweepOrphanedMedia(interval, mediaGCGrace)
+	}
 
 	// === СТРАНИЦЫ ===
 	// Главная страница - список всех досок
@@ -56,12 +114,17 @@ func main() {
 
 	// Страница доски - список тредов
 	// GET /board/{id}?sort=bump|new|old|replies
+	// GET /board/{id}/catalog?sort=bump|new|old|replies - каталог
 	mux.HandleFunc("/board/", h.BoardHandler)
 
 	// Страница треда - список комментариев
 	// GET /thread/{id}
 	mux.HandleFunc("/thread/", h.ThreadHandler)
 
+	// Страница поиска
+	// GET /search?q=&board=&author=&has_media=
+	mux.HandleFunc("/search", h.SearchHandler)
+
 	// === API (POST запросы) ===
 	// Создание новой доски
 	// POST /api/board  {id, name, description}
@@ -76,24 +139,41 @@ func main() {
 	mux.HandleFunc("/api/post", h.CreatePostHandler)
 
 	// === WebSocket ===
-	mux.HandleFunc("/ws/thread", handlers.WebSocketThreadHandler)
-	mux.HandleFunc("/ws/board", handlers.WebSocketBoardHandler)
-	mux.HandleFunc("/ws/home", handlers.WebSocketHomeHandler)
+	// Одно соединение на любые темы (thread:{id}, board:{id}, home),
+	// подписки меняются сообщениями subscribe/unsubscribe
+	mux.HandleFunc("/ws", h.WebSocketHandler)
+	// Совместимость: соединение с одной темой
+	mux.HandleFunc("/ws/thread", h.WebSocketThreadHandler)
+	mux.HandleFunc("/ws/board", h.WebSocketBoardHandler)
+	mux.HandleFunc("/ws/home", h.WebSocketHomeHandler)
+
+	// === Server-Sent Events ===
+	// Те же события потоком text/event-stream для клиентов, у которых
+	// прокси не пропускает WebSocket; поддерживается Last-Event-ID
+	mux.HandleFunc("/sse/thread", h.SSEThreadHandler)
+	mux.HandleFunc("/sse/board", h.SSEBoardHandler)
+	mux.HandleFunc("/sse/home", h.SSEHomeHandler)
 
 	// === REST API v1 для мобильных приложений ===
 	// Доски
-	mux.HandleFunc("/api/v1/boards", handlers.APIGetBoards)     // GET - список досок, POST - создать
-	mux.HandleFunc("/api/v1/boards/", handlers.APIBoardsRouter) // GET /api/v1/boards/{id} или /api/v1/boards/{id}/threads
+	mux.HandleFunc("/api/v1/boards", h.APIGetBoards)     // GET - список досок, POST - создать
+	mux.HandleFunc("/api/v1/boards/", h.APIBoardsRouter) // GET /api/v1/boards/{id}, /{id}/threads или /{id}/catalog
 
 	// Треды
-	mux.HandleFunc("/api/v1/threads", handlers.APICreateThread) // POST - создать тред
-	mux.HandleFunc("/api/v1/threads/", handlers.APIGetThread)   // GET /api/v1/threads/{id}
+	mux.HandleFunc("/api/v1/threads", h.APICreateThread) // POST - создать тред
+	mux.HandleFunc("/api/v1/threads/", h.APIGetThread)   // GET /api/v1/threads/{id}
 
 	// Посты
-	mux.HandleFunc("/api/v1/posts", handlers.APICreatePost) // POST - создать пост
+	mux.HandleFunc("/api/v1/posts", h.APICreatePost) // POST - создать пост
 
 	// Загрузка медиа
 	mux.HandleFunc("/api/v1/upload", h.APIUploadMedia) // POST - загрузить файл
+	mux.HandleFunc("/api/v1/uploads", h.APIUploads)    // возобновляемые загрузки (tus)
+	mux.HandleFunc("/api/v1/uploads/", h.APIUploads)   // /api/v1/uploads/{id}[/finalize]
+	mux.HandleFunc("/api/v1/media/", h.APIGetMedia)    // GET /api/v1/media/{sha256}
+
+	// Поиск
+	mux.HandleFunc("/api/v1/search", h.APISearch) // GET ?q=&board=&author=&has_media=
 
 	// Запуск сервера
 	port := getEnv("PORT", ":8080")
@@ -106,21 +186,36 @@ func main() {
 	log.Println("=== WEB ===")
 	log.Println("  GET  /              - Главная страница")
 	log.Println("  GET  /board/{id}    - Страница доски")
+	log.Println("  GET  /board/{id}/catalog - Каталог доски")
 	log.Println("  GET  /thread/{id}   - Страница треда")
+	log.Println("  GET  /search?q=     - Поиск")
 	log.Println("")
 	log.Println("=== REST API v1 ===")
 	log.Println("  GET    /api/v1/boards              - Список досок")
 	log.Println("  POST   /api/v1/boards              - Создать доску")
 	log.Println("  GET    /api/v1/boards/{id}         - Получить доску")
+	log.Println("  PATCH  /api/v1/boards/{id}         - Изменить настройки доски")
 	log.Println("  GET    /api/v1/boards/{id}/threads - Получить треды доски")
+	log.Println("  GET    /api/v1/boards/{id}/catalog - Каталог доски")
 	log.Println("  GET    /api/v1/threads/{id}        - Получить тред с постами")
 	log.Println("  POST   /api/v1/threads             - Создать тред")
 	log.Println("  POST   /api/v1/posts               - Создать пост")
 	log.Println("  POST   /api/v1/upload              - Загрузить медиафайл")
+	log.Println("  POST   /api/v1/uploads             - Начать возобновляемую загрузку (tus)")
+	log.Println("  PATCH  /api/v1/uploads/{id}        - Дописать кусок файла")
+	log.Println("  POST   /api/v1/uploads/{id}/finalize - Завершить загрузку")
+	log.Println("  GET    /api/v1/media/{hash}        - Медиафайл по SHA-256")
+	log.Println("  GET    /api/v1/search?q=           - Полнотекстовый поиск")
 	log.Println("")
 	log.Println("=== WebSocket ===")
+	log.Println("  WS /ws?topics=thread:{id},board:{id} - Live обновления по подпискам")
 	log.Println("  WS /ws/thread?thread_id={id}       - Live обновления треда")
 	log.Println("  WS /ws/board?board_id={id}         - Live обновления доски")
+	log.Println("")
+	log.Println("=== Server-Sent Events ===")
+	log.Println("  GET /sse/thread?thread_id={id}     - Live обновления треда")
+	log.Println("  GET /sse/board?board_id={id}       - Live обновления доски")
+	log.Println("  GET /sse/home                      - Live обновления главной")
 
 	if err := http.ListenAndServe(port, mux); err != nil {
 		log.Fatal("Ошибка запуска сервера: ", err)
@@ -134,3 +229,27 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvInt64 целое число из переменной окружения
+func getEnvInt64(key string, defaultValue int64) int64 {
+	if value := os.Getenv(key); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			log.Fatalf("Неверное значение %s: %v", key, err)
+		}
+		return n
+	}
+	return defaultValue
+}
+
+// getEnvDuration длительность из переменной окружения (формат time.ParseDuration)
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Неверное значение %s: %v", key, err)
+		}
+		return d
+	}
+	return defaultValue
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"webForum/database"
+	"webForum/handlers"
+	"webForum/storage"
+)
+
+// runMedia выполняет подкоманду `webForum media gc [-grace 24h] [-dry-run]`
+func runMedia(store database.Store, media storage.MediaStore, grace time.Duration, args []string) error {
+	if len(args) == 0 || args[0] != "gc" {
+		return fmt.Errorf("использование: webForum media gc [-grace 24h] [-dry-run]")
+	}
+
+	fs := flag.NewFlagSet("media gc", flag.ContinueOnError)
+	fs.DurationVar(&grace, "grace", grace, "не трогать файлы моложе этого срока")
+	dryRun := fs.Bool("dry-run", false, "только показать, что будет удалено")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	report, err := handlers.CollectGarbage(store, media, grace, *dryRun)
+	if report != nil {
+		for _, f := range report.Orphans {
+			fmt.Printf("%12d  %s  %s\n", f.Size, f.ModTime.Format("02.01.2006 15:04:05"), f.Key)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Проверено файлов: %d\n", report.Scanned)
+	if *dryRun {
+		fmt.Printf("Будет удалено файлов: %d (%d байт) — пробный запуск, ничего не удалено\n",
+			len(report.Orphans), report.Bytes)
+		return nil
+	}
+	fmt.Printf("Удалено файлов: %d (%d байт), записей media: %d\n",
+		report.Deleted, report.Bytes, report.Records)
+	return nil
+}
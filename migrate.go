@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"webForum/database"
+)
+
+// runMigrate выполняет подкоманду `webForum migrate up|down|status [n]`
+func runMigrate(store *database.SQLStore, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("использование: webForum migrate up|down|status [n]")
+	}
+
+	n := 0
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 0 {
+			return fmt.Errorf("неверное количество миграций: %s", args[1])
+		}
+	}
+
+	switch args[0] {
+	case "up":
+		count, err := store.MigrateUp(n)
+		fmt.Printf("Применено миграций: %d\n", count)
+		return err
+
+	case "down":
+		count, err := store.MigrateDown(n)
+		fmt.Printf("Откачено миграций: %d\n", count)
+		return err
+
+	case "status":
+		statuses, err := store.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			state := "ожидает"
+			if st.Applied {
+				state = "применена " + st.AppliedAt.Format("02.01.2006 15:04:05")
+			}
+			fmt.Printf("%4d  %-30s %s\n", st.Version, st.Name, state)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("неизвестная команда migrate: %s", args[0])
+	}
+}
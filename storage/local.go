@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore хранит файлы в каталоге на локальном диске
+type LocalStore struct {
+	dir string
+}
+
+var _ MediaStore = (*LocalStore)(nil)
+
+// NewLocalStore создаёт хранилище в каталоге dir (по умолчанию uploads)
+func NewLocalStore(dir string) *LocalStore {
+	if dir == "" {
+		dir = "uploads"
+	}
+	return &LocalStore{dir: dir}
+}
+
+// path путь к файлу на диске
+func (s *LocalStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+// Put записывает файл во временный рядом с целевым и переименовывает,
+// поэтому недописанный файл никогда не виден под ключом
+func (s *LocalStore) Put(key string, r io.Reader, size int64, contentType string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Имя с точкой в начале: UploadsHandler такие файлы не отдаёт
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Open открывает файл; Body - *os.File
+func (s *LocalStore) Open(key string) (*Object, error) {
+	if err := validKey(key); err != nil {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, ErrNotFound
+	}
+	return &Object{Body: f, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Delete удаляет файл
+func (s *LocalStore) Delete(key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// PresignGet локальные файлы отдаются только через сервер
+func (s *LocalStore) PresignGet(key string) (string, error) {
+	return "", nil
+}
+
+// List обходит каталог хранилища, пропуская скрытые файлы и каталоги
+func (s *LocalStore) List(fn func(ObjectInfo) error) error {
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == s.dir {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		return fn(ObjectInfo{Key: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
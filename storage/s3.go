@@ -0,0 +1,419 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config настройки S3-совместимого хранилища
+type S3Config struct {
+	Endpoint  string // https://s3.eu-central-1.amazonaws.com, http://localhost:9000 (MinIO)
+	Region    string // по умолчанию us-east-1
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// PathStyle адресация http://endpoint/bucket/key вместо
+	// http://bucket.endpoint/key. Нужна для MinIO и большинства
+	// самостоятельно развёрнутых хранилищ.
+	PathStyle bool
+
+	// Presign отдавать файлы редиректом на подписанную ссылку вместо
+	// проксирования через сервер
+	Presign    bool
+	PresignTTL time.Duration // срок действия ссылки, по умолчанию 15 минут
+}
+
+// S3Store хранит файлы в бакете S3-совместимого хранилища.
+// Запросы подписываются AWS Signature Version 4.
+type S3Store struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+var _ MediaStore = (*S3Store)(nil)
+
+// s3ResponseTimeout сколько ждать заголовков ответа хранилища после
+// отправки запроса. Общего таймаута у клиента нет: он оборвал бы долгую
+// отдачу видео, которое проксируется через сервер.
+const s3ResponseTimeout = time.Minute
+
+// unsignedPayload тело запроса не входит в подпись: файлы передаются
+// потоком и заранее не хешируются
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// NewS3Store создаёт хранилище по настройкам cfg
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("для хранилища s3 нужны endpoint и bucket")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("для хранилища s3 нужны ключи доступа")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("некорректный endpoint s3: %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.PresignTTL <= 0 {
+		cfg.PresignTTL = 15 * time.Minute
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = s3ResponseTimeout
+	return &S3Store{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Transport: transport},
+	}, nil
+}
+
+// objectURL адрес объекта с ключом key
+func (s *S3Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+	if s.cfg.PathStyle {
+		u.Path = base + "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = base + "/" + key
+	}
+	u.RawPath = ""
+	u.RawQuery = ""
+	return &u
+}
+
+// do подписывает и выполняет запрос к объекту key
+func (s *S3Store) do(method, key string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	return s.doURL(method, s.objectURL(key), body, size, header)
+}
+
+// doURL подписывает и выполняет запрос по адресу u
+func (s *S3Store) doURL(method string, u *url.URL, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, time.Now())
+	return s.client.Do(req)
+}
+
+// s3Error ошибка из ответа хранилища
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	var code string
+	if i := strings.Index(string(body), "<Code>"); i >= 0 {
+		code = string(body[i+len("<Code>"):])
+		if j := strings.Index(code, "<"); j >= 0 {
+			code = code[:j]
+		}
+	}
+	if code == "" {
+		return fmt.Errorf("s3: %s", resp.Status)
+	}
+	return fmt.Errorf("s3: %s (%s)", resp.Status, code)
+}
+
+// Put загружает объект одним запросом PUT. S3 сохраняет объект
+// атомарно, поэтому частично записанный файл не виден.
+func (s *S3Store) Put(key string, r io.Reader, size int64, contentType string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if size == 0 {
+		// Нулевой ContentLength с непустым телом net/http считает неизвестной длиной
+		r = http.NoBody
+	}
+	resp, err := s.do(http.MethodPut, key, r, size, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// Open начинает скачивание объекта; тело читается из ответа хранилища
+func (s *S3Store) Open(key string) (*Object, error) {
+	if err := validKey(key); err != nil {
+		return nil, ErrNotFound
+	}
+	resp, err := s.do(http.MethodGet, key, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, s3Error(resp)
+	}
+
+	obj := &Object{Body: resp.Body, Size: resp.ContentLength}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		obj.ModTime = t
+	}
+	return obj, nil
+}
+
+// Delete удаляет объект. S3 отвечает 204 и на удаление несуществующего.
+func (s *S3Store) Delete(key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodDelete, key, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK &&
+		resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// PresignGet подписанная ссылка на объект, если включён режим Presign
+func (s *S3Store) PresignGet(key string) (string, error) {
+	if !s.cfg.Presign {
+		return "", nil
+	}
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return s.presign(http.MethodGet, s.objectURL(key), s.cfg.PresignTTL, time.Now()), nil
+}
+
+// listResult ответ ListObjectsV2
+type listResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+// List перебирает объекты бакета страницами по 1000 (ListObjectsV2).
+// Ключи, которые не могли быть созданы через Put, пропускаются.
+func (s *S3Store) List(fn func(ObjectInfo) error) error {
+	token := ""
+	for {
+		u := s.objectURL("")
+		query := url.Values{"list-type": {"2"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = query.Encode()
+
+		resp, err := s.doURL(http.MethodGet, u, nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp)
+			resp.Body.Close()
+			return err
+		}
+		var page listResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("s3: разбор списка объектов: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			if validKey(obj.Key) != nil {
+				continue
+			}
+			if err := fn(ObjectInfo{Key: obj.Key, Size: obj.Size, ModTime: obj.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// AWS Signature Version 4
+
+const (
+	sigAlgorithm  = "AWS4-HMAC-SHA256"
+	amzDateFormat = "20060102T150405Z"
+)
+
+// sign добавляет к запросу заголовки X-Amz-Date, X-Amz-Content-Sha256
+// и Authorization
+func (s *S3Store) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	}
+
+	headers, signed := canonicalHeaders(req)
+	canonical := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		canonicalQuery(req.URL.Query()),
+		headers,
+		signed,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := s.scope(now)
+	signature := s.signature(now, scope, canonical)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigAlgorithm, s.cfg.AccessKey, scope, signed, signature))
+}
+
+// presign ссылка с подписью в параметрах запроса (подписывается только Host)
+func (s *S3Store) presign(method string, u *url.URL, ttl time.Duration, now time.Time) string {
+	now = now.UTC()
+	scope := s.scope(now)
+
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", sigAlgorithm)
+	query.Set("X-Amz-Credential", s.cfg.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", now.Format(amzDateFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(ttl/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonical := strings.Join([]string{
+		method,
+		canonicalURI(u),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	signed := *u
+	signed.RawQuery = canonicalQuery(query) + "&X-Amz-Signature=" + s.signature(now, scope, canonical)
+	return signed.String()
+}
+
+// scope область действия подписи: дата/регион/s3/aws4_request
+func (s *S3Store) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+// signature HMAC строки для подписи ключом, выведенным из секретного
+func (s *S3Store) signature(now time.Time, scope, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := sigAlgorithm + "\n" + now.Format(amzDateFormat) + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalHeaders подписываемые заголовки: Host, X-Amz-*, Content-Type,
+// Content-Md5 и Range. Возвращает их каноническую запись и список имён.
+func canonicalHeaders(req *http.Request) (string, string) {
+	values := map[string]string{"host": req.URL.Host}
+	for name, v := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" ||
+			lower == "content-md5" || lower == "range" {
+			values[lower] = strings.Join(strings.Fields(strings.Join(v, ",")), " ")
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + values[name] + "\n")
+	}
+	return b.String(), strings.Join(names, ";")
+}
+
+// canonicalURI путь с кодированием каждого сегмента по RFC 3986
+func canonicalURI(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	parts := strings.Split(u.Path, "/")
+	for i, part := range parts {
+		parts[i] = uriEncode(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// canonicalQuery параметры, отсортированные по имени, в кодировке RFC 3986
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		vals := append([]string(nil), query[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			pairs = append(pairs, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode кодирует всё, кроме A-Z a-z 0-9 - _ . ~
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
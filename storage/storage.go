@@ -0,0 +1,89 @@
+// Package storage хранилища загруженных медиафайлов: локальный диск и
+// S3-совместимые сервисы (AWS S3, MinIO, Ceph RGW и т.п.).
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Бэкенды хранилища
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// ErrNotFound файла с таким ключом нет
+var ErrNotFound = errors.New("файл не найден")
+
+// MediaStore хранилище медиафайлов. Ключ - относительный путь вида
+// ab/cd/{sha256}.jpg; публичный адрес файла - /uploads/{ключ}.
+type MediaStore interface {
+	// Put сохраняет size байт из r под ключом key. Существующий файл
+	// перезаписывается; читатели видят либо старое, либо новое содержимое.
+	Put(key string, r io.Reader, size int64, contentType string) error
+
+	// Open открывает файл для чтения. Если файла нет, возвращает ErrNotFound.
+	Open(key string) (*Object, error)
+
+	// Delete удаляет файл; отсутствие файла ошибкой не считается
+	Delete(key string) error
+
+	// PresignGet подписанная ссылка для чтения напрямую из хранилища.
+	// Пустая строка - файлы отдаются через сервер (Open).
+	PresignGet(key string) (string, error)
+
+	// List вызывает fn для каждого файла хранилища, кроме служебных
+	// (с точкой в начале имени). Ошибка fn прерывает обход.
+	List(fn func(ObjectInfo) error) error
+}
+
+// ObjectInfo файл хранилища при обходе
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Object открытый для чтения файл
+type Object struct {
+	Body    io.ReadCloser // *os.File для локального хранилища (поддерживает Seek)
+	Size    int64
+	ModTime time.Time
+}
+
+// Config конфигурация хранилища
+type Config struct {
+	Backend string // local (по умолчанию) или s3
+	Dir     string // каталог для local
+	S3      S3Config
+}
+
+// New создаёт хранилище по конфигурации
+func New(cfg Config) (MediaStore, error) {
+	switch cfg.Backend {
+	case "", BackendLocal:
+		return NewLocalStore(cfg.Dir), nil
+	case BackendS3:
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("неизвестное хранилище медиа: %s", cfg.Backend)
+	}
+}
+
+// validKey проверяет ключ: относительный путь без пустых сегментов,
+// «..» и скрытых (начинающихся с точки) имён
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("недопустимый ключ: %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || strings.HasPrefix(part, ".") || strings.Contains(part, `\`) {
+			return fmt.Errorf("недопустимый ключ: %q", key)
+		}
+	}
+	return nil
+}